@@ -48,6 +48,10 @@ func (this *GatewayAPI) RegisterSchema() {
 	if err != nil {
 		panic(err)
 	}
+	err = orm.RegisterSchemaWithIndexName(common.APIKey{}, "api_key")
+	if err != nil {
+		panic(err)
+	}
 
 }
 
@@ -77,4 +81,10 @@ func (this *GatewayAPI) RegisterAPI(prefix string) {
 	api.HandleAPIMethod(api.DELETE, path.Join("/", prefix, "/flow/:flow_id"), this.deleteFlow)
 	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/flow/_search"), this.searchFlow)
 
+	api.HandleAPIMethod(api.POST, path.Join("/", prefix, "/api_key"), this.createAPIKey)
+	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/api_key/:api_key_id"), this.getAPIKey)
+	api.HandleAPIMethod(api.PUT, path.Join("/", prefix, "/api_key/:api_key_id"), this.updateAPIKey)
+	api.HandleAPIMethod(api.DELETE, path.Join("/", prefix, "/api_key/:api_key_id"), this.deleteAPIKey)
+	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/api_key/_search"), this.searchAPIKey)
+
 }
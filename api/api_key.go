@@ -0,0 +1,251 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+	"infini.sh/gateway/common"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the fields absent in the request are left unchanged by the updates,
+// set `expiration` to "" or `rate_limit` to null to remove them
+type apiKeyRequest struct {
+	Name        *string         `json:"name"`
+	Owner       *string         `json:"owner"`
+	Roles       *[]string       `json:"roles"`
+	Expiration  *string         `json:"expiration"`
+	RateLimit   json.RawMessage `json:"rate_limit"`
+	Invalidated *bool           `json:"invalidated"`
+}
+
+func (r *apiKeyRequest) apply(obj *common.APIKey) error {
+	if r.Name != nil {
+		obj.Name = *r.Name
+	}
+	if r.Owner != nil {
+		obj.Owner = *r.Owner
+	}
+	if r.Roles != nil {
+		obj.Roles = *r.Roles
+	}
+	if r.Invalidated != nil {
+		obj.Invalidated = *r.Invalidated
+	}
+	if r.RateLimit != nil {
+		var rateLimit *common.APIKeyRateLimit
+		if err := json.Unmarshal(r.RateLimit, &rateLimit); err != nil {
+			return fmt.Errorf("invalid rate_limit: %v", err)
+		}
+		obj.RateLimit = rateLimit
+	}
+	if r.Expiration != nil {
+		obj.Expiration = nil
+		if *r.Expiration != "" {
+			duration, err := time.ParseDuration(*r.Expiration)
+			if err != nil {
+				return fmt.Errorf("invalid expiration [%v]: %v", *r.Expiration, err)
+			}
+			t := time.Now().Add(duration)
+			obj.Expiration = &t
+		}
+	}
+	return nil
+}
+
+func (h *GatewayAPI) createAPIKey(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var r = &apiKeyRequest{}
+	err := h.DecodeJSON(req, r)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Name == nil || *r.Name == "" {
+		h.WriteError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	obj := &common.APIKey{}
+	if err = r.apply(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj.ID = util.GetUUID()
+	key := obj.GenerateAPIKey()
+
+	err = orm.Create(nil, obj)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//the key is only returned once, it can't be retrieved later
+	h.WriteJSON(w, util.MapStr{
+		"_id":        obj.ID,
+		"result":     "created",
+		"name":       obj.Name,
+		"api_key":    key,
+		"encoded":    common.EncodeAPIKey(obj.ID, key),
+		"expiration": obj.Expiration,
+	}, 200)
+
+}
+
+func (h *GatewayAPI) getAPIKey(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("api_key_id")
+
+	obj := common.APIKey{}
+	obj.ID = id
+
+	exists, err := orm.Get(&obj)
+	if !exists || err != nil {
+		h.WriteJSON(w, util.MapStr{
+			"_id":   id,
+			"found": false,
+		}, http.StatusNotFound)
+		return
+	}
+
+	//protect
+	obj.Hash = ""
+	obj.Salt = ""
+
+	h.WriteJSON(w, util.MapStr{
+		"found":   true,
+		"_id":     id,
+		"_source": obj,
+	}, 200)
+}
+
+func (h *GatewayAPI) updateAPIKey(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("api_key_id")
+	obj := common.APIKey{}
+
+	obj.ID = id
+	exists, err := orm.Get(&obj)
+	if !exists || err != nil {
+		h.WriteJSON(w, util.MapStr{
+			"_id":    id,
+			"result": "not_found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	var r = &apiKeyRequest{}
+	err = h.DecodeJSON(req, r)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//the hash and the creation time are kept
+	if err = r.apply(&obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.Update(nil, &obj)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	common.InvalidateAPIKeyCache(id)
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    obj.ID,
+		"result": "updated",
+	}, 200)
+}
+
+func (h *GatewayAPI) deleteAPIKey(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("api_key_id")
+
+	obj := common.APIKey{}
+	obj.ID = id
+
+	exists, err := orm.Get(&obj)
+	if !exists || err != nil {
+		h.WriteJSON(w, util.MapStr{
+			"_id":    id,
+			"result": "not_found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	err = orm.Delete(nil, &obj)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	common.InvalidateAPIKeyCache(id)
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    obj.ID,
+		"result": "deleted",
+	}, 200)
+}
+
+func (h *GatewayAPI) searchAPIKey(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var (
+		name     = h.GetParameterOrDefault(req, "name", "")
+		owner    = h.GetParameterOrDefault(req, "owner", "")
+		queryDSL = `{"query":{"bool":{"must":[%s]}}, "_source": {"excludes": ["hash", "salt"]}, "size": %d, "from": %d}`
+		size     = h.GetIntOrDefault(req, "size", 20)
+		from     = h.GetIntOrDefault(req, "from", 0)
+		must     = []string{}
+	)
+	if name != "" {
+		must = append(must, fmt.Sprintf(`{"prefix":{"name.text": %s}}`, util.MustToJSON(name)))
+	}
+	if owner != "" {
+		must = append(must, fmt.Sprintf(`{"term":{"owner": %s}}`, util.MustToJSON(owner)))
+	}
+
+	if size <= 0 {
+		size = 20
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	q := orm.Query{}
+	queryDSL = fmt.Sprintf(queryDSL, strings.Join(must, ","), size, from)
+	q.RawQuery = []byte(queryDSL)
+
+	err, res := orm.Search(&common.APIKey{}, &q)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Write(w, res.Raw)
+}
@@ -24,10 +24,13 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"infini.sh/framework/core/util"
 	"infini.sh/gateway/common"
 	"testing"
+	"time"
 )
 
 func TestDecodeEntryConfig(t *testing.T) {
@@ -43,3 +46,42 @@ func TestDecodeEntryConfig(t *testing.T) {
 	fmt.Println(cfg.Name)
 
 }
+
+func TestPartialUpdateAPIKey(t *testing.T) {
+	expiration := time.Now().Add(time.Hour)
+	obj := common.APIKey{
+		Name:       "key",
+		Owner:      "medcl",
+		Roles:      []string{"reader"},
+		Expiration: &expiration,
+		RateLimit:  &common.APIKeyRateLimit{MaxRequests: 10},
+	}
+
+	r := &apiKeyRequest{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"invalidated":true}`), r))
+	assert.Nil(t, r.apply(&obj))
+	assert.True(t, obj.Invalidated)
+	assert.Equal(t, "key", obj.Name)
+	assert.Equal(t, "medcl", obj.Owner)
+	assert.Equal(t, []string{"reader"}, obj.Roles)
+	assert.Equal(t, &expiration, obj.Expiration)
+	assert.Equal(t, 10, obj.RateLimit.MaxRequests)
+
+	r = &apiKeyRequest{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"roles":["writer"],"rate_limit":{"max_requests":5}}`), r))
+	assert.Nil(t, r.apply(&obj))
+	assert.Equal(t, []string{"writer"}, obj.Roles)
+	assert.Equal(t, 5, obj.RateLimit.MaxRequests)
+	assert.Equal(t, &expiration, obj.Expiration)
+
+	r = &apiKeyRequest{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"expiration":"","rate_limit":null}`), r))
+	assert.Nil(t, r.apply(&obj))
+	assert.Nil(t, obj.Expiration)
+	assert.Nil(t, obj.RateLimit)
+	assert.Equal(t, []string{"writer"}, obj.Roles)
+
+	r = &apiKeyRequest{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"expiration":"1x"}`), r))
+	assert.NotNil(t, r.apply(&obj))
+}
@@ -42,6 +42,12 @@ func (h *GatewayAPI) createRouter(w http.ResponseWriter, req *http.Request, ps h
 		return
 	}
 
+	err = obj.Validate()
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	err = orm.Create(nil, obj)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
@@ -100,6 +106,12 @@ func (h *GatewayAPI) updateRouter(w http.ResponseWriter, req *http.Request, ps h
 		return
 	}
 
+	err = obj.Validate()
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	//protect
 	obj.ID = id
 	obj.Created = create
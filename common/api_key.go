@@ -0,0 +1,160 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
+
+// APIKey is the credential issued by the gateway, only the salted hash of the key is persisted
+type APIKey struct {
+	orm.ORMObjectBase
+
+	Name        string           `json:"name,omitempty" elastic_mapping:"name:{type:keyword,fields:{text: {type: text}}}"`
+	Owner       string           `json:"owner,omitempty" elastic_mapping:"owner: { type: keyword }"`
+	Roles       []string         `json:"roles,omitempty" elastic_mapping:"roles: { type: keyword }"`
+	Expiration  *time.Time       `json:"expiration,omitempty" elastic_mapping:"expiration: { type: date }"`
+	Invalidated bool             `json:"invalidated,omitempty" elastic_mapping:"invalidated: { type: boolean }"`
+	RateLimit   *APIKeyRateLimit `json:"rate_limit,omitempty" elastic_mapping:"rate_limit: { type: object }"`
+	Hash        string           `json:"hash,omitempty" elastic_mapping:"hash: { type: keyword, index: false }"`
+	Salt        string           `json:"salt,omitempty" elastic_mapping:"salt: { type: keyword, index: false }"`
+}
+
+type APIKeyRateLimit struct {
+	MaxRequests   int    `json:"max_requests,omitempty"`
+	BurstRequests int    `json:"burst_requests,omitempty"`
+	MaxBytes      int    `json:"max_bytes,omitempty"`
+	BurstBytes    int    `json:"burst_bytes,omitempty"`
+	Interval      string `json:"interval,omitempty"`
+}
+
+// GenerateAPIKey returns a new random key, and sets the hash of it
+func (key *APIKey) GenerateAPIKey() string {
+	salt := make([]byte, 16)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	str := base64.RawURLEncoding.EncodeToString(secret)
+	key.Salt = hex.EncodeToString(salt)
+	key.Hash = hashAPIKey(key.Salt, str)
+	return str
+}
+
+func hashAPIKey(salt, key string) string {
+	hash := sha256.Sum256([]byte(salt + key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (key *APIKey) Verify(secret string) bool {
+	if key.Hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashAPIKey(key.Salt, secret)), []byte(key.Hash)) == 1
+}
+
+func (key *APIKey) IsExpired() bool {
+	return key.Expiration != nil && time.Now().After(*key.Expiration)
+}
+
+// EncodeAPIKey returns the credential used in the `Authorization: ApiKey` header
+func EncodeAPIKey(id, key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(id + ":" + key))
+}
+
+type cachedAPIKey struct {
+	key    *APIKey
+	loaded time.Time
+}
+
+var apiKeyCacheLock = sync.RWMutex{}
+var apiKeyCache = map[string]cachedAPIKey{}
+
+// max number of cached missing keys, to avoid unbounded growth by random ids
+const maxMissingAPIKeys = 10000
+
+var missingAPIKeys = 0
+
+// GetAPIKey loads the api key from the store, results are cached for the ttl, nil is returned if the key does not exist
+func GetAPIKey(id string, ttl time.Duration) (*APIKey, error) {
+	apiKeyCacheLock.RLock()
+	cached, ok := apiKeyCache[id]
+	apiKeyCacheLock.RUnlock()
+	if ok && time.Since(cached.loaded) < ttl {
+		return cached.key, nil
+	}
+
+	obj := APIKey{}
+	obj.ID = id
+	exists, err := orm.Get(&obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var key *APIKey
+	if exists {
+		key = &obj
+	}
+
+	apiKeyCacheLock.Lock()
+	defer apiKeyCacheLock.Unlock()
+	if ok && cached.key == nil {
+		missingAPIKeys--
+	}
+	if key == nil {
+		if missingAPIKeys >= maxMissingAPIKeys {
+			delete(apiKeyCache, id)
+			return nil, nil
+		}
+		missingAPIKeys++
+	}
+	apiKeyCache[id] = cachedAPIKey{key: key, loaded: time.Now()}
+	return key, nil
+}
+
+// InvalidateAPIKeyCache removes the cached key, called when the key is updated or deleted
+func InvalidateAPIKeyCache(id string) {
+	apiKeyCacheLock.Lock()
+	defer apiKeyCacheLock.Unlock()
+	if cached, ok := apiKeyCache[id]; ok {
+		if cached.key == nil {
+			missingAPIKeys--
+		}
+		delete(apiKeyCache, id)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyVerify(t *testing.T) {
+	key := APIKey{}
+	secret := key.GenerateAPIKey()
+	assert.NotEmpty(t, secret)
+	assert.NotEmpty(t, key.Salt)
+	assert.NotEqual(t, secret, key.Hash)
+
+	assert.True(t, key.Verify(secret))
+	assert.False(t, key.Verify(secret+"x"))
+	assert.False(t, key.Verify(""))
+
+	//a new key gets a new salt
+	other := APIKey{}
+	otherSecret := other.GenerateAPIKey()
+	assert.NotEqual(t, secret, otherSecret)
+	assert.NotEqual(t, key.Salt, other.Salt)
+	assert.False(t, other.Verify(secret))
+
+	assert.False(t, (&APIKey{}).Verify(""))
+}
+
+func TestAPIKeyExpiration(t *testing.T) {
+	key := APIKey{}
+	assert.False(t, key.IsExpired())
+
+	past := time.Now().Add(-time.Minute)
+	key.Expiration = &past
+	assert.True(t, key.IsExpired())
+
+	future := time.Now().Add(time.Minute)
+	key.Expiration = &future
+	assert.False(t, key.IsExpired())
+}
+
+func TestEncodeAPIKey(t *testing.T) {
+	encoded := EncodeAPIKey("id", "key")
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, "id:key", string(decoded))
+}
@@ -0,0 +1,223 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"net"
+	"strings"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const HeaderForwarded = "Forwarded"
+
+// GetClientIP returns the client ip resolved from the forwarding headers of the trusted proxies,
+// or the remote ip of the connection
+func GetClientIP(ctx *fasthttp.RequestCtx) net.IP {
+	v := ctx.Get(ClientIPKey)
+	if v != nil {
+		if ip, ok := v.(net.IP); ok {
+			return ip
+		}
+	}
+	return ctx.RemoteIP()
+}
+
+// TrustedProxies resolves the client ip from the forwarding headers sent by the trusted proxies
+type TrustedProxies struct {
+	cidrs  []*net.IPNet
+	header string
+}
+
+// NewTrustedProxies creates the trusted proxies from the CIDR list, the header is `X-Forwarded-For` by default, or `Forwarded`
+func NewTrustedProxies(cidrs []string, header string) (*TrustedProxies, error) {
+	proxies := TrustedProxies{header: fasthttp.HeaderXForwardedFor}
+	if strings.EqualFold(header, HeaderForwarded) {
+		proxies.header = HeaderForwarded
+	}
+	for _, v := range cidrs {
+		cidr, err := ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		proxies.cidrs = append(proxies.cidrs, cidr)
+	}
+	return &proxies, nil
+}
+
+func (this *TrustedProxies) IsTrusted(ip net.IP) bool {
+	for _, cidr := range this.cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client ip of the request, the forwarding headers are only used if the request comes from a trusted proxy
+func (this *TrustedProxies) Resolve(ctx *fasthttp.RequestCtx) (net.IP, bool) {
+	remote := ctx.RemoteIP()
+	if !this.IsTrusted(remote) {
+		return remote, false
+	}
+
+	values := []string{}
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		if strings.EqualFold(string(key), this.header) {
+			values = append(values, string(value))
+		}
+	})
+	if len(values) == 0 {
+		return remote, false
+	}
+
+	var hops []net.IP
+	if this.header == HeaderForwarded {
+		hops = ParseForwarded(values...)
+	} else {
+		hops = ParseXForwardedFor(values...)
+	}
+	return this.ResolveHops(remote, hops), true
+}
+
+// ResolveHops walks the hops from right to left, and returns the right-most untrusted one,
+// the left-most hop is returned if all of them are trusted
+func (this *TrustedProxies) ResolveHops(remote net.IP, hops []net.IP) net.IP {
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		//the chain before an invalid hop can't be trusted
+		if hops[i] == nil {
+			break
+		}
+		client = hops[i]
+		if !this.IsTrusted(client) {
+			break
+		}
+	}
+	return client
+}
+
+// ParseXForwardedFor parses the `X-Forwarded-For` headers, invalid addresses are kept as nil
+func ParseXForwardedFor(values ...string) []net.IP {
+	hops := []net.IP{}
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			hops = append(hops, parseHop(v))
+		}
+	}
+	return hops
+}
+
+// ParseForwarded parses the `for` parameters of the `Forwarded` headers, invalid or obfuscated addresses are kept as nil
+func ParseForwarded(values ...string) []net.IP {
+	hops := []net.IP{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var hop net.IP
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = parseHop(strings.Trim(kv[1], "\""))
+					break
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+func parseHop(v string) net.IP {
+	v = strings.TrimSpace(v)
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	}
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	return net.ParseIP(v)
+}
+
+// SetForwardedHeaders sets the forwarding headers of the request to the upstream, the `X-Forwarded-For` chain
+// is kept and appended only if the request comes from a trusted proxy, otherwise it's overwritten by the remote ip
+func SetForwardedHeaders(ctx *fasthttp.RequestCtx, originalHost string) {
+	remote := ctx.RemoteIP().String()
+	if ctx.Get(ClientIPKey) != nil {
+		forwardedFor := ctx.Request.Header.Peek(fasthttp.HeaderXForwardedFor)
+		if len(forwardedFor) == 0 {
+			ctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, remote)
+		} else {
+			ctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, string(forwardedFor)+", "+remote)
+		}
+	} else {
+		ctx.Request.Header.Del(HeaderForwarded)
+		ctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, remote)
+	}
+	ctx.Request.Header.Set(fasthttp.HeaderXRealIP, GetClientIP(ctx).String())
+	ctx.Request.Header.Set(fasthttp.HeaderXForwardedHost, originalHost)
+}
+
+// IPAccessChecker checks the client ip of the requests against the ip access rules of the router,
+// used instead of the connection level checks when the requests come from the trusted proxies
+type IPAccessChecker struct {
+	denied    []*net.IPNet
+	permitted []*net.IPNet
+}
+
+func NewIPAccessChecker(rules IPAccessRules) (*IPAccessChecker, error) {
+	checker := IPAccessChecker{}
+	for _, v := range rules.ClientIP.DeniedList {
+		cidr, err := ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		checker.denied = append(checker.denied, cidr)
+	}
+	for _, v := range rules.ClientIP.PermittedList {
+		cidr, err := ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		checker.permitted = append(checker.permitted, cidr)
+	}
+	return &checker, nil
+}
+
+func (this *IPAccessChecker) Allow(ip net.IP) bool {
+	for _, cidr := range this.denied {
+		if cidr.Contains(ip) {
+			return false
+		}
+	}
+	if len(this.permitted) == 0 {
+		return true
+	}
+	for _, cidr := range this.permitted {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForwardingHeaders(t *testing.T) {
+	hops := ParseXForwardedFor("203.0.113.7, 10.0.0.1:8080", "[2001:db8::1]:443,invalid")
+	assert.Equal(t, 4, len(hops))
+	assert.Equal(t, "203.0.113.7", hops[0].String())
+	assert.Equal(t, "10.0.0.1", hops[1].String())
+	assert.Equal(t, "2001:db8::1", hops[2].String())
+	assert.Nil(t, hops[3])
+
+	hops = ParseForwarded(`for=192.0.2.60;proto=http;by=203.0.113.43, For="[2001:db8:cafe::17]:4711", for=_hidden`)
+	assert.Equal(t, 3, len(hops))
+	assert.Equal(t, "192.0.2.60", hops[0].String())
+	assert.Equal(t, "2001:db8:cafe::17", hops[1].String())
+	assert.Nil(t, hops[2])
+}
+
+func TestResolveHops(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}, "")
+	assert.Nil(t, err)
+
+	remote := net.ParseIP("10.0.0.2")
+	resolve := func(xff string) string {
+		return proxies.ResolveHops(remote, ParseXForwardedFor(xff)).String()
+	}
+
+	//the right-most untrusted hop is the client, spoofed hops on the left are ignored
+	assert.Equal(t, "203.0.113.7", resolve("1.1.1.1, 203.0.113.7, 192.168.1.1, 10.0.0.1"))
+	assert.Equal(t, "203.0.113.7", resolve("203.0.113.7"))
+	//all hops are trusted
+	assert.Equal(t, "10.0.0.5", resolve("10.0.0.5, 10.0.0.1"))
+	//the chain before an invalid hop is not used
+	assert.Equal(t, "10.0.0.1", resolve("203.0.113.7, unknown, 10.0.0.1"))
+
+	_, err = NewTrustedProxies([]string{"10.0.0.0/40"}, "")
+	assert.NotNil(t, err)
+}
+
+func TestIPAccessChecker(t *testing.T) {
+	rules := IPAccessRules{Enabled: true}
+	rules.ClientIP.DeniedList = []string{"203.0.113.7"}
+	rules.ClientIP.PermittedList = []string{"203.0.113.0/24"}
+	checker, err := NewIPAccessChecker(rules)
+	assert.Nil(t, err)
+	assert.False(t, checker.Allow(net.ParseIP("203.0.113.7")))
+	assert.True(t, checker.Allow(net.ParseIP("203.0.113.8")))
+	assert.False(t, checker.Allow(net.ParseIP("198.51.100.1")))
+
+	rules.ClientIP.PermittedList = nil
+	checker, err = NewIPAccessChecker(rules)
+	assert.Nil(t, err)
+	assert.True(t, checker.Allow(net.ParseIP("198.51.100.1")))
+}
@@ -27,8 +27,11 @@
 package common
 
 import (
+	"fmt"
+
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
 )
 
 type EntryConfig struct {
@@ -55,20 +58,112 @@ type EntryConfig struct {
 	MaxConcurrency         int `config:"max_concurrency" json:"max_concurrency,omitempty" elastic_mapping:"max_concurrency: { type: integer }"`
 	MaxConnsPerIP          int `config:"max_conns_per_ip" json:"max_conns_per_ip,omitempty" elastic_mapping:"max_conns_per_ip: { type: integer }"`
 
-	TLSConfig        config.TLSConfig     `config:"tls" json:"tls,omitempty" elastic_mapping:"tls: { type: object }"`
-	NetworkConfig    config.NetworkConfig `config:"network" json:"network,omitempty" elastic_mapping:"network: { type: object }"`
-	RouterConfigName string               `config:"router" json:"router,omitempty" elastic_mapping:"router: { type: keyword }"`
+	TLSConfig        config.TLSConfig      `config:"tls" json:"tls,omitempty" elastic_mapping:"tls: { type: object }"`
+	TLSClientAuth    TLSClientAuthConfig   `config:"tls" json:"tls_client_auth,omitempty" elastic_mapping:"tls_client_auth: { type: object }"`
+	TLSCertificates  TLSCertificatesConfig `config:"tls" json:"tls_certificates,omitempty" elastic_mapping:"tls_certificates: { type: object }"`
+	NetworkConfig    config.NetworkConfig  `config:"network" json:"network,omitempty" elastic_mapping:"network: { type: object }"`
+	NetworkExt       NetworkExtConfig      `config:"network" json:"network_ext,omitempty" elastic_mapping:"network_ext: { type: object }"`
+	RouterConfigName string                `config:"router" json:"router,omitempty" elastic_mapping:"router: { type: keyword }"`
+
+	HTTP2 HTTP2Config `config:"http2" json:"http2,omitempty" elastic_mapping:"http2: { type: object }"`
+
+	//wait for the in-flight requests before closing the entry, instead of cutting them off
+	Drain DrainConfig `config:"drain" json:"drain,omitempty" elastic_mapping:"drain: { type: object }"`
+
+	//protocol of the entry, http by default, the adapter specific settings are in the adapter section
+	Type          string         `config:"type" json:"type,omitempty" elastic_mapping:"type: { type: keyword }"`
+	AdapterConfig *config.Config `config:"adapter" json:"-"`
 }
 
 func (this *EntryConfig) Equals(target *EntryConfig) bool {
 	if this.Enabled != target.Enabled ||
 		this.DirtyShutdown != target.DirtyShutdown ||
+		this.Drain != target.Drain ||
+		this.HTTP2 != target.HTTP2 ||
 		this.RouterConfigName != target.RouterConfigName ||
+		this.Type != target.Type ||
 		this.TLSConfig.TLSEnabled != target.TLSConfig.TLSEnabled ||
+		this.TLSClientAuth != target.TLSClientAuth ||
+		util.MustToJSON(this.TLSCertificates) != util.MustToJSON(target.TLSCertificates) ||
+		util.MustToJSON(this.NetworkExt) != util.MustToJSON(target.NetworkExt) ||
 		this.NetworkConfig.GetBindingAddr() != target.NetworkConfig.GetBindingAddr() {
 		return false
 	}
-	return true
+
+	v1, err := configFingerprint(this.AdapterConfig)
+	if err != nil {
+		return false
+	}
+	v2, err := configFingerprint(target.AdapterConfig)
+	if err != nil {
+		return false
+	}
+	return v1 == v2
+}
+
+// configFingerprint unpacks the config section, which can't be compared directly
+func configFingerprint(cfg *config.Config) (string, error) {
+	m := map[string]interface{}{}
+	if cfg != nil {
+		if err := cfg.Unpack(&m); err != nil {
+			return "", err
+		}
+	}
+	return util.MustToJSON(m), nil
+}
+
+type HTTP2Config struct {
+	//accept HTTP/2 negotiated by ALPN over TLS
+	Enabled bool `config:"enabled" json:"enabled,omitempty"`
+	//accept HTTP/2 over cleartext with prior knowledge
+	H2C                  bool   `config:"h2c" json:"h2c,omitempty"`
+	MaxConcurrentStreams uint32 `config:"max_concurrent_streams" json:"max_concurrent_streams,omitempty"`
+}
+
+type DrainConfig struct {
+	Enabled bool `config:"enabled" json:"enabled,omitempty"`
+	//max time to wait for the in-flight requests, 30s by default
+	Timeout string `config:"timeout" json:"timeout,omitempty"`
+}
+
+// TLSClientAuthConfig holds the client certificate settings, unpacked from the same `tls` section of the entry
+type TLSClientAuthConfig struct {
+	//none, request, require, verify_if_given or verify
+	ClientAuth   string `config:"client_auth" json:"client_auth,omitempty"`
+	ClientCAFile string `config:"client_ca_file" json:"client_ca_file,omitempty"`
+}
+
+// TLSCertificatesConfig holds the certificates selected by SNI, unpacked from the same `tls` section of the entry
+type TLSCertificatesConfig struct {
+	Certificates []TLSCertificate `config:"certificates" json:"certificates,omitempty"`
+	//load the `<name>.crt`, `<name>.cert` or `<name>.pem` files with the `<name>.key` files in the directory
+	CertDir string `config:"cert_dir" json:"cert_dir,omitempty"`
+	//interval to check the changes of the certificate files, 10s by default
+	ReloadInterval string `config:"reload_interval" json:"reload_interval,omitempty"`
+}
+
+type TLSCertificate struct {
+	CertFile string `config:"cert_file" json:"cert_file,omitempty"`
+	KeyFile  string `config:"key_file" json:"key_file,omitempty"`
+}
+
+// NetworkExtConfig holds the gateway specific network settings, unpacked from the same `network` section of the entry
+type NetworkExtConfig struct {
+	ProxyProtocol ProxyProtocolConfig `config:"proxy_protocol" json:"proxy_protocol,omitempty"`
+	//the client ip is resolved from the forwarding headers of the requests from these proxies
+	TrustedProxies []string `config:"trusted_proxies" json:"trusted_proxies,omitempty"`
+	//`X-Forwarded-For` by default, or `Forwarded`
+	ClientIPHeader string `config:"client_ip_header" json:"client_ip_header,omitempty"`
+	//permission of the socket file when binding to a unix domain socket, eg: 0660
+	SocketMode string `config:"socket_mode" json:"socket_mode,omitempty"`
+}
+
+type ProxyProtocolConfig struct {
+	Enabled bool `config:"enabled" json:"enabled,omitempty"`
+	//the headers are only accepted from these sources, all the sources are trusted if not set
+	TrustedCIDR []string `config:"trusted_cidr" json:"trusted_cidr,omitempty"`
+	//max time to wait for the header, 5s by default
+	Timeout string `config:"timeout" json:"timeout,omitempty"`
 }
 
 type RuleConfig struct {
@@ -77,6 +172,14 @@ type RuleConfig struct {
 	PathPattern []string `config:"pattern" json:"pattern,omitempty"      elastic_mapping:"pattern: { type: keyword }"`
 	Flow        []string `config:"flow" json:"flow,omitempty"      elastic_mapping:"flow: { type: keyword }"`
 	Description string   `config:"description" json:"description,omitempty"      elastic_mapping:"description: { type: keyword }"`
+
+	//optional conditions besides the method and path, all of them need to be matched, `*` is supported as wildcard
+	Host       []string          `config:"host" json:"host,omitempty"      elastic_mapping:"host: { type: keyword }"`
+	Header     map[string]string `config:"header" json:"header,omitempty"      elastic_mapping:"header: { type: object, enabled: false }"`
+	Query      map[string]string `config:"query" json:"query,omitempty"      elastic_mapping:"query: { type: object, enabled: false }"`
+	ClientCIDR []string          `config:"client_cidr" json:"client_cidr,omitempty"      elastic_mapping:"client_cidr: { type: keyword }"`
+	//rules with higher priority are evaluated first, rules with the same priority are evaluated in order
+	Priority int `config:"priority" json:"priority,omitempty"      elastic_mapping:"priority: { type: integer }"`
 }
 
 type FilterConfig struct {
@@ -98,6 +201,19 @@ type RouterConfig struct {
 	IPAccessRules IPAccessRules `config:"ip_access_control" json:"ip_access_rules,omitempty" elastic_mapping:"ip_access_rules: { type: object }"`
 }
 
+func (this *RouterConfig) Equals(target *RouterConfig) bool {
+	return util.MustToJSON(this) == util.MustToJSON(target)
+}
+
+func (this *RouterConfig) Validate() error {
+	for i, rule := range this.Rules {
+		if _, err := NewRuleMatcher(rule); err != nil {
+			return fmt.Errorf("invalid rule [%v] of router [%v]: %v", i, this.Name, err)
+		}
+	}
+	return nil
+}
+
 type IPAccessRules struct {
 	Enabled  bool `config:"enabled" json:"enabled,omitempty" elastic_mapping:"enabled: { type: boolean }"`
 	ClientIP struct {
@@ -114,6 +230,37 @@ type FlowConfig struct {
 	JsonFilters []map[string]interface{} `json:"filter,omitempty"`
 }
 
+// Equals compares the filters of the flows
+func (flow *FlowConfig) Equals(target *FlowConfig) bool {
+	if flow.Name != target.Name {
+		return false
+	}
+	v1, err := flow.fingerprint()
+	if err != nil {
+		return false
+	}
+	v2, err := target.fingerprint()
+	if err != nil {
+		return false
+	}
+	return v1 == v2
+}
+
+func (flow *FlowConfig) fingerprint() (string, error) {
+	filters := []interface{}{}
+	for _, v := range flow.Filters {
+		m := map[string]interface{}{}
+		if err := v.Unpack(&m); err != nil {
+			return "", err
+		}
+		filters = append(filters, m)
+	}
+	for _, v := range flow.JsonFilters {
+		filters = append(filters, v)
+	}
+	return util.MustToJSON(filters), nil
+}
+
 func (flow *FlowConfig) GetConfig() []*config.Config {
 
 	for _, v := range flow.JsonFilters {
@@ -0,0 +1,82 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+)
+
+func newTestConfig(t *testing.T, m map[string]interface{}) *config.Config {
+	cfg, err := config.NewConfigFrom(m)
+	assert.Nil(t, err)
+	return cfg
+}
+
+func TestEntryConfigEquals(t *testing.T) {
+	entry1 := EntryConfig{Type: "kafka", AdapterConfig: newTestConfig(t, map[string]interface{}{"topics": []string{"logs"}})}
+	entry2 := EntryConfig{Type: "kafka", AdapterConfig: newTestConfig(t, map[string]interface{}{"topics": []string{"logs"}})}
+	assert.True(t, entry1.Equals(&entry2))
+
+	//the adapter restarts when its settings are changed
+	entry2.AdapterConfig = newTestConfig(t, map[string]interface{}{"topics": []string{"metrics"}})
+	assert.False(t, entry1.Equals(&entry2))
+
+	entry2.AdapterConfig = nil
+	assert.False(t, entry1.Equals(&entry2))
+
+	entry1.AdapterConfig = nil
+	assert.True(t, entry1.Equals(&entry2))
+}
+
+func TestRegisterFlowConfigs(t *testing.T) {
+	flow := func(name, index string) FlowConfig {
+		return FlowConfig{Name: name, JsonFilters: []map[string]interface{}{{"set_request_header": map[string]interface{}{"index": index}}}}
+	}
+
+	changed := RegisterFlowConfigs([]FlowConfig{flow("test_flow_a", "a"), flow("test_flow_b", "b")})
+	assert.ElementsMatch(t, []string{"test_flow_a", "test_flow_b"}, changed)
+
+	//only the changed flows are registered again
+	changed = RegisterFlowConfigs([]FlowConfig{flow("test_flow_a", "a"), flow("test_flow_b", "c")})
+	assert.Equal(t, []string{"test_flow_b"}, changed)
+
+	changed = RegisterFlowConfigs([]FlowConfig{flow("test_flow_a", "a"), flow("test_flow_b", "c")})
+	assert.Equal(t, 0, len(changed))
+
+	//the removed flows are deleted
+	changed = RegisterFlowConfigs([]FlowConfig{flow("test_flow_a", "a")})
+	assert.Equal(t, []string{"test_flow_b"}, changed)
+	_, err := GetFlowConfig("test_flow_b")
+	assert.NotNil(t, err)
+
+	//the same filters loaded from the config file
+	yaml := FlowConfig{Name: "test_flow_a", Filters: []*config.Config{newTestConfig(t, map[string]interface{}{"set_request_header": map[string]interface{}{"index": "a"}})}}
+	assert.True(t, yaml.Equals(&FlowConfig{Name: "test_flow_a", JsonFilters: flow("test_flow_a", "a").JsonFilters}))
+}
@@ -24,6 +24,7 @@
 package common
 
 import (
+	"fmt"
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -76,9 +77,31 @@ func (flow *FilterFlow) Process(ctx *fasthttp.RequestCtx) {
 			log.Tracef("processing filter [%v] [%v]", v.Name(), v)
 		}
 		ctx.AddFlowProcess(v.Name())
-		v.Filter(ctx)
+		if IsTracingEnabled() {
+			processFilterWithSpan(ctx, v)
+		} else {
+			v.Filter(ctx)
+		}
 	}
 }
+
+func processFilterWithSpan(ctx *fasthttp.RequestCtx, filter pipeline.Filter) {
+	span := StartSpan(ctx, filter.Name(), SpanKindInternal)
+	span.SetAttribute("filter.name", filter.Name())
+	defer func() {
+		if r := recover(); r != nil {
+			span.RecordError(fmt.Errorf("%v", r))
+			span.End()
+			panic(r)
+		}
+		span.SetAttribute("http.response.status_code", ctx.Response.StatusCode())
+		if !ctx.ShouldContinue() {
+			span.SetAttribute("filter.stopped", true)
+		}
+		span.End()
+	}()
+	filter.Filter(ctx)
+}
 var nilIDFlowError=errors.New("flow id can't be nil")
 
 func GetFlow(flowID string) (FilterFlow,error) {
@@ -130,6 +153,9 @@ func GetFlowProcess(flowID string) func(ctx *fasthttp.RequestCtx) {
 var flows = &sync.Map{}
 
 var routingRules map[string]RuleConfig = make(map[string]RuleConfig)
+
+// configLock guards the flow and router configs, which are replaced by the config handlers while the entries are serving
+var configLock = sync.RWMutex{}
 var flowConfigs map[string]FlowConfig = make(map[string]FlowConfig)
 var routerConfigs map[string]RouterConfig = make(map[string]RouterConfig)
 
@@ -147,6 +173,12 @@ func GetAllFlows()map[string]FilterFlow{
 }
 
 func RegisterFlowConfig(flow FlowConfig) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	registerFlowConfig(flow)
+}
+
+func registerFlowConfig(flow FlowConfig) {
 
 	if flow.ID == "" && flow.Name != "" {
 		flow.ID = flow.Name
@@ -158,15 +190,59 @@ func RegisterFlowConfig(flow FlowConfig) {
 	ClearFlowCache(flow.Name)
 }
 
+// RegisterFlowConfigs replaces the flows with the configs, registers the flows which are new or changed, removes the
+// flows which are not in the configs, and returns the ids and names of the changed and removed flows
+func RegisterFlowConfigs(configs []FlowConfig) []string {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	changed := []string{}
+	keys := map[string]bool{}
+	for _, v := range configs {
+		if v.ID == "" && v.Name != "" {
+			v.ID = v.Name
+		}
+		keys[v.ID] = true
+		keys[v.Name] = true
+		old, ok := flowConfigs[v.ID]
+		if ok && old.Equals(&v) {
+			continue
+		}
+		registerFlowConfig(v)
+		changed = append(changed, v.ID)
+		if v.Name != "" && v.Name != v.ID {
+			changed = append(changed, v.Name)
+		}
+	}
+
+	for k := range flowConfigs {
+		if !keys[k] {
+			delete(flowConfigs, k)
+			ClearFlowCache(k)
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
 func RegisterRouterConfig(config RouterConfig) {
 	if config.ID == "" && config.Name != "" {
 		config.ID = config.Name
 	}
+	configLock.Lock()
+	defer configLock.Unlock()
 	routerConfigs[config.ID] = config
 }
 
-func GetRouter(name string) RouterConfig {
+func GetRouterConfig(name string) (RouterConfig, bool) {
+	configLock.RLock()
+	defer configLock.RUnlock()
 	v, ok := routerConfigs[name]
+	return v, ok
+}
+
+func GetRouter(name string) RouterConfig {
+	v, ok := GetRouterConfig(name)
 	if !ok {
 		panic(errors.Errorf("router [%s] not found", name))
 	}
@@ -174,7 +250,9 @@ func GetRouter(name string) RouterConfig {
 }
 
 func GetFlowConfig(id string) (FlowConfig,error) {
+	configLock.RLock()
 	v, ok := flowConfigs[id]
+	configLock.RUnlock()
 	if !ok {
 		return v,errors.Errorf("flow [%s] not found", id)
 	}
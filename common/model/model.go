@@ -62,6 +62,8 @@ type DataFlow struct {
 
 type HttpRequest struct {
 	ID           uint64    `json:"id,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+	TraceID      string    `json:"trace_id,omitempty"`
 	LoggingTime  string    `json:"timestamp,omitempty"`
 	LocalIP      string    `json:"local_ip,omitempty"`
 	RemoteIP     string    `json:"remote_ip,omitempty"`
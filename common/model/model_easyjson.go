@@ -461,6 +461,10 @@ func easyjsonC80ae7adDecodeInfiniShGatewayCommonModel2(in *jlexer.Lexer, out *Ht
 		switch key {
 		case "id":
 			out.ID = uint64(in.Uint64())
+		case "request_id":
+			out.RequestID = string(in.String())
+		case "trace_id":
+			out.TraceID = string(in.String())
 		case "timestamp":
 			out.LoggingTime = string(in.String())
 		case "local_ip":
@@ -547,6 +551,26 @@ func easyjsonC80ae7adEncodeInfiniShGatewayCommonModel2(out *jwriter.Writer, in H
 		out.RawString(prefix[1:])
 		out.Uint64(uint64(in.ID))
 	}
+	if in.RequestID != "" {
+		const prefix string = ",\"request_id\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.RequestID))
+	}
+	if in.TraceID != "" {
+		const prefix string = ",\"trace_id\":"
+		if first {
+			first = false
+			out.RawString(prefix[1:])
+		} else {
+			out.RawString(prefix)
+		}
+		out.String(string(in.TraceID))
+	}
 	if in.LoggingTime != "" {
 		const prefix string = ",\"timestamp\":"
 		if first {
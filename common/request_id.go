@@ -0,0 +1,236 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+const HeaderRequestID = "X-Request-ID"
+const HeaderTraceParent = "traceparent"
+const HeaderOpaqueID = "X-Opaque-Id"
+
+// the max length of the request id inherited from the client
+const maxRequestIDLength = 128
+
+// TraceContext is the W3C trace context carried by the `traceparent` header
+type TraceContext struct {
+	Version  string
+	TraceID  string
+	ParentID string
+	Flags    string
+}
+
+// NewTraceContext starts a new sampled trace
+func NewTraceContext() *TraceContext {
+	return &TraceContext{Version: "00", TraceID: randomHex(16), ParentID: randomHex(8), Flags: "01"}
+}
+
+// ParseTraceParent parses the `traceparent` header, eg: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceParent(v string) (*TraceContext, error) {
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || (len(v) > 55 && v[55] != '-') {
+		return nil, fmt.Errorf("invalid traceparent: %v", v)
+	}
+	parts := strings.Split(v[:55], "-")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid traceparent: %v", v)
+	}
+	tc := TraceContext{Version: parts[0], TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}
+	if !isLowerHex(tc.Version, 2) || tc.Version == "ff" || (tc.Version == "00" && len(v) != 55) {
+		return nil, fmt.Errorf("invalid traceparent version: %v", v)
+	}
+	if !isLowerHex(tc.TraceID, 32) || isZeroHex(tc.TraceID) {
+		return nil, fmt.Errorf("invalid trace id: %v", v)
+	}
+	if !isLowerHex(tc.ParentID, 16) || isZeroHex(tc.ParentID) {
+		return nil, fmt.Errorf("invalid parent id: %v", v)
+	}
+	if !isLowerHex(tc.Flags, 2) {
+		return nil, fmt.Errorf("invalid trace flags: %v", v)
+	}
+	return &tc, nil
+}
+
+// Child creates the trace context of a new span in the same trace, the version is downgraded to `00`
+func (this *TraceContext) Child() *TraceContext {
+	return &TraceContext{Version: "00", TraceID: this.TraceID, ParentID: randomHex(8), Flags: this.Flags}
+}
+
+func (this *TraceContext) IsSampled() bool {
+	b, err := hex.DecodeString(this.Flags)
+	return err == nil && len(b) == 1 && b[0]&0x01 == 0x01
+}
+
+func (this *TraceContext) String() string {
+	return fmt.Sprintf("%v-%v-%v-%v", this.Version, this.TraceID, this.ParentID, this.Flags)
+}
+
+// InitRequestID assigns the request id and the trace context of the request, the request id is inherited from
+// the `X-Request-ID` header, or the trace id of the incoming `traceparent`, or generated. the gateway joins the
+// incoming trace as a new span, the headers of the request are updated, so the id is carried by the request anywhere
+// it goes, to the upstream, the queue or the logging
+func InitRequestID(ctx *fasthttp.RequestCtx) string {
+	var tc *TraceContext
+	if v := peekHeader(&ctx.Request.Header, HeaderTraceParent); v != nil {
+		if parent, err := ParseTraceParent(string(v)); err == nil {
+			tc = parent.Child()
+			ctx.Set(ParentTraceContextKey, parent)
+		}
+	}
+	if tc == nil {
+		tc = NewTraceContext()
+	}
+
+	requestID := string(peekHeader(&ctx.Request.Header, HeaderRequestID))
+	if !IsValidRequestID(requestID) {
+		requestID = tc.TraceID
+		delHeader(&ctx.Request.Header, HeaderRequestID)
+		ctx.Request.Header.Set(HeaderRequestID, requestID)
+	}
+
+	delHeader(&ctx.Request.Header, HeaderTraceParent)
+	ctx.Request.Header.Set(HeaderTraceParent, tc.String())
+
+	ctx.Set(RequestIDKey, requestID)
+	ctx.Set(TraceContextKey, tc)
+	return requestID
+}
+
+// GetRequestID returns the request id assigned by the entry, or the `X-Request-ID` header of the request
+func GetRequestID(ctx *fasthttp.RequestCtx) string {
+	if v, ok := ctx.Get(RequestIDKey).(string); ok && v != "" {
+		return v
+	}
+	return string(peekHeader(&ctx.Request.Header, HeaderRequestID))
+}
+
+// GetTraceContext returns the trace context of the current span, or the one assigned by the entry, or the one parsed
+// from the `traceparent` header
+func GetTraceContext(ctx *fasthttp.RequestCtx) *TraceContext {
+	if tc := CurrentSpan(ctx).TraceContext(); tc != nil {
+		return tc
+	}
+	if v, ok := ctx.Get(TraceContextKey).(*TraceContext); ok && v != nil {
+		return v
+	}
+	if v := peekHeader(&ctx.Request.Header, HeaderTraceParent); v != nil {
+		if tc, err := ParseTraceParent(string(v)); err == nil {
+			return tc
+		}
+	}
+	return nil
+}
+
+// SetTracingHeaders sets the `X-Request-ID` and `traceparent` headers of the request sent to the upstream
+func SetTracingHeaders(ctx *fasthttp.RequestCtx) {
+	if requestID := GetRequestID(ctx); requestID != "" {
+		delHeader(&ctx.Request.Header, HeaderRequestID)
+		ctx.Request.Header.Set(HeaderRequestID, requestID)
+	}
+	if tc := GetTraceContext(ctx); tc != nil {
+		delHeader(&ctx.Request.Header, HeaderTraceParent)
+		ctx.Request.Header.Set(HeaderTraceParent, tc.String())
+	}
+}
+
+// SetOpaqueIDHeader sends the request id as `X-Opaque-Id` to elasticsearch, so the request can be found in the
+// slow logs and the tasks api, the `X-Opaque-Id` set by the client is kept
+func SetOpaqueIDHeader(ctx *fasthttp.RequestCtx) {
+	if peekHeader(&ctx.Request.Header, HeaderOpaqueID) != nil {
+		return
+	}
+	if requestID := GetRequestID(ctx); requestID != "" {
+		ctx.Request.Header.Set(HeaderOpaqueID, requestID)
+	}
+}
+
+// IsValidRequestID checks the request id inherited from the client, only letters, digits and `-_.:` are allowed
+func IsValidRequestID(v string) bool {
+	if v == "" || len(v) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == ':' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// delHeader removes the header in any letter case
+func delHeader(header *fasthttp.RequestHeader, key string) {
+	var names []string
+	header.VisitAll(func(name, v []byte) {
+		if strings.EqualFold(string(name), key) {
+			names = append(names, string(name))
+		}
+	})
+	for _, name := range names {
+		header.Del(name)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		//all zero ids are invalid
+		for _, v := range b {
+			if v != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+func isLowerHex(v string, size int) bool {
+	if len(v) != size {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroHex(v string) bool {
+	return strings.Trim(v, "0") == ""
+}
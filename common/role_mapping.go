@@ -26,13 +26,130 @@
 
 package common
 
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
-func GetLDAPGroupsMappingRoles(str []string)[]string  {
-	var roles []string
-	roles=append(roles,"admin")
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// RoleMapping maps the authenticated users to roles by their groups, usernames or attributes,
+// all the configured conditions must be met, and any of the patterns of each condition
+type RoleMapping struct {
+	Name       string              `config:"name" json:"name,omitempty"`
+	Enabled    *bool               `config:"enabled" json:"enabled,omitempty"`
+	Roles      []string            `config:"roles" json:"roles,omitempty"`
+	Groups     []string            `config:"groups" json:"groups,omitempty"`           //group names or DNs, `*` wildcard supported
+	GroupRegex []string            `config:"group_regex" json:"group_regex,omitempty"` //regex patterns of group names or DNs
+	Users      []string            `config:"users" json:"users,omitempty"`             //usernames, `*` wildcard supported
+	Attributes map[string][]string `config:"attributes" json:"attributes,omitempty"`   //user attribute => patterns
+
+	groupRegex []*regexp.Regexp
+}
+
+var roleMappingLock = sync.RWMutex{}
+var roleMappings []RoleMapping
+
+// RegisterRoleMappings replaces all the role mappings, invalid regex patterns are rejected as a whole
+func RegisterRoleMappings(mappings []RoleMapping) error {
+	for i := range mappings {
+		mappings[i].groupRegex = nil
+		for _, v := range mappings[i].GroupRegex {
+			reg, err := regexp.Compile(v)
+			if err != nil {
+				return err
+			}
+			mappings[i].groupRegex = append(mappings[i].groupRegex, reg)
+		}
+	}
+	roleMappingLock.Lock()
+	defer roleMappingLock.Unlock()
+	roleMappings = mappings
+	return nil
+}
+
+// GetMappedRoles resolves the roles of the user from the registered role mappings
+func GetMappedRoles(user string, groups []string, attributes map[string][]string) []string {
+	roleMappingLock.RLock()
+	defer roleMappingLock.RUnlock()
+
+	roles := []string{}
+	seen := map[string]bool{}
+	for _, v := range roleMappings {
+		if !v.Match(user, groups, attributes) {
+			continue
+		}
+		for _, role := range v.Roles {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
 	return roles
 }
 
+func (mapping *RoleMapping) Match(user string, groups []string, attributes map[string][]string) bool {
+	if mapping.Enabled != nil && !*mapping.Enabled {
+		return false
+	}
+
+	hasRules := false
+
+	if len(mapping.Users) > 0 {
+		hasRules = true
+		if !matchAnyPattern(mapping.Users, []string{user}) {
+			return false
+		}
+	}
+
+	if len(mapping.Groups) > 0 {
+		hasRules = true
+		if !matchAnyPattern(mapping.Groups, groups) {
+			return false
+		}
+	}
+
+	if len(mapping.groupRegex) > 0 {
+		hasRules = true
+		matched := false
+		for _, reg := range mapping.groupRegex {
+			for _, group := range groups {
+				if reg.MatchString(group) {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for k, patterns := range mapping.Attributes {
+		hasRules = true
+		if !matchAnyPattern(patterns, attributes[k]) {
+			return false
+		}
+	}
+
+	return hasRules
+}
+
+func matchAnyPattern(patterns []string, values []string) bool {
+	for _, pattern := range patterns {
+		for _, v := range values {
+			//ldap DNs are case insensitive
+			if WildcardMatch(strings.ToLower(pattern), strings.ToLower(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 type Roles struct {
 	ClusterAllowedRoles map[string][]Role
@@ -40,24 +157,238 @@ type Roles struct {
 }
 
 type Role struct {
-	Cluster []ClusterPermission //A list of cluster privileges.
-	Indices []IndexPermission //A list of indices permissions entries.
+	Name    string              `config:"name" json:"name,omitempty"`
+	Cluster []ClusterPermission `config:"cluster" json:"cluster,omitempty"` //A list of cluster privileges.
+	Indices []IndexPermission   `config:"indices" json:"indices,omitempty"` //A list of indices permissions entries.
 }
 
 type ClusterPermission struct {
-	Name string
-	Path []string
+	Name string   `config:"name" json:"name,omitempty"` //privilege name, eg: all, manage, monitor
+	Path []string `config:"path" json:"path,omitempty"` //extra path patterns granted, eg: /_cat/*
 }
 
 type IndexPermission struct {
-	Name []string
-	Privileges []string
-	FieldSecurity []string
-	Query string
-	AllowRestrictedIndices string
+	Name                   []string          `config:"names" json:"names,omitempty"`
+	Privileges             []string          `config:"privileges" json:"privileges,omitempty"`
+	FieldSecurity          []FieldPermission `config:"field_security" json:"field_security,omitempty"`
+	Query                  string            `config:"query" json:"query,omitempty"`
+	AllowRestrictedIndices bool              `config:"allow_restricted_indices" json:"allow_restricted_indices,omitempty"`
 }
 
 type FieldPermission struct {
-	Name []string
-	Type string //grant,deny,mask
+	Name []string `config:"names" json:"names,omitempty"`
+	Type string   `config:"type" json:"type,omitempty"` //grant,deny,mask
+}
+
+//privilege => privileges implied by it
+var clusterPrivileges = map[string][]string{
+	"manage":                 {"monitor", "manage_index_templates", "manage_pipeline", "manage_ilm", "manage_snapshot"},
+	"manage_index_templates": {},
+	"manage_pipeline":        {},
+	"manage_ilm":             {},
+	"manage_snapshot":        {},
+	"manage_security":        {},
+	"monitor":                {},
+}
+
+var indexPrivileges = map[string][]string{
+	"read":                {},
+	"create_doc":          {},
+	"create":              {"create_doc"},
+	"index":               {"create", "create_doc"},
+	"delete":              {},
+	"write":               {"index", "create", "create_doc", "delete"},
+	"view_index_metadata": {},
+	"monitor":             {},
+	"create_index":        {},
+	"delete_index":        {},
+	"manage":              {"monitor", "view_index_metadata", "create_index", "delete_index"},
+}
+
+func privilegeImplies(privileges map[string][]string, granted, required string) bool {
+	if granted == "all" || granted == required {
+		return true
+	}
+	for _, v := range privileges[granted] {
+		if v == required {
+			return true
+		}
+	}
+	return false
+}
+
+//IsClusterActionPermitted checks if the required cluster privilege or the request path is granted to this role
+func (role *Role) IsClusterActionPermitted(privilege, path string) bool {
+	for _, v := range role.Cluster {
+		if privilegeImplies(clusterPrivileges, v.Name, privilege) {
+			return true
+		}
+		for _, p := range v.Path {
+			if WildcardMatch(p, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//IsIndexActionPermitted checks if the required index privilege on the index is granted to this role
+func (role *Role) IsIndexActionPermitted(privilege, index string) bool {
+	for _, v := range role.GetIndexPermissions(index) {
+		if v.HasPrivilege(privilege) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRestrictedIndexActionPermitted checks if the required index privilege on the index, including the hidden and
+// system indices it's expanded to, is granted to this role, eg: the wildcards with `expand_wildcards=all`
+func (role *Role) IsRestrictedIndexActionPermitted(privilege, index string) bool {
+	for _, v := range role.GetIndexPermissions(index) {
+		if v.AllowRestrictedIndices && v.HasPrivilege(privilege) {
+			return true
+		}
+	}
+	return false
+}
+
+//GetIndexPermissions returns all the index permissions which cover this index
+func (role *Role) GetIndexPermissions(index string) []IndexPermission {
+	var perms []IndexPermission
+	for _, v := range role.Indices {
+		if v.MatchIndex(index) {
+			perms = append(perms, v)
+		}
+	}
+	return perms
+}
+
+func (perm *IndexPermission) HasPrivilege(privilege string) bool {
+	for _, v := range perm.Privileges {
+		if privilegeImplies(indexPrivileges, v, privilege) {
+			return true
+		}
+	}
+	return false
+}
+
+//MatchIndex checks the index name or index expression against the patterns of this permission,
+//wildcard expressions from the request are only covered by a pattern that is at least as broad,
+//restricted indices (with `.` prefix) are only covered by exact names unless explicitly allowed
+func (perm *IndexPermission) MatchIndex(index string) bool {
+	for _, pattern := range perm.Name {
+		if pattern == index {
+			return true
+		}
+		//the indices of the remote clusters, eg: `cluster:index`, are only covered by the remote patterns
+		if strings.Contains(pattern, ":") != strings.Contains(index, ":") {
+			continue
+		}
+		if !WildcardMatch(pattern, index) {
+			continue
+		}
+		if strings.HasPrefix(index, ".") && !perm.AllowRestrictedIndices {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+//WildcardMatch matches the string against a simple pattern, only `*` is supported
+func WildcardMatch(pattern, str string) bool {
+	if pattern == "*" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == str
+	}
+	if !strings.HasPrefix(str, parts[0]) {
+		return false
+	}
+	str = str[len(parts[0]):]
+	for i := 1; i < len(parts)-1; i++ {
+		idx := strings.Index(str, parts[i])
+		if idx < 0 {
+			return false
+		}
+		str = str[idx+len(parts[i]):]
+	}
+	return strings.HasSuffix(str, parts[len(parts)-1])
+}
+
+var roleLock = sync.RWMutex{}
+var roles = map[string]Role{}
+
+func RegisterRole(role Role) {
+	roleLock.Lock()
+	defer roleLock.Unlock()
+	roles[role.Name] = role
+}
+
+// RegisterRoles replaces all the roles, the removed or renamed roles no longer grant anything
+func RegisterRoles(newRoles []Role) {
+	m := make(map[string]Role, len(newRoles))
+	for _, v := range newRoles {
+		m[v.Name] = v
+	}
+	roleLock.Lock()
+	defer roleLock.Unlock()
+	roles = m
+}
+
+func GetRole(name string) (Role, bool) {
+	roleLock.RLock()
+	defer roleLock.RUnlock()
+	v, ok := roles[name]
+	return v, ok
+}
+
+//GetRoles returns the registered roles by names, unknown roles are ignored
+func GetRoles(names []string) []Role {
+	roleLock.RLock()
+	defer roleLock.RUnlock()
+	var result []Role
+	for _, v := range names {
+		role, ok := roles[v]
+		if ok {
+			result = append(result, role)
+		}
+	}
+	return result
+}
+
+//GetUserRoles returns the roles resolved by the authentication filters
+func GetUserRoles(ctx *fasthttp.RequestCtx) []string {
+	v := ctx.Get(UserRolesKey)
+	if v != nil {
+		if roles, ok := v.([]string); ok {
+			return roles
+		}
+	}
+	return nil
+}
+
+// GetUserID returns the user id resolved by the authentication filters, eg: the id of the api key
+func GetUserID(ctx *fasthttp.RequestCtx) string {
+	v := ctx.Get(UserIDKey)
+	if v != nil {
+		if id, ok := v.(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+//GetUserName returns the username resolved by the authentication filters
+func GetUserName(ctx *fasthttp.RequestCtx) string {
+	v := ctx.Get(UserNameKey)
+	if v != nil {
+		if name, ok := v.(string); ok {
+			return name
+		}
+	}
+	return ""
 }
@@ -0,0 +1,72 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetMappedRoles(t *testing.T) {
+	disabled := false
+	err := RegisterRoleMappings([]RoleMapping{
+		{Roles: []string{"admin"}, Groups: []string{"cn=admins,ou=groups,dc=example,dc=com"}},
+		{Roles: []string{"reader"}, GroupRegex: []string{"^cn=team-.*,ou=groups"}},
+		{Roles: []string{"writer", "reader"}, Users: []string{"svc-*"}, Attributes: map[string][]string{"department": {"ops"}}},
+		{Roles: []string{"disabled"}, Users: []string{"*"}, Enabled: &disabled},
+		{Roles: []string{"no_rules"}},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"admin"}, GetMappedRoles("tesla", []string{"CN=Admins,OU=Groups,DC=example,DC=com"}, nil))
+	assert.Equal(t, []string{"reader"}, GetMappedRoles("tesla", []string{"cn=team-a,ou=groups,dc=example,dc=com"}, nil))
+	assert.Equal(t, []string{"reader", "writer"}, GetMappedRoles("svc-etl", nil, map[string][]string{"department": {"ops"}}))
+	assert.Equal(t, []string{}, GetMappedRoles("svc-etl", nil, map[string][]string{"department": {"dev"}}))
+
+	err = RegisterRoleMappings([]RoleMapping{{Roles: []string{"x"}, GroupRegex: []string{"("}}})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"admin"}, GetMappedRoles("tesla", []string{"cn=admins,ou=groups,dc=example,dc=com"}, nil))
+}
+
+func TestIndexPermissionOfRemoteAndRestrictedIndices(t *testing.T) {
+	role := Role{Indices: []IndexPermission{{Name: []string{"*"}, Privileges: []string{"read"}}}}
+	assert.True(t, role.IsIndexActionPermitted("read", "logs"))
+	assert.True(t, role.IsIndexActionPermitted("read", "*"))
+	assert.False(t, role.IsIndexActionPermitted("read", ".security"))
+
+	//the remote indices are not covered by the local patterns
+	assert.False(t, role.IsIndexActionPermitted("read", "remote:logs"))
+	assert.False(t, role.IsIndexActionPermitted("read", "*:*"))
+	role.Indices = append(role.Indices, IndexPermission{Name: []string{"remote:logs-*"}, Privileges: []string{"read"}})
+	assert.True(t, role.IsIndexActionPermitted("read", "remote:logs-a"))
+	assert.False(t, role.IsIndexActionPermitted("read", "other:logs-a"))
+
+	//the wildcards expanded to the hidden indices
+	assert.False(t, role.IsRestrictedIndexActionPermitted("read", "*"))
+	role.Indices = append(role.Indices, IndexPermission{Name: []string{"*"}, Privileges: []string{"read"}, AllowRestrictedIndices: true})
+	assert.True(t, role.IsRestrictedIndexActionPermitted("read", "*"))
+}
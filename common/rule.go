@@ -0,0 +1,217 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// RuleMatcher checks the host, header, query and client ip conditions of a rule
+type RuleMatcher struct {
+	hosts   []string
+	headers map[string]string
+	query   map[string]string
+	cidrs   []*net.IPNet
+}
+
+// NewRuleMatcher compiles the conditions of the rule, returns nil if the rule has no conditions
+func NewRuleMatcher(rule RuleConfig) (*RuleMatcher, error) {
+	if len(rule.Host) == 0 && len(rule.Header) == 0 && len(rule.Query) == 0 && len(rule.ClientCIDR) == 0 {
+		return nil, nil
+	}
+
+	matcher := RuleMatcher{
+		headers: rule.Header,
+		query:   rule.Query,
+	}
+
+	for _, host := range rule.Host {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			return nil, fmt.Errorf("empty host")
+		}
+		matcher.hosts = append(matcher.hosts, host)
+	}
+
+	for _, v := range rule.ClientCIDR {
+		cidr, err := ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		matcher.cidrs = append(matcher.cidrs, cidr)
+	}
+
+	return &matcher, nil
+}
+
+// ParseCIDR parses the CIDR notation, a single ip address is also accepted
+func ParseCIDR(v string) (*net.IPNet, error) {
+	v = strings.TrimSpace(v)
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address: %v", v)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, cidr, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr: %v", v)
+	}
+	return cidr, nil
+}
+
+func (this *RuleMatcher) Match(ctx *fasthttp.RequestCtx) bool {
+	if len(this.hosts) > 0 && !this.MatchHost(string(ctx.Request.Host())) {
+		return false
+	}
+
+	for k, v := range this.headers {
+		if !WildcardMatch(v, string(peekHeader(&ctx.Request.Header, k))) {
+			return false
+		}
+	}
+
+	if len(this.query) > 0 {
+		args := ctx.Request.PhantomURI().QueryArgs()
+		for k, v := range this.query {
+			if !args.Has(k) || !WildcardMatch(v, string(args.Peek(k))) {
+				return false
+			}
+		}
+	}
+
+	if len(this.cidrs) > 0 && !this.MatchIP(GetClientIP(ctx)) {
+		return false
+	}
+
+	return true
+}
+
+// MatchHost checks the host without port against the host patterns, case-insensitive
+func (this *RuleMatcher) MatchHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range this.hosts {
+		if WildcardMatch(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *RuleMatcher) MatchIP(ip net.IP) bool {
+	for _, cidr := range this.cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekHeader finds the header case-insensitive, as the header names are not normalized by the entry
+func peekHeader(header *fasthttp.RequestHeader, key string) []byte {
+	var value []byte
+	k := []byte(key)
+	header.VisitAll(func(name, v []byte) {
+		if value == nil && bytes.EqualFold(name, k) {
+			value = v
+		}
+	})
+	return value
+}
+
+// CompilePathPattern compiles the path pattern of the router to a regexp, eg: `/{index}/_search` or `/{any:*}`, which
+// checks whether a path is matched by the pattern, regardless of the other patterns of the router
+func CompilePathPattern(pattern string) (*regexp.Regexp, error) {
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	for len(pattern) > 0 {
+		start := strings.Index(pattern, "{")
+		if start < 0 {
+			expr.WriteString(regexp.QuoteMeta(pattern))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(pattern[:start]))
+
+		//the braces of the regexp of the variable are balanced
+		depth, end := 0, -1
+		for i := start; i < len(pattern) && end < 0; i++ {
+			switch pattern[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("invalid pattern: %v", pattern)
+		}
+
+		variable := pattern[start+1 : end]
+		i := strings.Index(variable, ":")
+		switch {
+		case i >= 0 && variable[i+1:] == "*":
+			expr.WriteString(".*")
+		case i >= 0:
+			expr.WriteString("(?:" + variable[i+1:] + ")")
+		case strings.HasSuffix(variable, "?"):
+			expr.WriteString("[^/]*")
+		default:
+			expr.WriteString("[^/]+")
+		}
+		pattern = pattern[end+1:]
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// SortRules sorts the rules by priority in descending order, the order of the rules with the same priority is kept,
+// the rules of the pattern picked by the router are evaluated first, then the rules of the other patterns matching the path
+func SortRules(rules []RuleConfig) []RuleConfig {
+	sorted := make([]RuleConfig, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+	return sorted
+}
@@ -0,0 +1,111 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	assert.True(t, WildcardMatch("*", ""))
+	assert.True(t, WildcardMatch("es.example.com", "es.example.com"))
+	assert.False(t, WildcardMatch("es.example.com", "es.example.co"))
+	assert.True(t, WildcardMatch("*.example.com", "es.example.com"))
+	assert.False(t, WildcardMatch("*.example.com", "example.com"))
+	assert.True(t, WildcardMatch("tenant-*", "tenant-a"))
+	assert.True(t, WildcardMatch("a*b*c", "a123b456c"))
+	assert.False(t, WildcardMatch("a*b*c", "a123c"))
+	assert.False(t, WildcardMatch("ab*ba", "aba"))
+}
+
+func TestRuleMatcher(t *testing.T) {
+	matcher, err := NewRuleMatcher(RuleConfig{Method: []string{"GET"}, PathPattern: []string{"/"}})
+	assert.Nil(t, err)
+	assert.Nil(t, matcher)
+
+	matcher, err = NewRuleMatcher(RuleConfig{
+		Host:       []string{"ES.example.com", "*.search.example.com"},
+		ClientCIDR: []string{"10.0.0.0/8", "192.168.3.10", "fd00::/8"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, matcher.MatchHost("es.example.com:8000"))
+	assert.True(t, matcher.MatchHost("Tenant-A.search.example.com"))
+	assert.False(t, matcher.MatchHost("kibana.example.com"))
+	assert.True(t, matcher.MatchIP(net.ParseIP("10.1.2.3")))
+	assert.True(t, matcher.MatchIP(net.ParseIP("192.168.3.10")))
+	assert.False(t, matcher.MatchIP(net.ParseIP("192.168.3.11")))
+	assert.True(t, matcher.MatchIP(net.ParseIP("fd00::1")))
+
+	_, err = NewRuleMatcher(RuleConfig{ClientCIDR: []string{"10.0.0.0/33"}})
+	assert.NotNil(t, err)
+}
+
+func TestSortRules(t *testing.T) {
+	rules := SortRules([]RuleConfig{
+		{Description: "a"},
+		{Description: "b", Priority: 10},
+		{Description: "c"},
+		{Description: "d", Priority: 10},
+	})
+	var order []string
+	for _, v := range rules {
+		order = append(order, v.Description)
+	}
+	assert.Equal(t, []string{"b", "d", "a", "c"}, order)
+}
+
+func TestCompilePathPattern(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		matched       bool
+	}{
+		{"/", "/", true},
+		{"/", "/index", false},
+		{"/{any:*}", "/", true},
+		{"/{any:*}", "/index/_search", true},
+		{"/{index}/_search", "/logs/_search", true},
+		{"/{index}/_search", "/logs/_doc/_search", false},
+		{"/{index}/_search", "//_search", false},
+		{"/user/{user}_admin", "/user/gordon_admin", true},
+		{"/user/{user}_admin", "/user/gordon", false},
+		{"/{name:[a-z]{2,3}}/_count", "/abc/_count", true},
+		{"/{name:[a-z]{2,3}}/_count", "/abcd/_count", false},
+		{"/src/{filepath:*}", "/src/subdir/somefile.go", true},
+		{"/a.b", "/axb", false},
+	}
+	for _, v := range cases {
+		expr, err := CompilePathPattern(v.pattern)
+		assert.Nil(t, err, v.pattern)
+		assert.Equal(t, v.matched, expr.MatchString(v.path), v.pattern+" "+v.path)
+	}
+
+	_, err := CompilePathPattern("/{index")
+	assert.NotNil(t, err)
+}
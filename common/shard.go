@@ -0,0 +1,267 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// GetShardID computes the shard of the document the same way as elasticsearch, the custom routing is hashed instead
+// of the id if it's set. the shard is computed with the routing factor of the index if the `routingNumShards` is known
+func GetShardID(majorVersion int, id, routing string, numberOfShards, routingNumShards int) int {
+	if numberOfShards <= 1 {
+		return 0
+	}
+	key := id
+	if routing != "" {
+		key = routing
+	}
+	if routingNumShards > 0 {
+		return getRoutingShardID(key, numberOfShards, routingNumShards)
+	}
+	return elastic.GetShardID(majorVersion, util.UnsafeStringToBytes(key), numberOfShards)
+}
+
+// getRoutingShardID computes the shard by the `routing_num_shards` of the index, which is larger than the number of
+// shards for the indices created on elasticsearch 7 and later, or by split
+func getRoutingShardID(key string, numberOfShards, routingNumShards int) int {
+	if routingNumShards < numberOfShards || routingNumShards%numberOfShards != 0 {
+		routingNumShards = numberOfShards
+	}
+	hash := int(murmur3Hash(key))
+	mod := hash % routingNumShards
+	if mod < 0 {
+		mod += routingNumShards
+	}
+	return mod / (routingNumShards / numberOfShards)
+}
+
+// murmur3Hash is the murmur3 x86_32 hash of the utf-16 code units of the key in little endian, seed 0, the same
+// as the hash of the routing in elasticsearch
+func murmur3Hash(key string) int32 {
+	units := utf16.Encode([]rune(key))
+	data := make([]byte, len(units)*2)
+	for i, v := range units {
+		data[i*2] = byte(v)
+		data[i*2+1] = byte(v >> 8)
+	}
+
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	var h uint32
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	//the length is always even
+	if len(data) > n {
+		k := uint32(data[n]) | uint32(data[n+1])<<8
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return int32(h)
+}
+
+// calculateRoutingNumShards returns the default `routing_num_shards` of elasticsearch, the largest number of
+// `numberOfShards * 2^n` up to 1024, so the index can be split at least once, see `calculateNumRoutingShards`
+func calculateRoutingNumShards(numberOfShards, createdMajorVersion int) int {
+	if createdMajorVersion < 7 || numberOfShards <= 0 {
+		return numberOfShards
+	}
+	log2NumShards := bits.Len(uint(numberOfShards - 1))
+	splits := 10 - log2NumShards
+	if splits < 1 {
+		splits = 1
+	}
+	return numberOfShards << splits
+}
+
+// getIndexRoutingNumShards reads the `routing_num_shards` from the settings of the index, the explicit
+// `number_of_routing_shards` is used if it's set, or it's computed by the version which created the index
+func getIndexRoutingNumShards(settings util.MapStr, numberOfShards int) int {
+	if v, err := settings.GetValue("settings.index.number_of_routing_shards"); err == nil {
+		if n, err := util.ToInt(util.ToString(v)); err == nil && n > 0 {
+			return n
+		}
+	}
+	v, err := settings.GetValue("settings.index.version.created")
+	if err != nil {
+		return 0
+	}
+	//the version id, eg: 7100299 for 7.10.2
+	created, err := util.ToInt(util.ToString(v))
+	if err != nil || created <= 0 {
+		return 0
+	}
+	return calculateRoutingNumShards(numberOfShards, created/1000000)
+}
+
+const (
+	routingNumShardsTTL        = 5 * time.Minute
+	routingNumShardsFailureTTL = 10 * time.Second
+)
+
+// indexRoutingShards caches the `routing_num_shards` of an index, it's loaded once by the first request and refreshed
+// in the background after expired, the cached one is returned while refreshing
+type indexRoutingShards struct {
+	lock             sync.Mutex
+	routingNumShards int
+	expiredAt        time.Time
+	refreshing       bool
+	//closed after the first load
+	loaded chan struct{}
+}
+
+func newIndexRoutingShards() *indexRoutingShards {
+	return &indexRoutingShards{loaded: make(chan struct{})}
+}
+
+// get returns the cached `routing_num_shards`, only one load is in flight, the requests before the first load is
+// done wait for it, the failures are only cached briefly and the last known one is kept
+func (this *indexRoutingShards) get(load func() (int, error)) int {
+	this.lock.Lock()
+	refresh := !this.refreshing && time.Now().After(this.expiredAt)
+	if refresh {
+		this.refreshing = true
+	}
+	this.lock.Unlock()
+
+	if refresh {
+		go this.refresh(load)
+	}
+
+	<-this.loaded
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.routingNumShards
+}
+
+func (this *indexRoutingShards) refresh(load func() (int, error)) {
+	routingNumShards, err := load()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err != nil {
+		this.expiredAt = time.Now().Add(routingNumShardsFailureTTL)
+	} else {
+		this.routingNumShards = routingNumShards
+		this.expiredAt = time.Now().Add(routingNumShardsTTL)
+	}
+	this.refreshing = false
+	select {
+	case <-this.loaded:
+	default:
+		close(this.loaded)
+	}
+}
+
+var indexRoutingShardsCache = sync.Map{}
+
+// GetRoutingNumShards returns the `routing_num_shards` of the index from its settings, which are cached for a while,
+// 0 is returned if the settings are not available, and the shard is computed without the routing factor
+func GetRoutingNumShards(metadata *elastic.ElasticsearchMetadata, index string, numberOfShards int) int {
+	key := metadata.Config.ID + "/" + index
+	v, ok := indexRoutingShardsCache.Load(key)
+	if !ok {
+		v, _ = indexRoutingShardsCache.LoadOrStore(key, newIndexRoutingShards())
+	}
+	return v.(*indexRoutingShards).get(func() (int, error) {
+		return loadRoutingNumShards(metadata, index, numberOfShards)
+	})
+}
+
+// loadRoutingNumShards reads the `routing_num_shards` from the settings of the index
+func loadRoutingNumShards(metadata *elastic.ElasticsearchMetadata, index string, numberOfShards int) (int, error) {
+	client := elastic.GetClientNoPanic(metadata.Config.ID)
+	if client == nil {
+		return 0, errors.Errorf("client of elasticsearch [%v] is not found", metadata.Config.ID)
+	}
+	settings, err := client.GetIndexSettings(index)
+	if err != nil {
+		log.Debugf("failed to get the settings of index [%v]: %v", index, err)
+		return 0, err
+	}
+	if settings == nil {
+		return 0, nil
+	}
+	//the settings are keyed by the name of the concrete index
+	for _, v := range *settings {
+		switch m := v.(type) {
+		case util.MapStr:
+			return getIndexRoutingNumShards(m, numberOfShards), nil
+		case map[string]interface{}:
+			return getIndexRoutingNumShards(m, numberOfShards), nil
+		}
+		break
+	}
+	return 0, nil
+}
+
+// GetPrimaryShardNode returns the shard of the document and the id of the node holding the primary shard, the routing
+// table of the index is taken from the metadata of the cluster
+func GetPrimaryShardNode(metadata *elastic.ElasticsearchMetadata, index, id, routing string) (int, string, error) {
+	table, err := metadata.GetIndexRoutingTable(index)
+	if err != nil {
+		return 0, "", err
+	}
+
+	routingNumShards := 0
+	if len(table) > 1 {
+		routingNumShards = GetRoutingNumShards(metadata, index, len(table))
+	}
+	shardID := GetShardID(metadata.GetMajorVersion(), id, routing, len(table), routingNumShards)
+	shardInfo, err := metadata.GetPrimaryShardInfo(index, util.IntToString(shardID))
+	if err != nil {
+		return shardID, "", err
+	}
+	if shardInfo == nil || shardInfo.Node == "" {
+		return shardID, "", errors.Errorf("primary shard [%v][%v] is not assigned", index, shardID)
+	}
+	return shardID, shardInfo.Node, nil
+}
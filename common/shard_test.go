@@ -0,0 +1,152 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/util"
+)
+
+func TestMurmur3Hash(t *testing.T) {
+	//the known values of Murmur3HashFunctionTests of elasticsearch
+	cases := map[string]uint32{
+		"hell":      0x5a0cb7c3,
+		"hello":     0xd7c31989,
+		"hello w":   0x22ab2984,
+		"hello wo":  0xdf0ca123,
+		"hello wor": 0xe7744d61,
+		"The quick brown fox jumps over the lazy dog": 0xe07db09c,
+		"The quick brown fox jumps over the lazy cog": 0x4e63d2ad,
+	}
+	for k, v := range cases {
+		assert.Equal(t, int32(v), murmur3Hash(k), k)
+	}
+}
+
+func TestGetRoutingShardID(t *testing.T) {
+	cases := []struct {
+		key                              string
+		numberOfShards, routingNumShards int
+		shard                            int
+	}{
+		{"1", 5, 5, 3},
+		{"1", 5, 640, 4},
+		{"1", 3, 768, 2},
+		{"2", 5, 5, 2},
+		{"2", 5, 640, 3},
+		{"3", 5, 640, 0},
+		{"hello", 5, 640, 4},
+		{"user_1", 3, 768, 2},
+		{"中文", 5, 640, 2},
+		{"中文", 2, 1024, 1},
+		//invalid routing shards are ignored
+		{"1", 5, 3, 3},
+		{"1", 5, 7, 3},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.shard, getRoutingShardID(c.key, c.numberOfShards, c.routingNumShards), c.key)
+	}
+
+	assert.Equal(t, 0, GetShardID(7, "1", "", 1, 1024))
+	assert.Equal(t, 2, GetShardID(7, "1", "中文", 5, 640))
+}
+
+func TestCalculateRoutingNumShards(t *testing.T) {
+	cases := map[int]int{1: 1024, 2: 1024, 3: 768, 4: 1024, 5: 640, 6: 768, 7: 896, 9: 576, 512: 1024, 1024: 2048, 2048: 4096}
+	for k, v := range cases {
+		assert.Equal(t, v, calculateRoutingNumShards(k, 7), k)
+	}
+	assert.Equal(t, 5, calculateRoutingNumShards(5, 6))
+}
+
+func TestGetIndexRoutingNumShards(t *testing.T) {
+	settings := util.MapStr{"settings": map[string]interface{}{"index": map[string]interface{}{
+		"number_of_shards": "5",
+		"version":          map[string]interface{}{"created": "7100299"},
+	}}}
+	assert.Equal(t, 640, getIndexRoutingNumShards(settings, 5))
+
+	settings = util.MapStr{"settings": map[string]interface{}{"index": map[string]interface{}{
+		"number_of_shards":         "5",
+		"number_of_routing_shards": "30",
+		"version":                  map[string]interface{}{"created": "7100299"},
+	}}}
+	assert.Equal(t, 30, getIndexRoutingNumShards(settings, 5))
+
+	//the indices created before elasticsearch 7
+	settings = util.MapStr{"settings": map[string]interface{}{"index": map[string]interface{}{
+		"version": map[string]interface{}{"created": "6080099"},
+	}}}
+	assert.Equal(t, 5, getIndexRoutingNumShards(settings, 5))
+
+	assert.Equal(t, 0, getIndexRoutingNumShards(util.MapStr{}, 5))
+}
+
+func TestIndexRoutingShardsCache(t *testing.T) {
+	cache := newIndexRoutingShards()
+	var calls int32
+	release := make(chan struct{})
+	load := func() (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 640, nil
+	}
+
+	//only one load for the concurrent requests
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, 640, cache.get(load))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	//the last known one is kept after a failed refresh, which is retried soon
+	cache.expiredAt = time.Now().Add(-time.Second)
+	failed := make(chan struct{})
+	assert.Equal(t, 640, cache.get(func() (int, error) {
+		defer close(failed)
+		return 0, errors.New("unavailable")
+	}))
+	<-failed
+	assert.Equal(t, 640, cache.get(load))
+	cache.lock.Lock()
+	assert.True(t, cache.expiredAt.Before(time.Now().Add(routingNumShardsFailureTTL+time.Second)))
+	cache.lock.Unlock()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -44,3 +44,11 @@ const CACHEHASH = "request_cache_hash"
 
 var FaviconPath = []byte("/favicon.ico")
 
+const UserIDKey = "user_id"
+const UserNameKey = "user_name"
+const UserRolesKey = "user_roles"
+const ClientIPKey = "client_ip"
+const RequestIDKey = "request_id"
+const TraceContextKey = "trace_context"
+const ParentTraceContextKey = "parent_trace_context"
+const SpanKey = "trace_span"
@@ -0,0 +1,173 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"sync/atomic"
+
+	"infini.sh/framework/lib/fasthttp"
+)
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+	SpanKindProducer
+	SpanKindConsumer
+)
+
+// Span is a traced unit of work of the request, eg: the entry, a filter or an upstream call
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	// TraceContext returns the trace context of the span, which is propagated to the upstream
+	TraceContext() *TraceContext
+	End()
+}
+
+// SpanOptions tells the tracer where the span is started
+type SpanOptions struct {
+	Kind SpanKind
+	// Parent is the span of the same request which the new span is nested in
+	Parent Span
+	// RemoteParent is the span of the caller, eg: the incoming `traceparent` header
+	RemoteParent *TraceContext
+	// TraceContext is the ids assigned to the root span of the request
+	TraceContext *TraceContext
+}
+
+// Tracer creates and exports the spans, registered by the tracing module when it's enabled
+type Tracer interface {
+	Start(name string, options SpanOptions) Span
+}
+
+// tracerHolder wraps the tracer, as the atomic value can't store a nil or the tracers of different types
+type tracerHolder struct {
+	tracer Tracer
+}
+
+var tracer atomic.Value
+
+func RegisterTracer(t Tracer) {
+	tracer.Store(tracerHolder{tracer: t})
+}
+
+func getTracer() Tracer {
+	if v, ok := tracer.Load().(tracerHolder); ok {
+		return v.tracer
+	}
+	return nil
+}
+
+func IsTracingEnabled() bool {
+	return getTracer() != nil
+}
+
+// StartSpan starts a span of the request, nested in the current span of the request, it becomes the current span
+// until it's ended. the first span of the request uses the trace context assigned by the entry, or joins the trace of
+// the `traceparent` header of the request, eg: the requests replayed from the queue
+func StartSpan(ctx *fasthttp.RequestCtx, name string, kind SpanKind) Span {
+	tracer := getTracer()
+	if tracer == nil {
+		return noopSpan{}
+	}
+
+	options := SpanOptions{Kind: kind}
+	if v, ok := ctx.Get(SpanKey).(Span); ok && v != nil {
+		options.Parent = v
+	} else if v, ok := ctx.Get(TraceContextKey).(*TraceContext); ok && v != nil {
+		options.TraceContext = v
+		options.RemoteParent, _ = ctx.Get(ParentTraceContextKey).(*TraceContext)
+	} else if v := peekHeader(&ctx.Request.Header, HeaderTraceParent); v != nil {
+		options.RemoteParent, _ = ParseTraceParent(string(v))
+	}
+
+	span := tracer.Start(name, options)
+	ctx.Set(SpanKey, span)
+	return &requestSpan{Span: span, ctx: ctx, parent: options.Parent}
+}
+
+// StartHeaderSpan starts a span for the request which is sent without a request context, the parent is taken from the
+// `traceparent` header, and the header is updated to the new span
+func StartHeaderSpan(header *fasthttp.RequestHeader, name string, kind SpanKind) Span {
+	tracer := getTracer()
+	if tracer == nil {
+		return noopSpan{}
+	}
+
+	options := SpanOptions{Kind: kind}
+	if v := peekHeader(header, HeaderTraceParent); v != nil {
+		options.RemoteParent, _ = ParseTraceParent(string(v))
+	}
+
+	span := tracer.Start(name, options)
+	if tc := span.TraceContext(); tc != nil {
+		delHeader(header, HeaderTraceParent)
+		header.Set(HeaderTraceParent, tc.String())
+	}
+	return span
+}
+
+// CurrentSpan returns the current span of the request, which is used to add the attributes
+func CurrentSpan(ctx *fasthttp.RequestCtx) Span {
+	if getTracer() != nil {
+		if v, ok := ctx.Get(SpanKey).(Span); ok && v != nil {
+			return v
+		}
+	}
+	return noopSpan{}
+}
+
+// requestSpan restores the parent as the current span of the request when it's ended
+type requestSpan struct {
+	Span
+	ctx    *fasthttp.RequestCtx
+	parent Span
+}
+
+func (this *requestSpan) End() {
+	this.Span.End()
+	if this.parent != nil {
+		this.ctx.Set(SpanKey, this.parent)
+	} else {
+		this.ctx.Set(SpanKey, nil)
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) TraceContext() *TraceContext {
+	return nil
+}
+
+func (noopSpan) End() {}
@@ -0,0 +1,99 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const UnixSocketPrefix = "unix://"
+
+// IsUnixSocket checks if the address is a unix domain socket, eg: unix:///var/run/gateway.sock
+func IsUnixSocket(addr string) bool {
+	return strings.HasPrefix(addr, UnixSocketPrefix)
+}
+
+func GetUnixSocketPath(addr string) string {
+	return strings.TrimPrefix(addr, UnixSocketPrefix)
+}
+
+// ListenUnixSocket listens on the unix domain socket, the stale socket file is removed,
+// and the permission of the socket file is changed to the mode, eg: 0660
+func ListenUnixSocket(addr string, mode string) (net.Listener, error) {
+	path := GetUnixSocketPath(addr)
+	if path == "" {
+		return nil, fmt.Errorf("invalid unix socket address: %v", addr)
+	}
+
+	if stat, err := os.Stat(path); err == nil {
+		if stat.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("[%v] exists and is not a unix socket", path)
+		}
+		//the socket is still in use by another process
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket [%v] is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid socket mode [%v]: %v", mode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// DialUnixSocket returns a dial func of the fasthttp clients, which connects to the unix domain socket
+func DialUnixSocket(addr string, timeout time.Duration) func(string) (net.Conn, error) {
+	path := GetUnixSocketPath(addr)
+	return func(string) (net.Conn, error) {
+		if timeout > 0 {
+			return net.DialTimeout("unix", path, timeout)
+		}
+		return net.Dial("unix", path)
+	}
+}
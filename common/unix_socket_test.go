@@ -0,0 +1,81 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package common
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.sock")
+	addr := UnixSocketPrefix + path
+	assert.True(t, IsUnixSocket(addr))
+	assert.False(t, IsUnixSocket("127.0.0.1:8000"))
+
+	ln, err := ListenUnixSocket(addr, "0660")
+	assert.Nil(t, err)
+
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), stat.Mode().Perm())
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Write([]byte("pong"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := DialUnixSocket(addr, 0)("localhost:80")
+	assert.Nil(t, err)
+	data, _ := io.ReadAll(conn)
+	assert.Equal(t, "pong", string(data))
+
+	//the socket is in use
+	_, err = ListenUnixSocket(addr, "")
+	assert.NotNil(t, err)
+	ln.Close()
+
+	//the stale socket file is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	assert.Nil(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = ListenUnixSocket(addr, "")
+	assert.Nil(t, err)
+	ln.Close()
+
+	_, err = ListenUnixSocket(addr, "999")
+	assert.NotNil(t, err)
+}
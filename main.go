@@ -49,6 +49,7 @@ import (
 	"infini.sh/gateway/proxy"
 	"infini.sh/gateway/service/floating_ip"
 	"infini.sh/gateway/service/forcemerge"
+	"infini.sh/gateway/service/tracing"
 )
 
 func setup() {
@@ -65,6 +66,7 @@ func setup() {
 
 	module.RegisterUserPlugin(forcemerge.ForceMergeModule{})
 	module.RegisterUserPlugin(floating_ip.FloatingIPPlugin{})
+	module.RegisterUserPlugin(tracing.TracingModule{})
 	module.RegisterUserPlugin(&metrics.MetricsModule{})
 	module.RegisterPluginWithPriority(&proxy.GatewayModule{},200)
 }
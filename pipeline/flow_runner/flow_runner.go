@@ -247,8 +247,16 @@ func (processor *FlowRunnerProcessor) Process(ctx *pipeline.Context) error {
 
 					ctx.SetFlowID(processor.config.FlowName)
 
+					span := common.StartSpan(ctx, "flow_runner "+processor.config.FlowName, common.SpanKindConsumer)
+					span.SetAttribute("flow.name", processor.config.FlowName)
+					span.SetAttribute("queue.name", qConfig.Name)
+					span.SetAttribute("queue.offset", pop.Offset.String())
+
 					flowProcessor(ctx)
 
+					span.SetAttribute("http.response.status_code", ctx.Response.StatusCode())
+					span.End()
+
 					if global.Env().IsDebug {
 						log.Tracef("end forward request to flow:%v", processor.config.FlowName)
 					}
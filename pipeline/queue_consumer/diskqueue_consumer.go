@@ -42,6 +42,7 @@ import (
 	"infini.sh/framework/core/queue"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 var defaultHTTPPool=fasthttp.NewRequestResponsePool("queue_consumer")
@@ -324,6 +325,18 @@ func (processor *DiskQueueConsumer) processMessage(metadata *elastic.Elasticsear
 	resp := defaultHTTPPool.AcquireResponseWithTag("disk_consumer_response")
 	defer defaultHTTPPool.ReleaseResponse(resp)
 
+	if common.IsTracingEnabled() {
+		span := common.StartHeaderSpan(&req.Header, fmt.Sprintf("queue_consumer %s", metadata.Config.Name), common.SpanKindClient)
+		span.SetAttribute("elasticsearch.cluster", metadata.Config.Name)
+		span.SetAttribute("server.address", host)
+		span.SetAttribute("url.path", string(req.PhantomURI().Path()))
+		span.SetAttribute("queue.offset", msg.Offset.String())
+		defer func() {
+			span.SetAttribute("http.response.status_code", resp.StatusCode())
+			span.End()
+		}()
+	}
+
 	acceptGzipped := req.AcceptGzippedResponse()
 	compressed := false
 	if !req.IsGzipped() && processor.config.Compress {
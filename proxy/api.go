@@ -29,6 +29,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/util"
 	"infini.sh/gateway/common"
+	"infini.sh/gateway/proxy/output/elastic"
 	"net/http"
 	"path"
 )
@@ -41,6 +42,8 @@ func (this *GatewayModule) registerAPI(prefix string) {
 	api.HandleAPIMethod(api.POST, path.Join("/", prefix, "/entry/:id/_start"), this.startEntry)
 	api.HandleAPIMethod(api.POST, path.Join("/", prefix, "/entry/:id/_stop"), this.stopEntry)
 	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/entry/:id"), this.getConfig)
+	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/entry/:id/_certificates"), this.getCertificates)
+	api.HandleAPIMethod(api.GET, path.Join("/", prefix, "/elasticsearch/_circuit_breakers"), this.getCircuitBreakers)
 }
 
 func (this *GatewayModule) getConfig(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -91,3 +94,21 @@ func (this *GatewayModule) stopEntry(w http.ResponseWriter, req *http.Request, p
 		this.Error404(w)
 	}
 }
+
+func (this *GatewayModule) getCertificates(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	v, ok := this.entryPoints[id]
+	if ok {
+		this.WriteJSON(w, util.MapStr{
+			"certificates": v.GetCertificates(),
+		}, 200)
+	} else {
+		this.Error404(w)
+	}
+}
+
+func (this *GatewayModule) getCircuitBreakers(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	this.WriteJSON(w, util.MapStr{
+		"circuit_breakers": elastic.GetCircuitBreakerStates(),
+	}, 200)
+}
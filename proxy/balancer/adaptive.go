@@ -0,0 +1,144 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package balancer
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"infini.sh/framework/core/errors"
+)
+
+const (
+	WeightedRoundRobin = "weight"
+	LeastRequests      = "least_requests"
+	EWMALatency        = "ewma"
+	PowerOfTwoChoices  = "p2c"
+)
+
+// the latency of the endpoint without any samples, so it's probed, but not flooded
+const minLatency = float64(time.Millisecond)
+
+// NewBalancerByName creates the balancer by the name, the balancers except `weight` choose the endpoint by the
+// in-flight requests and the latency tracked by the stats, which are aligned with the weights
+func NewBalancerByName(name string, ws []int, stats []*EndpointStats) (IBalancer, error) {
+	if name == "" || name == WeightedRoundRobin {
+		return NewBalancer(ws), nil
+	}
+
+	if len(ws) == 0 || len(ws) != len(stats) {
+		return nil, errors.Errorf("weights %v and stats of %v endpoints are mismatched", ws, len(stats))
+	}
+
+	b := statsBalancer{weights: make([]float64, len(ws)), stats: stats}
+	for i, w := range ws {
+		if w <= 0 {
+			w = 1
+		}
+		b.weights[i] = float64(w)
+	}
+
+	switch name {
+	case LeastRequests:
+		b.score = leastRequestsScore
+		return &b, nil
+	case EWMALatency:
+		b.score = latencyScore
+		return &b, nil
+	case PowerOfTwoChoices:
+		b.score = latencyScore
+		return &p2cBalancer{statsBalancer: b}, nil
+	}
+	return nil, errors.Errorf("unknown balancer: %v", name)
+}
+
+// statsBalancer chooses the endpoint with the lowest score, the ties are broken in turn
+type statsBalancer struct {
+	weights []float64
+	stats   []*EndpointStats
+	score   func(stats *EndpointStats, weight float64, now time.Time) float64
+	next    uint64
+}
+
+func (this *statsBalancer) Distribute() int {
+	n := len(this.stats)
+	if n == 1 {
+		return 0
+	}
+
+	now := time.Now()
+	start := int(atomic.AddUint64(&this.next, 1) % uint64(n))
+	best := start
+	bestScore := this.score(this.stats[start], this.weights[start], now)
+	for i := 1; i < n; i++ {
+		idx := (start + i) % n
+		score := this.score(this.stats[idx], this.weights[idx], now)
+		if score < bestScore {
+			best = idx
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// p2cBalancer picks two random endpoints and chooses the one with the lower score, it's cheaper than a full scan,
+// and avoids all the gateways herding to the same best endpoint
+type p2cBalancer struct {
+	statsBalancer
+}
+
+func (this *p2cBalancer) Distribute() int {
+	n := len(this.stats)
+	if n == 1 {
+		return 0
+	}
+
+	a := rand.Intn(n)
+	b := rand.Intn(n - 1)
+	if b >= a {
+		b++
+	}
+
+	now := time.Now()
+	if this.score(this.stats[b], this.weights[b], now) < this.score(this.stats[a], this.weights[a], now) {
+		return b
+	}
+	return a
+}
+
+func leastRequestsScore(stats *EndpointStats, weight float64, now time.Time) float64 {
+	return float64(stats.Inflight()+1) / weight
+}
+
+func latencyScore(stats *EndpointStats, weight float64, now time.Time) float64 {
+	latency := stats.decayedLatency(now)
+	if latency < minLatency {
+		latency = minLatency
+	}
+	return latency * float64(stats.Inflight()+1) / weight
+}
@@ -0,0 +1,147 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStats(n int) []*EndpointStats {
+	stats := []*EndpointStats{}
+	for i := 0; i < n; i++ {
+		stats = append(stats, NewEndpointStats())
+	}
+	return stats
+}
+
+func TestLeastRequestsBalancer(t *testing.T) {
+	stats := newStats(3)
+	b, err := NewBalancerByName(LeastRequests, []int{1, 1, 1}, stats)
+	assert.Nil(t, err)
+
+	//idle endpoints are chosen in turn
+	seen := map[int]bool{}
+	for i := 0; i < 3; i++ {
+		seen[b.Distribute()] = true
+	}
+	assert.Equal(t, 3, len(seen))
+
+	stats[0].Acquire()
+	stats[0].Acquire()
+	stats[2].Acquire()
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, b.Distribute())
+	}
+}
+
+func TestEWMABalancer(t *testing.T) {
+	stats := newStats(3)
+	b, err := NewBalancerByName(EWMALatency, []int{1, 1, 1}, stats)
+	assert.Nil(t, err)
+
+	for _, v := range stats {
+		v.Acquire()
+	}
+	stats[0].Release(200*time.Millisecond, false)
+	stats[1].Release(10*time.Millisecond, false)
+	stats[2].Release(50*time.Millisecond, true)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, b.Distribute())
+	}
+
+	//the fast endpoint is still preferred with a few requests in flight
+	stats[1].Acquire()
+	stats[1].Acquire()
+	assert.Equal(t, 1, b.Distribute())
+
+	//but not when it's piled up
+	for i := 0; i < 20; i++ {
+		stats[1].Acquire()
+	}
+	assert.NotEqual(t, 1, b.Distribute())
+}
+
+func TestP2CBalancer(t *testing.T) {
+	stats := newStats(2)
+	b, err := NewBalancerByName(PowerOfTwoChoices, []int{1, 1}, stats)
+	assert.Nil(t, err)
+
+	stats[0].Acquire()
+	stats[0].Release(100*time.Millisecond, false)
+	stats[1].Acquire()
+	stats[1].Release(5*time.Millisecond, false)
+
+	//the two choices are always both endpoints
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, b.Distribute())
+	}
+
+	b, err = NewBalancerByName(PowerOfTwoChoices, []int{1, 1, 1, 1, 1}, newStats(5))
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		idx := b.Distribute()
+		assert.True(t, idx >= 0 && idx < 5)
+	}
+}
+
+func TestNewBalancerByName(t *testing.T) {
+	b, err := NewBalancerByName("", []int{1, 2}, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, b)
+
+	_, err = NewBalancerByName(LeastRequests, []int{1, 2}, newStats(1))
+	assert.NotNil(t, err)
+
+	_, err = NewBalancerByName("unknown", []int{1}, newStats(1))
+	assert.NotNil(t, err)
+}
+
+func TestEndpointStats(t *testing.T) {
+	stats := NewEndpointStats()
+	stats.Acquire()
+	assert.Equal(t, int64(1), stats.Inflight())
+	stats.Release(100*time.Millisecond, false)
+	assert.Equal(t, int64(0), stats.Inflight())
+
+	stats.Acquire()
+	stats.Release(200*time.Millisecond, false)
+	assert.InDelta(t, float64(130*time.Millisecond), float64(stats.Latency()), float64(time.Millisecond))
+
+	//the failed request counts as a slow request
+	stats.Acquire()
+	stats.Release(time.Millisecond, true)
+	assert.Equal(t, int64(1), stats.Failures())
+	assert.True(t, stats.Latency() > 300*time.Millisecond)
+
+	//the latency decays when the endpoint is idle
+	now := time.Now().Add(ewmaDecay)
+	assert.True(t, stats.decayedLatency(now) < float64(stats.Latency())/2)
+}
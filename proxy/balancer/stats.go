@@ -0,0 +1,116 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package balancer
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the weight of the new latency sample in the moving average
+	ewmaAlpha = 0.3
+	// the latency of an idle endpoint decays over time, so it's probed again
+	ewmaDecay = 10 * time.Second
+	// the failed requests count as slow requests, so the failing endpoint is avoided
+	failurePenalty = time.Second
+)
+
+// EndpointStats tracks the in-flight requests and the moving average latency of an endpoint, lock free
+type EndpointStats struct {
+	inflight int64
+	requests int64
+	failures int64
+	latency  uint64 //math.Float64bits of the average latency in nanoseconds
+	updated  int64  //unix nano of the last sample
+}
+
+func NewEndpointStats() *EndpointStats {
+	return &EndpointStats{}
+}
+
+// Acquire marks a request is sent to the endpoint
+func (this *EndpointStats) Acquire() {
+	atomic.AddInt64(&this.inflight, 1)
+}
+
+// Release marks the request is finished, and records the latency
+func (this *EndpointStats) Release(latency time.Duration, failed bool) {
+	atomic.AddInt64(&this.inflight, -1)
+	atomic.AddInt64(&this.requests, 1)
+	if failed {
+		atomic.AddInt64(&this.failures, 1)
+		if latency < failurePenalty {
+			latency = failurePenalty
+		}
+	}
+
+	sample := float64(latency)
+	for {
+		old := atomic.LoadUint64(&this.latency)
+		avg := math.Float64frombits(old)
+		if avg == 0 {
+			avg = sample
+		} else {
+			avg = avg + ewmaAlpha*(sample-avg)
+		}
+		if atomic.CompareAndSwapUint64(&this.latency, old, math.Float64bits(avg)) {
+			break
+		}
+	}
+	atomic.StoreInt64(&this.updated, time.Now().UnixNano())
+}
+
+func (this *EndpointStats) Inflight() int64 {
+	return atomic.LoadInt64(&this.inflight)
+}
+
+func (this *EndpointStats) Requests() int64 {
+	return atomic.LoadInt64(&this.requests)
+}
+
+func (this *EndpointStats) Failures() int64 {
+	return atomic.LoadInt64(&this.failures)
+}
+
+// Latency returns the moving average latency, decayed by the time since the last sample
+func (this *EndpointStats) Latency() time.Duration {
+	return time.Duration(this.decayedLatency(time.Now()))
+}
+
+func (this *EndpointStats) decayedLatency(now time.Time) float64 {
+	avg := math.Float64frombits(atomic.LoadUint64(&this.latency))
+	if avg == 0 {
+		return 0
+	}
+	elapsed := now.UnixNano() - atomic.LoadInt64(&this.updated)
+	if elapsed <= 0 {
+		return avg
+	}
+	return avg * math.Exp(-float64(elapsed)/float64(ewmaDecay))
+}
@@ -0,0 +1,191 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// Adapter accepts the inbound data of a protocol, converts each unit of data into a request,
+// and runs it through the router and the filter flows of the entry
+type Adapter interface {
+	Name() string
+	Start(handler fasthttp.RequestHandler) error
+	Stop() error
+}
+
+// AdapterFactory creates the adapter of an entry, the adapter specific settings are in the `adapter` section of the entry
+type AdapterFactory func(entry common.EntryConfig, cfg *config.Config) (Adapter, error)
+
+var adapterLock = sync.RWMutex{}
+var adapters = map[string]AdapterFactory{}
+
+func RegisterAdapter(name string, factory AdapterFactory) {
+	adapterLock.Lock()
+	defer adapterLock.Unlock()
+	adapters[name] = factory
+}
+
+func NewAdapter(name string, entry common.EntryConfig, cfg *config.Config) (Adapter, error) {
+	adapterLock.RLock()
+	factory, ok := adapters[name]
+	adapterLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("adapter [%v] not found", name)
+	}
+	if cfg == nil {
+		var err error
+		cfg, err = config.NewConfigFrom(map[string]interface{}{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return factory(entry, cfg)
+}
+
+const RemoteAddrKey = "adapter_remote_addr"
+const AdapterHeader = "X-Gateway-Adapter"
+
+// RequestConfig controls how the units of data are presented to the filter flows
+type RequestConfig struct {
+	Method  string            `config:"method"`
+	Path    string            `config:"path"`
+	Headers map[string]string `config:"headers"`
+}
+
+// Message is a unit of data received by the adapter
+type Message struct {
+	Body       []byte
+	Path       string
+	Headers    map[string]string
+	RemoteAddr net.Addr
+	//values placed in the request context
+	Values map[string]interface{}
+	//a request encoded by fasthttp, eg: written by the kafka filter, it is decoded instead of built from the body
+	Request []byte
+}
+
+var ctxPool = &sync.Pool{
+	New: func() interface{} {
+		c := fasthttp.RequestCtx{}
+		return &c
+	},
+}
+
+func acquireCtx() *fasthttp.RequestCtx {
+	ctx := ctxPool.Get().(*fasthttp.RequestCtx)
+	ctx.Reset()
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	return ctx
+}
+
+func releaseCtx(ctx *fasthttp.RequestCtx) {
+	ctx.Reset()
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctxPool.Put(ctx)
+}
+
+// Process converts the message into a request and runs the handler,
+// the callback is invoked with the processed context before it is released
+func (cfg *RequestConfig) Process(adapter string, msg Message, handler fasthttp.RequestHandler, callback func(ctx *fasthttp.RequestCtx)) {
+	ctx := acquireCtx()
+	defer releaseCtx(ctx)
+
+	for k, v := range msg.Values {
+		ctx.Set(k, v)
+	}
+	if msg.RemoteAddr != nil {
+		ctx.Set(RemoteAddrKey, msg.RemoteAddr.String())
+	}
+
+	if msg.Request != nil {
+		if err := ctx.Request.Decode(msg.Request); err != nil {
+			log.Errorf("failed to decode the request of %v adapter: %v", adapter, err)
+			ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
+		} else {
+			ctx.Request.Header.Set(AdapterHeader, adapter)
+			handler(ctx)
+		}
+		if callback != nil {
+			callback(ctx)
+		}
+		return
+	}
+
+	method := cfg.Method
+	if method == "" {
+		method = fasthttp.MethodPost
+	}
+	path := msg.Path
+	if path == "" {
+		path = cfg.Path
+	}
+	if path == "" {
+		path = "/" + adapter
+	}
+
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(path)
+	ctx.Request.Header.Set(AdapterHeader, adapter)
+	for k, v := range cfg.Headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	for k, v := range msg.Headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	ctx.Request.SetBody(msg.Body)
+
+	handler(ctx)
+
+	if callback != nil {
+		callback(ctx)
+	}
+}
+
+// buildBulk builds the body of a bulk request which indexes the documents into the index
+func buildBulk(index string, docs [][]byte) []byte {
+	action := util.MustToJSONBytes(util.MapStr{"index": util.MapStr{"_index": index}})
+	buf := bytes.Buffer{}
+	for _, v := range docs {
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(v)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
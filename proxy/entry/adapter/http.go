@@ -22,3 +22,6 @@
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
 package adapter
+
+// HTTP is the default type of the entries, which is served by the built-in http server of the entry
+const HTTP = "http"
@@ -21,4 +21,376 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
 package adapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// KafkaAdapter joins a consumer group, and replays the records through the flows,
+// the offsets are only committed after the records are processed successfully, or sent to the dead letter
+type KafkaAdapter struct {
+	RequestConfig   `config:",inline"`
+	Brokers         []string `config:"brokers"`
+	Topics          []string `config:"topics"`
+	Group           string   `config:"group"`
+	StartOffset     string   `config:"start_offset"`
+	Codec           string   `config:"codec"`
+	BulkIndex       string   `config:"bulk_index"`
+	Flow            string   `config:"flow"`
+	DeadLetterTopic string   `config:"dead_letter_topic"`
+	DeadLetterQueue string   `config:"dead_letter_queue"`
+	RetryDelay      string   `config:"retry_delay"`
+	MaxRetries      int      `config:"max_retries"`
+	MaxPollRecords  int      `config:"max_poll_records"`
+
+	retryDelay time.Duration
+	handler    fasthttp.RequestHandler
+	client     *kgo.Client
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+const (
+	CodecRequest   = "request"
+	CodecBulk      = "bulk"
+	CodecJSON      = "json"
+	CodecJSONLines = "json_lines"
+)
+
+func init() {
+	RegisterAdapter("kafka", NewKafkaAdapter)
+}
+
+func NewKafkaAdapter(entry common.EntryConfig, c *config.Config) (Adapter, error) {
+	adapter := KafkaAdapter{
+		StartOffset:    "earliest",
+		Codec:          CodecRequest,
+		RetryDelay:     "5s",
+		MaxRetries:     10,
+		MaxPollRecords: 1000,
+	}
+	if err := c.Unpack(&adapter); err != nil {
+		return nil, fmt.Errorf("failed to unpack the adapter configuration : %s", err)
+	}
+
+	if len(adapter.Brokers) == 0 || len(adapter.Topics) == 0 || adapter.Group == "" {
+		return nil, errors.New("brokers, topics and group are required for kafka adapter")
+	}
+
+	switch adapter.Codec {
+	case CodecRequest, CodecBulk, CodecJSON, CodecJSONLines:
+	default:
+		return nil, fmt.Errorf("invalid codec [%v]", adapter.Codec)
+	}
+
+	if adapter.Path == "" {
+		if adapter.BulkIndex != "" || adapter.Codec == CodecBulk {
+			adapter.Path = "/_bulk"
+		}
+	}
+
+	adapter.retryDelay = util.GetDurationOrDefault(adapter.RetryDelay, 5*time.Second)
+	return &adapter, nil
+}
+
+func (adapter *KafkaAdapter) Name() string {
+	return "kafka"
+}
+
+func (adapter *KafkaAdapter) Start(handler fasthttp.RequestHandler) error {
+	offset := kgo.NewOffset().AtStart()
+	if adapter.StartOffset == "latest" {
+		offset = kgo.NewOffset().AtEnd()
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(adapter.Brokers...),
+		kgo.ConsumerGroup(adapter.Group),
+		kgo.ConsumeTopics(adapter.Topics...),
+		kgo.ConsumeResetOffset(offset),
+		kgo.DisableAutoCommit(),
+		//no rebalance while the polled records are in process
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return err
+	}
+	adapter.client = client
+
+	//the flow is resolved for each record, to pick up the reloaded flow
+	if adapter.Flow != "" {
+		if _, err := common.GetFlow(adapter.Flow); err != nil {
+			client.Close()
+			return err
+		}
+	}
+	adapter.handler = handler
+
+	adapter.ctx, adapter.cancel = context.WithCancel(context.Background())
+	adapter.done = make(chan struct{})
+	go adapter.consume()
+	return nil
+}
+
+func (adapter *KafkaAdapter) getHandler() fasthttp.RequestHandler {
+	if adapter.Flow != "" {
+		return common.GetFlowProcess(adapter.Flow)
+	}
+	return adapter.handler
+}
+
+func (adapter *KafkaAdapter) consume() {
+	defer close(adapter.done)
+
+	for {
+		fetches := adapter.client.PollRecords(adapter.ctx, adapter.MaxPollRecords)
+		if fetches.IsClientClosed() || adapter.ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Errorf("failed to fetch from kafka, topic: %v, partition: %v, %v", topic, partition, err)
+		})
+
+		//partitions are processed in parallel, the records of a partition in order
+		wg := sync.WaitGroup{}
+		lock := sync.Mutex{}
+		var committable []*kgo.Record
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			if len(p.Records) == 0 {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				last := adapter.processPartition(p.Records)
+				if last != nil {
+					lock.Lock()
+					committable = append(committable, last)
+					lock.Unlock()
+				}
+			}()
+		})
+		wg.Wait()
+
+		if len(committable) > 0 {
+			if err := adapter.client.CommitRecords(context.Background(), committable...); err != nil {
+				log.Errorf("failed to commit kafka offsets: %v", err)
+			}
+		}
+		adapter.client.AllowRebalance()
+	}
+}
+
+// processPartition returns the last record done, which is safe to commit, a record is done once it's processed
+// successfully or sent to the dead letter, the partition stops at the record which is not done when the adapter stops
+func (adapter *KafkaAdapter) processPartition(records []*kgo.Record) *kgo.Record {
+	var last *kgo.Record
+	for _, record := range records {
+		for retries := 0; ; retries++ {
+			err := adapter.process(record)
+			if err == nil {
+				break
+			}
+
+			log.Warnf("failed to process kafka record, topic: %v, partition: %v, offset: %v, %v", record.Topic, record.Partition, record.Offset, err)
+
+			//the record is sent to the dead letter after the max retries, and retried until it's taken,
+			//without a dead letter it's retried until success, the rebalance is blocked while retrying
+			if adapter.MaxRetries >= 0 && retries >= adapter.MaxRetries {
+				if adapter.deadLetter(record, err) {
+					break
+				}
+				if retries == adapter.MaxRetries {
+					log.Errorf("kafka record is not taken by a dead letter after %v retries, keep retrying, topic: %v, partition: %v, offset: %v", retries, record.Topic, record.Partition, record.Offset)
+				}
+			}
+
+			select {
+			case <-adapter.ctx.Done():
+				return last
+			case <-time.After(adapter.retryDelay):
+			}
+		}
+		last = record
+	}
+	return last
+}
+
+func (adapter *KafkaAdapter) process(record *kgo.Record) (err error) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic in flow: %v", r)
+			}
+		}
+	}()
+
+	msg := Message{
+		Headers: map[string]string{},
+		Values: map[string]interface{}{
+			"kafka_topic":     record.Topic,
+			"kafka_partition": record.Partition,
+			"kafka_offset":    record.Offset,
+		},
+	}
+	for _, h := range record.Headers {
+		msg.Headers[h.Key] = string(h.Value)
+	}
+
+	body, err := DecodeKafkaRecord(adapter.Codec, adapter.BulkIndex, record.Value)
+	if err != nil {
+		return err
+	}
+	switch adapter.Codec {
+	case CodecRequest:
+		msg.Request = body
+	case CodecJSON:
+		msg.Body = body
+		if adapter.BulkIndex == "" {
+			msg.Headers["Content-Type"] = util.ContentTypeJson
+			if adapter.Path == "" {
+				msg.Path = "/" + record.Topic + "/_doc"
+			}
+		} else {
+			msg.Headers["Content-Type"] = "application/x-ndjson"
+		}
+	default:
+		msg.Body = body
+		msg.Headers["Content-Type"] = "application/x-ndjson"
+	}
+
+	adapter.Process(adapter.Name(), msg, adapter.getHandler(), func(ctx *fasthttp.RequestCtx) {
+		err = CheckResponse(ctx)
+	})
+	return err
+}
+
+// CheckResponse returns the error of the response of the flow, the failed items of the bulk requests included
+func CheckResponse(ctx *fasthttp.RequestCtx) error {
+	status := ctx.Response.StatusCode()
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("flow responded with status %v: %s", status, util.SubString(string(ctx.Response.Body()), 0, 256))
+	}
+	//the bulk requests succeed with the failures of the items
+	if bytes.HasSuffix(ctx.Request.URI().Path(), []byte("/_bulk")) {
+		return CheckBulkResponse(ctx.Response.GetRawBody())
+	}
+	return nil
+}
+
+// CheckBulkResponse returns the error of the failed items of a bulk response
+func CheckBulkResponse(body []byte) error {
+	if failed, _ := jsonparser.GetBoolean(body, "errors"); !failed {
+		return nil
+	}
+
+	var count int
+	var reason string
+	jsonparser.ArrayEach(body, func(item []byte, dataType jsonparser.ValueType, offset int, err error) {
+		jsonparser.ObjectEach(item, func(action []byte, result []byte, dataType jsonparser.ValueType, offset int) error {
+			status, _ := jsonparser.GetInt(result, "status")
+			if status >= 200 && status < 300 {
+				return nil
+			}
+			count++
+			if reason == "" {
+				reason, _ = jsonparser.GetString(result, "error", "reason")
+				reason = fmt.Sprintf("status %v, %v", status, reason)
+			}
+			return nil
+		})
+	}, "items")
+	return fmt.Errorf("%v items of the bulk request failed, first error: %v", count, reason)
+}
+
+// DecodeKafkaRecord converts the value of a record into the body of a request
+func DecodeKafkaRecord(codec, bulkIndex string, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecRequest, CodecBulk:
+		return value, nil
+	case CodecJSON:
+		value = bytes.TrimSpace(value)
+		if len(value) == 0 || value[0] != '{' {
+			return nil, errors.New("invalid json document")
+		}
+		if bulkIndex == "" {
+			return value, nil
+		}
+		return buildBulk(bulkIndex, [][]byte{value}), nil
+	case CodecJSONLines:
+		var docs [][]byte
+		for _, line := range bytes.Split(value, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			if line[0] != '{' {
+				return nil, errors.New("invalid json document")
+			}
+			docs = append(docs, line)
+		}
+		if bulkIndex == "" {
+			return bytes.Join(docs, []byte("\n")), nil
+		}
+		return buildBulk(bulkIndex, docs), nil
+	}
+	return nil, fmt.Errorf("invalid codec [%v]", codec)
+}
+
+// deadLetter sends the failed record to the dead letter topic or queue, returns true if the record was taken
+func (adapter *KafkaAdapter) deadLetter(record *kgo.Record, cause error) bool {
+	if adapter.DeadLetterTopic != "" {
+		headers := append([]kgo.RecordHeader{}, record.Headers...)
+		dead := &kgo.Record{
+			Topic:   adapter.DeadLetterTopic,
+			Key:     record.Key,
+			Value:   record.Value,
+			Headers: append(headers, kgo.RecordHeader{Key: "x-gateway-error", Value: []byte(cause.Error())}),
+		}
+		if err := adapter.client.ProduceSync(context.Background(), dead).FirstErr(); err != nil {
+			log.Errorf("failed to send record to dead letter topic [%v]: %v", adapter.DeadLetterTopic, err)
+			return false
+		}
+		return true
+	}
+
+	if adapter.DeadLetterQueue != "" {
+		if err := queue.Push(queue.GetOrInitConfig(adapter.DeadLetterQueue), record.Value); err != nil {
+			log.Errorf("failed to push record to dead letter queue [%v]: %v", adapter.DeadLetterQueue, err)
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+func (adapter *KafkaAdapter) Stop() error {
+	if adapter.client == nil {
+		return nil
+	}
+	adapter.cancel()
+	<-adapter.done
+	adapter.client.Close()
+	return nil
+}
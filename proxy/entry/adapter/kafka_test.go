@@ -0,0 +1,114 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestDecodeKafkaRecord(t *testing.T) {
+	body, err := DecodeKafkaRecord(CodecBulk, "", []byte("{\"index\":{}}\n{\"a\":1}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"index\":{}}\n{\"a\":1}\n", string(body))
+
+	body, err = DecodeKafkaRecord(CodecJSON, "", []byte(" {\"a\":1}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"a\":1}", string(body))
+
+	body, err = DecodeKafkaRecord(CodecJSON, "logs", []byte("{\"a\":1}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"index\":{\"_index\":\"logs\"}}\n{\"a\":1}\n", string(body))
+
+	_, err = DecodeKafkaRecord(CodecJSON, "", []byte("plain text"))
+	assert.Error(t, err)
+
+	body, err = DecodeKafkaRecord(CodecJSONLines, "", []byte("{\"a\":1}\n\n{\"a\":2}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"a\":1}\n{\"a\":2}", string(body))
+
+	body, err = DecodeKafkaRecord(CodecJSONLines, "logs", []byte("{\"a\":1}\r\n{\"a\":2}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"index\":{\"_index\":\"logs\"}}\n{\"a\":1}\n{\"index\":{\"_index\":\"logs\"}}\n{\"a\":2}\n", string(body))
+
+	_, err = DecodeKafkaRecord(CodecJSONLines, "logs", []byte("{\"a\":1}\nbroken"))
+	assert.Error(t, err)
+
+	_, err = DecodeKafkaRecord("avro", "", []byte("{}"))
+	assert.Error(t, err)
+}
+
+func TestCheckBulkResponse(t *testing.T) {
+	assert.NoError(t, CheckBulkResponse([]byte(`{"took":3,"errors":false,"items":[{"index":{"_index":"logs","status":201}}]}`)))
+
+	err := CheckBulkResponse([]byte(`{"took":3,"errors":true,"items":[
+		{"index":{"_index":"logs","status":201}},
+		{"index":{"_index":"logs","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [a]"}}},
+		{"create":{"_index":"logs","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}}
+	]}`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 items")
+	assert.Contains(t, err.Error(), "failed to parse field [a]")
+}
+
+func TestProcessPartitionWithoutDeadLetter(t *testing.T) {
+	calls := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	adapter := &KafkaAdapter{
+		Codec:      CodecJSON,
+		BulkIndex:  "logs",
+		MaxRetries: 2,
+		retryDelay: time.Millisecond,
+		ctx:        ctx,
+		handler: func(ctx *fasthttp.RequestCtx) {
+			calls++
+			ctx.Response.SetStatusCode(200)
+			if bytes.Contains(ctx.Request.Body(), []byte(`"a":2`)) {
+				ctx.Response.SetBody([]byte(`{"errors":true,"items":[{"index":{"status":400,"error":{"reason":"bad"}}}]}`))
+				if calls >= 10 {
+					cancel()
+				}
+				return
+			}
+			ctx.Response.SetBody([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		},
+	}
+	adapter.Path = "/_bulk"
+
+	records := []*kgo.Record{{Value: []byte(`{"a":1}`)}, {Value: []byte(`{"a":2}`), Offset: 1}, {Value: []byte(`{"a":3}`), Offset: 2}}
+	last := adapter.processPartition(records)
+
+	//the failed record is retried beyond the max retries, and never committed
+	assert.Equal(t, 10, calls)
+	assert.Equal(t, records[0], last)
+}
@@ -21,4 +21,360 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
 package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// SyslogAdapter receives syslog messages over udp and tcp, and converts them into json documents,
+// the documents are sent one by one, or in micro batches
+type SyslogAdapter struct {
+	RequestConfig  `config:",inline"`
+	Protocols      []string `config:"protocol"`
+	MaxMessageSize int      `config:"max_message_size"`
+	BatchSize      int      `config:"batch_size"`
+	BatchTimeout   string   `config:"batch_timeout"`
+	BulkIndex      string   `config:"bulk_index"`
+	WorkerSize     int      `config:"worker_size"`
+
+	address      string
+	batchTimeout time.Duration
+	udpConn      net.PacketConn
+	tcpListener  net.Listener
+	conns        sync.Map
+	lock         sync.Mutex
+	closed       bool
+	docs         chan []byte
+	batches      chan [][]byte
+	readers      sync.WaitGroup
+	workers      sync.WaitGroup
+}
+
+func init() {
+	RegisterAdapter("syslog", NewSyslogAdapter)
+}
+
+func NewSyslogAdapter(entry common.EntryConfig, c *config.Config) (Adapter, error) {
+	adapter := SyslogAdapter{
+		Protocols:      []string{"udp", "tcp"},
+		MaxMessageSize: 64 * 1024,
+		BatchSize:      1,
+		BatchTimeout:   "1s",
+		WorkerSize:     10,
+	}
+	if err := c.Unpack(&adapter); err != nil {
+		return nil, fmt.Errorf("failed to unpack the adapter configuration : %s", err)
+	}
+	for _, v := range adapter.Protocols {
+		if v != "udp" && v != "tcp" {
+			return nil, fmt.Errorf("invalid syslog protocol [%v]", v)
+		}
+	}
+	if adapter.BatchSize <= 0 {
+		adapter.BatchSize = 1
+	}
+	if adapter.WorkerSize <= 0 {
+		adapter.WorkerSize = 1
+	}
+	if adapter.BulkIndex != "" && adapter.Path == "" {
+		adapter.Path = "/_bulk"
+	}
+	adapter.batchTimeout = util.GetDurationOrDefault(adapter.BatchTimeout, time.Second)
+	adapter.address = entry.NetworkConfig.GetBindingAddr()
+	return &adapter, nil
+}
+
+func (adapter *SyslogAdapter) Name() string {
+	return "syslog"
+}
+
+func (adapter *SyslogAdapter) Start(handler fasthttp.RequestHandler) error {
+	for _, v := range adapter.Protocols {
+		var err error
+		if v == "udp" {
+			adapter.udpConn, err = net.ListenPacket("udp", adapter.address)
+		} else {
+			adapter.tcpListener, err = net.Listen("tcp", adapter.address)
+		}
+		if err != nil {
+			adapter.closeListeners()
+			return err
+		}
+	}
+
+	adapter.docs = make(chan []byte, adapter.BatchSize*adapter.WorkerSize*2)
+	adapter.batches = make(chan [][]byte, adapter.WorkerSize)
+
+	for i := 0; i < adapter.WorkerSize; i++ {
+		adapter.workers.Add(1)
+		go func() {
+			defer adapter.workers.Done()
+			for batch := range adapter.batches {
+				adapter.process(batch, handler)
+			}
+		}()
+	}
+	go adapter.batch()
+
+	if adapter.udpConn != nil {
+		adapter.readers.Add(1)
+		go adapter.readUDP()
+	}
+	if adapter.tcpListener != nil {
+		adapter.readers.Add(1)
+		go adapter.acceptTCP()
+	}
+	return nil
+}
+
+func (adapter *SyslogAdapter) readUDP() {
+	defer adapter.readers.Done()
+	buf := make([]byte, adapter.MaxMessageSize)
+	for {
+		n, addr, err := adapter.udpConn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		adapter.docs <- adapter.encode(buf[:n], addr)
+	}
+}
+
+func (adapter *SyslogAdapter) acceptTCP() {
+	defer adapter.readers.Done()
+	for {
+		conn, err := adapter.tcpListener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if !adapter.track(conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer func() {
+				conn.Close()
+				adapter.conns.Delete(conn)
+				adapter.readers.Done()
+			}()
+			reader := bufio.NewReaderSize(conn, 64*1024)
+			for {
+				frame, err := ReadSyslogFrame(reader, adapter.MaxMessageSize)
+				if len(frame) > 0 {
+					adapter.docs <- adapter.encode(frame, conn.RemoteAddr())
+				}
+				if err != nil {
+					if err != io.EOF && global.Env().IsDebug {
+						log.Debugf("syslog connection from %v closed: %v", conn.RemoteAddr(), err)
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+// track keeps the connection to be closed on stop, returns false if the adapter is stopped
+func (adapter *SyslogAdapter) track(conn net.Conn) bool {
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+	if adapter.closed {
+		return false
+	}
+	adapter.conns.Store(conn, struct{}{})
+	adapter.readers.Add(1)
+	return true
+}
+
+// ReadSyslogFrame reads a message framed by octet counting or by newline, see RFC 6587
+func ReadSyslogFrame(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] >= '1' && b[0] <= '9' {
+			//octet counting, eg: 67 <34>1 ...
+			size, err := readFrameLength(reader)
+			if err != nil {
+				return nil, err
+			}
+			if size > maxSize {
+				return nil, fmt.Errorf("invalid frame length [%v]", size)
+			}
+			frame := make([]byte, size)
+			_, err = io.ReadFull(reader, frame)
+			return frame, err
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || len(line) > maxSize {
+			return nil, fmt.Errorf("message exceeds the max size %v", maxSize)
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 && err == nil {
+			continue
+		}
+		return append([]byte(nil), line...), err
+	}
+}
+
+// maxFrameLengthDigits limits the digits of the octet count, so a malformed frame can't be read without bound
+const maxFrameLengthDigits = 10
+
+// readFrameLength reads the octet count followed by a space
+func readFrameLength(reader *bufio.Reader) (int, error) {
+	size := 0
+	for i := 0; ; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b == ' ' && i > 0 {
+			return size, nil
+		}
+		if b < '0' || b > '9' || i >= maxFrameLengthDigits {
+			return 0, fmt.Errorf("invalid frame length, unexpected [%q] at [%v]", b, i)
+		}
+		size = size*10 + int(b-'0')
+	}
+}
+
+func (adapter *SyslogAdapter) encode(data []byte, addr net.Addr) []byte {
+	msg, err := ParseSyslogMessage(data, time.Now())
+	if err != nil {
+		return util.MustToJSONBytes(util.MapStr{
+			"format":      "unknown",
+			"message":     string(data),
+			"source":      addr.String(),
+			"parse_error": err.Error(),
+		})
+	}
+	msg.Source = addr.String()
+
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(msg)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+func (adapter *SyslogAdapter) batch() {
+	defer close(adapter.batches)
+
+	ticker := time.NewTicker(adapter.batchTimeout)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	for {
+		select {
+		case doc, ok := <-adapter.docs:
+			if !ok {
+				if len(batch) > 0 {
+					adapter.batches <- batch
+				}
+				return
+			}
+			batch = append(batch, doc)
+			if len(batch) >= adapter.BatchSize {
+				adapter.batches <- batch
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				adapter.batches <- batch
+				batch = nil
+			}
+		}
+	}
+}
+
+func (adapter *SyslogAdapter) process(batch [][]byte, handler fasthttp.RequestHandler) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				log.Errorf("error in syslog adapter: %v", r)
+			}
+		}
+	}()
+
+	msg := Message{Headers: map[string]string{}}
+	if adapter.BulkIndex != "" {
+		msg.Body = buildBulk(adapter.BulkIndex, batch)
+		msg.Headers["Content-Type"] = "application/x-ndjson"
+	} else if len(batch) == 1 {
+		msg.Body = batch[0]
+		msg.Headers["Content-Type"] = util.ContentTypeJson
+	} else {
+		buf := bytes.Buffer{}
+		for _, v := range batch {
+			buf.Write(v)
+			buf.WriteByte('\n')
+		}
+		msg.Body = buf.Bytes()
+		msg.Headers["Content-Type"] = "application/x-ndjson"
+	}
+
+	adapter.Process(adapter.Name(), msg, handler, func(ctx *fasthttp.RequestCtx) {
+		if err := CheckResponse(ctx); err != nil {
+			log.Warnf("failed to process [%v] syslog messages: %v", len(batch), err)
+			stats.IncrementBy("syslog", "failed", int64(len(batch)))
+			return
+		}
+		stats.IncrementBy("syslog", "processed", int64(len(batch)))
+	})
+}
+
+func (adapter *SyslogAdapter) closeListeners() {
+	if adapter.udpConn != nil {
+		adapter.udpConn.Close()
+	}
+	if adapter.tcpListener != nil {
+		adapter.tcpListener.Close()
+	}
+}
+
+func (adapter *SyslogAdapter) Stop() error {
+	adapter.lock.Lock()
+	adapter.closed = true
+	adapter.lock.Unlock()
+
+	adapter.closeListeners()
+	adapter.conns.Range(func(key, value interface{}) bool {
+		key.(net.Conn).Close()
+		return true
+	})
+	adapter.readers.Wait()
+
+	//flush the pending messages
+	if adapter.docs != nil {
+		close(adapter.docs)
+		adapter.workers.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogMessage is the structured form of a RFC 3164 or RFC 5424 message
+type SyslogMessage struct {
+	Format         string                       `json:"format"`
+	Priority       int                          `json:"priority"`
+	Facility       int                          `json:"facility"`
+	FacilityLabel  string                       `json:"facility_label"`
+	Severity       int                          `json:"severity"`
+	SeverityLabel  string                       `json:"severity_label"`
+	Version        int                          `json:"version,omitempty"`
+	Timestamp      *time.Time                   `json:"timestamp,omitempty"`
+	Hostname       string                       `json:"host,omitempty"`
+	AppName        string                       `json:"app,omitempty"`
+	ProcID         string                       `json:"procid,omitempty"`
+	MsgID          string                       `json:"msgid,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	Message        string                       `json:"message"`
+	Source         string                       `json:"source,omitempty"`
+}
+
+var facilityLabels = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+var severityLabels = []string{"emergency", "alert", "critical", "error", "warning", "notice", "informational", "debug"}
+
+var errNoPriority = errors.New("invalid syslog message, priority is missing")
+
+// ParseSyslogMessage parses the message in RFC 5424 format, or in RFC 3164 format as a fallback,
+// the year of RFC 3164 timestamps is guessed from the time of now
+func ParseSyslogMessage(data []byte, now time.Time) (*SyslogMessage, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) < 3 || data[0] != '<' {
+		return nil, errNoPriority
+	}
+	end := bytes.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return nil, errNoPriority
+	}
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, errNoPriority
+	}
+
+	msg := &SyslogMessage{
+		Priority:      pri,
+		Facility:      pri / 8,
+		FacilityLabel: facilityLabels[pri/8],
+		Severity:      pri % 8,
+		SeverityLabel: severityLabels[pri%8],
+	}
+
+	rest := data[end+1:]
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && (rest[1] == ' ' || (len(rest) > 2 && rest[1] >= '0' && rest[1] <= '9' && rest[2] == ' ')) {
+		return msg, msg.parseRFC5424(string(rest))
+	}
+	msg.parseRFC3164(string(rest), now)
+	return msg, nil
+}
+
+func nextField(str string) (string, string) {
+	i := strings.IndexByte(str, ' ')
+	if i < 0 {
+		return str, ""
+	}
+	return str[:i], str[i+1:]
+}
+
+func nilValue(str string) string {
+	if str == "-" {
+		return ""
+	}
+	return str
+}
+
+func (msg *SyslogMessage) parseRFC5424(str string) error {
+	msg.Format = "rfc5424"
+
+	var field string
+	field, str = nextField(str)
+	msg.Version, _ = strconv.Atoi(field)
+
+	field, str = nextField(str)
+	if field != "-" {
+		t, err := time.Parse(time.RFC3339Nano, field)
+		if err != nil {
+			return errors.New("invalid timestamp: " + field)
+		}
+		msg.Timestamp = &t
+	}
+
+	field, str = nextField(str)
+	msg.Hostname = nilValue(field)
+	field, str = nextField(str)
+	msg.AppName = nilValue(field)
+	field, str = nextField(str)
+	msg.ProcID = nilValue(field)
+	field, str = nextField(str)
+	msg.MsgID = nilValue(field)
+
+	if strings.HasPrefix(str, "-") {
+		str = strings.TrimPrefix(str[1:], " ")
+	} else if strings.HasPrefix(str, "[") {
+		sd, rest, err := parseStructuredData(str)
+		if err != nil {
+			return err
+		}
+		msg.StructuredData = sd
+		str = strings.TrimPrefix(rest, " ")
+	}
+
+	msg.Message = strings.TrimPrefix(str, "\ufeff")
+	return nil
+}
+
+func parseStructuredData(str string) (map[string]map[string]string, string, error) {
+	result := map[string]map[string]string{}
+	for strings.HasPrefix(str, "[") {
+		i := 1
+		for i < len(str) && str[i] != ' ' && str[i] != ']' {
+			i++
+		}
+		if i >= len(str) {
+			return nil, str, errors.New("invalid structured data")
+		}
+		id := str[1:i]
+		params := map[string]string{}
+		for i < len(str) && str[i] == ' ' {
+			i++
+			eq := strings.IndexByte(str[i:], '=')
+			if eq < 0 || i+eq+1 >= len(str) || str[i+eq+1] != '"' {
+				return nil, str, errors.New("invalid structured data")
+			}
+			name := str[i : i+eq]
+			i += eq + 2
+			value := strings.Builder{}
+			for ; i < len(str) && str[i] != '"'; i++ {
+				//escaped characters: \" \\ \]
+				if str[i] == '\\' && i+1 < len(str) && (str[i+1] == '"' || str[i+1] == '\\' || str[i+1] == ']') {
+					i++
+				}
+				value.WriteByte(str[i])
+			}
+			if i >= len(str) {
+				return nil, str, errors.New("invalid structured data")
+			}
+			params[name] = value.String()
+			i++
+		}
+		if i >= len(str) || str[i] != ']' {
+			return nil, str, errors.New("invalid structured data")
+		}
+		result[id] = params
+		str = str[i+1:]
+	}
+	return result, str, nil
+}
+
+func (msg *SyslogMessage) parseRFC3164(str string, now time.Time) {
+	msg.Format = "rfc3164"
+
+	//eg: Oct 11 22:14:15
+	if len(str) >= 16 && str[15] == ' ' {
+		t, err := time.ParseInLocation(time.Stamp, str[:15], now.Location())
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			//messages of the last december received in january
+			if t.After(now.AddDate(0, 0, 1)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = &t
+			str = str[16:]
+			msg.Hostname, str = nextField(str)
+		}
+	}
+
+	//tag, eg: sshd[1234]: message
+	tag, rest := nextField(str)
+	if strings.HasSuffix(tag, ":") {
+		tag = strings.TrimSuffix(tag, ":")
+		if i := strings.IndexByte(tag, '['); i > 0 && strings.HasSuffix(tag, "]") {
+			msg.ProcID = tag[i+1 : len(tag)-1]
+			tag = tag[:i]
+		}
+		msg.AppName = tag
+		str = rest
+	}
+
+	msg.Message = str
+}
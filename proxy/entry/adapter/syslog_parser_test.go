@@ -0,0 +1,160 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package adapter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRFC5424(t *testing.T) {
+	now := time.Now()
+	msg, err := ParseSyslogMessage([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high \"x\\y\]"] An application event log entry...`+"\n"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "rfc5424", msg.Format)
+	assert.Equal(t, 165, msg.Priority)
+	assert.Equal(t, 20, msg.Facility)
+	assert.Equal(t, "local4", msg.FacilityLabel)
+	assert.Equal(t, 5, msg.Severity)
+	assert.Equal(t, "notice", msg.SeverityLabel)
+	assert.Equal(t, 1, msg.Version)
+	assert.Equal(t, time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC), msg.Timestamp.UTC())
+	assert.Equal(t, "mymachine.example.com", msg.Hostname)
+	assert.Equal(t, "evntslog", msg.AppName)
+	assert.Equal(t, "", msg.ProcID)
+	assert.Equal(t, "ID47", msg.MsgID)
+	assert.Equal(t, map[string]map[string]string{
+		"exampleSDID@32473":     {"iut": "3", "eventSource": "Application", "eventID": "1011"},
+		"examplePriority@32473": {"class": `high "x\y]`},
+	}, msg.StructuredData)
+	assert.Equal(t, "An application event log entry...", msg.Message)
+
+	msg, err = ParseSyslogMessage([]byte("<34>1 - - su - - - \ufeff'su root' failed"), now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Timestamp)
+	assert.Equal(t, "su", msg.AppName)
+	assert.Nil(t, msg.StructuredData)
+	assert.Equal(t, "'su root' failed", msg.Message)
+
+	_, err = ParseSyslogMessage([]byte(`<34>1 2003-10-11T22:14:15.003Z host app - - [broken`), now)
+	assert.Error(t, err)
+}
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	msg, err := ParseSyslogMessage([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "rfc3164", msg.Format)
+	assert.Equal(t, 4, msg.Facility)
+	assert.Equal(t, "auth", msg.FacilityLabel)
+	assert.Equal(t, "critical", msg.SeverityLabel)
+	//the timestamp of october can't be in the future
+	assert.Equal(t, time.Date(2022, 10, 11, 22, 14, 15, 0, time.UTC), *msg.Timestamp)
+	assert.Equal(t, "mymachine", msg.Hostname)
+	assert.Equal(t, "su", msg.AppName)
+	assert.Equal(t, "123", msg.ProcID)
+	assert.Equal(t, "'su root' failed for lonvick on /dev/pts/8", msg.Message)
+
+	msg, err = ParseSyslogMessage([]byte("<13>Apr  1 10:00:00 host kernel: boot"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC), *msg.Timestamp)
+	assert.Equal(t, "kernel", msg.AppName)
+	assert.Equal(t, "boot", msg.Message)
+
+	//no timestamp and tag
+	msg, err = ParseSyslogMessage([]byte("<13>just a message"), now)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Timestamp)
+	assert.Equal(t, "just a message", msg.Message)
+
+	_, err = ParseSyslogMessage([]byte("no priority"), now)
+	assert.Error(t, err)
+	_, err = ParseSyslogMessage([]byte("<192>invalid priority"), now)
+	assert.Error(t, err)
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("11 <13>1 - a b\n<13>plain line\r\n\n14 <13>multi\nline<13>last"))
+
+	//octet counting
+	frame, err := ReadSyslogFrame(reader, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>1 - a b", string(frame))
+
+	//newline framing, empty lines are skipped
+	frame, err = ReadSyslogFrame(reader, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>plain line", string(frame))
+
+	frame, err = ReadSyslogFrame(reader, 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "<13>multi\nline", string(frame))
+
+	frame, err = ReadSyslogFrame(reader, 1024)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "<13>last", string(frame))
+
+	_, err = ReadSyslogFrame(bufio.NewReader(strings.NewReader("2048 <13>too large")), 1024)
+	assert.Error(t, err)
+
+	//the octet count is rejected without reading the rest of the stream
+	data := strings.Repeat("1", 64) + " <13>digits"
+	reader = bufio.NewReader(strings.NewReader(data))
+	_, err = ReadSyslogFrame(reader, 1024)
+	assert.Error(t, err)
+	assert.Equal(t, len(data)-maxFrameLengthDigits-1, reader.Buffered())
+
+	_, err = ReadSyslogFrame(bufio.NewReader(strings.NewReader("12a <13>bad")), 1024)
+	assert.Error(t, err)
+}
+
+func TestSyslogAdapterStop(t *testing.T) {
+	adapter := &SyslogAdapter{}
+	client, server := net.Pipe()
+	defer client.Close()
+	assert.True(t, adapter.track(server))
+	var err error
+	go func() {
+		defer adapter.readers.Done()
+		_, err = server.Read(make([]byte, 1))
+	}()
+	//the tracked connections are closed on stop
+	assert.NoError(t, adapter.Stop())
+	assert.Error(t, err)
+
+	//the connections accepted after stop are not tracked
+	client, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	assert.False(t, adapter.track(server))
+}
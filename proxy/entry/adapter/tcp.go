@@ -21,4 +21,151 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
 package adapter
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// TCPAdapter reads newline delimited messages from the tcp connections, each line is a request
+type TCPAdapter struct {
+	RequestConfig `config:",inline"`
+	MaxLineSize   int  `config:"max_line_size"`
+	Reply         bool `config:"reply"`
+
+	address     string
+	idleTimeout time.Duration
+	listener    net.Listener
+	conns       sync.Map
+	lock        sync.Mutex
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+func init() {
+	RegisterAdapter("tcp", NewTCPAdapter)
+}
+
+func NewTCPAdapter(entry common.EntryConfig, c *config.Config) (Adapter, error) {
+	adapter := TCPAdapter{
+		MaxLineSize: 1024 * 1024,
+	}
+	if err := c.Unpack(&adapter); err != nil {
+		return nil, fmt.Errorf("failed to unpack the adapter configuration : %s", err)
+	}
+	adapter.address = entry.NetworkConfig.GetBindingAddr()
+	adapter.idleTimeout = time.Duration(entry.IdleTimeout) * time.Second
+	return &adapter, nil
+}
+
+func (adapter *TCPAdapter) Name() string {
+	return "tcp"
+}
+
+func (adapter *TCPAdapter) Start(handler fasthttp.RequestHandler) error {
+	ln, err := net.Listen("tcp", adapter.address)
+	if err != nil {
+		return err
+	}
+	adapter.listener = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				return
+			}
+			if !adapter.track(conn) {
+				conn.Close()
+				return
+			}
+			go adapter.serve(conn, handler)
+		}
+	}()
+	return nil
+}
+
+// track keeps the connection to be closed on stop, returns false if the adapter is stopped
+func (adapter *TCPAdapter) track(conn net.Conn) bool {
+	adapter.lock.Lock()
+	defer adapter.lock.Unlock()
+	if adapter.closed {
+		return false
+	}
+	adapter.conns.Store(conn, struct{}{})
+	adapter.wg.Add(1)
+	return true
+}
+
+func (adapter *TCPAdapter) serve(conn net.Conn, handler fasthttp.RequestHandler) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				log.Errorf("error in tcp adapter: %v", r)
+			}
+		}
+		conn.Close()
+		adapter.conns.Delete(conn)
+		adapter.wg.Done()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), adapter.MaxLineSize)
+	writer := bufio.NewWriter(conn)
+	for {
+		if adapter.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(adapter.idleTimeout))
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil && global.Env().IsDebug {
+				log.Debugf("tcp connection from %v closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		msg := Message{Body: line, RemoteAddr: conn.RemoteAddr()}
+		adapter.Process(adapter.Name(), msg, handler, func(ctx *fasthttp.RequestCtx) {
+			if adapter.Reply {
+				writer.Write(ctx.Response.Body())
+				writer.WriteByte('\n')
+				writer.Flush()
+			}
+		})
+	}
+}
+
+func (adapter *TCPAdapter) Stop() error {
+	adapter.lock.Lock()
+	adapter.closed = true
+	adapter.lock.Unlock()
+
+	if adapter.listener != nil {
+		adapter.listener.Close()
+	}
+	adapter.conns.Range(func(key, value interface{}) bool {
+		key.(net.Conn).Close()
+		return true
+	})
+	adapter.wg.Wait()
+	return nil
+}
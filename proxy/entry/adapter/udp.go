@@ -21,4 +21,117 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
 package adapter
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// UDPAdapter reads datagrams, each datagram is a request
+type UDPAdapter struct {
+	RequestConfig `config:",inline"`
+	MaxPacketSize int `config:"max_packet_size"`
+	WorkerSize    int `config:"worker_size"`
+
+	address string
+	conn    net.PacketConn
+	queue   chan Message
+	wg      sync.WaitGroup
+}
+
+func init() {
+	RegisterAdapter("udp", NewUDPAdapter)
+}
+
+func NewUDPAdapter(entry common.EntryConfig, c *config.Config) (Adapter, error) {
+	adapter := UDPAdapter{
+		MaxPacketSize: 64 * 1024,
+		WorkerSize:    10,
+	}
+	if err := c.Unpack(&adapter); err != nil {
+		return nil, fmt.Errorf("failed to unpack the adapter configuration : %s", err)
+	}
+	if adapter.WorkerSize <= 0 {
+		adapter.WorkerSize = 1
+	}
+	adapter.address = entry.NetworkConfig.GetBindingAddr()
+	return &adapter, nil
+}
+
+func (adapter *UDPAdapter) Name() string {
+	return "udp"
+}
+
+func (adapter *UDPAdapter) Start(handler fasthttp.RequestHandler) error {
+	return adapter.listen(adapter.Name(), adapter.RequestConfig, func(data []byte, addr net.Addr) Message {
+		return Message{Body: data, RemoteAddr: addr}
+	}, handler)
+}
+
+// listen reads the datagrams and dispatches them to the workers, the decoder converts a datagram into a message
+func (adapter *UDPAdapter) listen(name string, cfg RequestConfig, decoder func(data []byte, addr net.Addr) Message, handler fasthttp.RequestHandler) error {
+	conn, err := net.ListenPacket("udp", adapter.address)
+	if err != nil {
+		return err
+	}
+	adapter.conn = conn
+	adapter.queue = make(chan Message, adapter.WorkerSize*10)
+
+	for i := 0; i < adapter.WorkerSize; i++ {
+		adapter.wg.Add(1)
+		go func() {
+			defer adapter.wg.Done()
+			for msg := range adapter.queue {
+				adapter.process(name, cfg, msg, handler)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(adapter.queue)
+		buf := make([]byte, adapter.MaxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				return
+			}
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			adapter.queue <- decoder(data, addr)
+		}
+	}()
+	return nil
+}
+
+func (adapter *UDPAdapter) process(name string, cfg RequestConfig, msg Message, handler fasthttp.RequestHandler) {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				log.Errorf("error in %v adapter: %v", name, r)
+			}
+		}
+	}()
+	cfg.Process(name, msg, handler, nil)
+}
+
+func (adapter *UDPAdapter) Stop() error {
+	if adapter.conn != nil {
+		adapter.conn.Close()
+	}
+	adapter.wg.Wait()
+	return nil
+}
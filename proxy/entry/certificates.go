@@ -0,0 +1,260 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/gateway/common"
+)
+
+type CertificateInfo struct {
+	CertFile      string    `json:"cert_file"`
+	KeyFile       string    `json:"key_file"`
+	Domains       []string  `json:"domains,omitempty"`
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	ExpiresInDays int       `json:"expires_in_days"`
+	Default       bool      `json:"default,omitempty"`
+}
+
+// CertificateStore selects the certificate by the SNI of the client hello,
+// the certificate files are checked periodically and reloaded when changed
+type CertificateStore struct {
+	defaultPair *common.TLSCertificate
+	pairs       []common.TLSCertificate
+	dir         string
+
+	lock        sync.RWMutex
+	names       map[string]*tls.Certificate
+	defaultCert *tls.Certificate
+	infos       []CertificateInfo
+	fingerprint string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewCertificateStore(defaultPair *common.TLSCertificate, cfg common.TLSCertificatesConfig) *CertificateStore {
+	return &CertificateStore{
+		defaultPair: defaultPair,
+		pairs:       cfg.Certificates,
+		dir:         cfg.CertDir,
+		names:       map[string]*tls.Certificate{},
+		stop:        make(chan struct{}),
+	}
+}
+
+// listPairs returns the configured pairs, the pairs found in the directory, and the default pair at last
+func (this *CertificateStore) listPairs() ([]common.TLSCertificate, error) {
+	pairs := append([]common.TLSCertificate{}, this.pairs...)
+
+	if this.dir != "" {
+		files, err := os.ReadDir(this.dir)
+		if err != nil {
+			return nil, err
+		}
+		names := []string{}
+		for _, f := range files {
+			if !f.IsDir() {
+				names = append(names, f.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ext := filepath.Ext(name)
+			if ext != ".crt" && ext != ".cert" && ext != ".pem" {
+				continue
+			}
+			key := filepath.Join(this.dir, strings.TrimSuffix(name, ext)+".key")
+			if _, err := os.Stat(key); err != nil {
+				continue
+			}
+			pairs = append(pairs, common.TLSCertificate{CertFile: filepath.Join(this.dir, name), KeyFile: key})
+		}
+	}
+
+	if this.defaultPair != nil {
+		pairs = append(pairs, *this.defaultPair)
+	}
+	return pairs, nil
+}
+
+func getFingerprint(pairs []common.TLSCertificate) string {
+	buf := strings.Builder{}
+	for _, pair := range pairs {
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			stat, err := os.Stat(file)
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("%v:missing;", file))
+				continue
+			}
+			buf.WriteString(fmt.Sprintf("%v:%v:%v;", file, stat.ModTime().UnixNano(), stat.Size()))
+		}
+	}
+	return buf.String()
+}
+
+// Reload loads the certificates if any of the files changed, the previous certificates are kept on error
+func (this *CertificateStore) Reload() (bool, error) {
+	pairs, err := this.listPairs()
+	if err != nil {
+		return false, err
+	}
+
+	fingerprint := getFingerprint(pairs)
+	this.lock.RLock()
+	changed := fingerprint != this.fingerprint
+	this.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	names := map[string]*tls.Certificate{}
+	infos := []CertificateInfo{}
+	var defaultCert *tls.Certificate
+	for i, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load certificate [%v]: %v", pair.CertFile, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return false, fmt.Errorf("failed to parse certificate [%v]: %v", pair.CertFile, err)
+		}
+		cert.Leaf = leaf
+
+		domains := leaf.DNSNames
+		if len(domains) == 0 && leaf.Subject.CommonName != "" {
+			domains = []string{leaf.Subject.CommonName}
+		}
+		for _, domain := range domains {
+			domain = strings.ToLower(domain)
+			//the first certificate of the domain wins
+			if _, ok := names[domain]; !ok {
+				names[domain] = &cert
+			}
+		}
+
+		isDefault := this.defaultPair != nil && i == len(pairs)-1
+		if isDefault {
+			defaultCert = &cert
+		}
+
+		infos = append(infos, CertificateInfo{
+			CertFile:  pair.CertFile,
+			KeyFile:   pair.KeyFile,
+			Domains:   domains,
+			Subject:   leaf.Subject.String(),
+			Issuer:    leaf.Issuer.String(),
+			NotBefore: leaf.NotBefore,
+			NotAfter:  leaf.NotAfter,
+			Default:   isDefault,
+		})
+	}
+
+	this.lock.Lock()
+	this.names = names
+	this.defaultCert = defaultCert
+	this.infos = infos
+	this.fingerprint = fingerprint
+	this.lock.Unlock()
+
+	return true, nil
+}
+
+// GetCertificate returns the certificate of the server name, exact names are preferred to the wildcard names,
+// nil is returned if nothing matched, and the certificates of the tls config are used
+func (this *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if name != "" {
+		if cert, ok := this.names[name]; ok {
+			return cert, nil
+		}
+		if i := strings.Index(name, "."); i > 0 {
+			if cert, ok := this.names["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+	return this.defaultCert, nil
+}
+
+func (this *CertificateStore) Certificates() []CertificateInfo {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	now := time.Now()
+	infos := make([]CertificateInfo, len(this.infos))
+	for i, info := range this.infos {
+		info.ExpiresInDays = int(info.NotAfter.Sub(now).Hours() / 24)
+		infos[i] = info
+	}
+	return infos
+}
+
+// Watch checks the certificate files in background
+func (this *CertificateStore) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := this.Reload()
+				if err != nil {
+					log.Errorf("failed to reload certificates: %v", err)
+				} else if changed {
+					log.Infof("certificates reloaded, %v certificates loaded", len(this.Certificates()))
+				}
+			case <-this.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (this *CertificateStore) Stop() {
+	this.stopOnce.Do(func() {
+		close(this.stop)
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/gateway/common"
+)
+
+func writeCert(t *testing.T, dir, name string, notAfter time.Time, domains ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func getServerName(t *testing.T, store *CertificateStore, name string) string {
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	assert.Nil(t, err)
+	if cert == nil {
+		return ""
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+	defaultDir := t.TempDir()
+	expiry := time.Now().Add(60 * 24 * time.Hour)
+	writeCert(t, dir, "logs", expiry, "logs.example.com", "*.logs.example.com")
+	writeCert(t, dir, "search", expiry, "search.example.com")
+	writeCert(t, defaultDir, "default", expiry, "localhost")
+
+	store := NewCertificateStore(&common.TLSCertificate{
+		CertFile: filepath.Join(defaultDir, "default.crt"),
+		KeyFile:  filepath.Join(defaultDir, "default.key"),
+	}, common.TLSCertificatesConfig{CertDir: dir})
+
+	changed, err := store.Reload()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	assert.Equal(t, "logs.example.com", getServerName(t, store, "logs.example.com"))
+	assert.Equal(t, "logs.example.com", getServerName(t, store, "A.Logs.Example.com."))
+	assert.Equal(t, "search.example.com", getServerName(t, store, "search.example.com"))
+	assert.Equal(t, "localhost", getServerName(t, store, "unknown.example.com"))
+	assert.Equal(t, "localhost", getServerName(t, store, ""))
+
+	infos := store.Certificates()
+	assert.Equal(t, 3, len(infos))
+	assert.True(t, infos[2].Default)
+	assert.True(t, infos[0].ExpiresInDays >= 59)
+
+	changed, err = store.Reload()
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	//rotate the certificate
+	time.Sleep(10 * time.Millisecond)
+	writeCert(t, dir, "search", expiry, "search.example.com", "kibana.example.com")
+	changed, err = store.Reload()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "search.example.com", getServerName(t, store, "kibana.example.com"))
+
+	//broken files are not loaded, the previous certificates are kept
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "search.key"), []byte("broken"), 0600))
+	_, err = store.Reload()
+	assert.NotNil(t, err)
+	assert.Equal(t, "search.example.com", getServerName(t, store, "kibana.example.com"))
+}
@@ -0,0 +1,92 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"fmt"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// initTrustedProxies prepares the trusted proxies, the ip access rules are checked by the client ip of the requests
+// instead of the connections, as the connections are from the proxies. it's called on each start, as the entry is
+// restarted when the ip access rules are changed
+func (this *Entrypoint) initTrustedProxies() error {
+	this.trustedProxies = nil
+	this.ipAccessChecker = nil
+
+	if len(this.config.NetworkExt.TrustedProxies) == 0 {
+		return nil
+	}
+
+	proxies, err := common.NewTrustedProxies(this.config.NetworkExt.TrustedProxies, this.config.NetworkExt.ClientIPHeader)
+	if err != nil {
+		return err
+	}
+	this.trustedProxies = proxies
+
+	if this.routerConfig.IPAccessRules.Enabled {
+		this.ipAccessChecker, err = common.NewIPAccessChecker(this.routerConfig.IPAccessRules)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("entry [%v] trusts the proxies: %v", this.String(), this.config.NetworkExt.TrustedProxies)
+	return nil
+}
+
+// resolveClientIP resolves the client ip of the request, returns false if the request is denied
+func (this *Entrypoint) resolveClientIP(ctx *fasthttp.RequestCtx) bool {
+	if this.trustedProxies == nil {
+		return true
+	}
+
+	if ip, ok := this.trustedProxies.Resolve(ctx); ok {
+		ctx.Set(common.ClientIPKey, ip)
+	}
+
+	if this.ipAccessChecker != nil {
+		ip := common.GetClientIP(ctx)
+		if !this.ipAccessChecker.Allow(ip) {
+			ctx.SetContentType(util.ContentTypeJson)
+			ctx.Response.SetStatusCode(403)
+			ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+				"error": util.MapStr{
+					"type":   "security_exception",
+					"reason": fmt.Sprintf("client ip [%v] is not allowed", ip),
+				},
+				"status": 403,
+			}))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/gateway/common"
+)
+
+func TestInitTrustedProxiesOnRestart(t *testing.T) {
+	entry := &Entrypoint{}
+	entry.config.NetworkExt.TrustedProxies = []string{"10.0.0.0/8"}
+	entry.routerConfig.IPAccessRules.Enabled = true
+	entry.routerConfig.IPAccessRules.ClientIP.DeniedList = []string{"192.168.0.1"}
+	assert.Nil(t, entry.initTrustedProxies())
+	assert.NotNil(t, entry.trustedProxies)
+	assert.NotNil(t, entry.ipAccessChecker)
+
+	//the rules are disabled before the restart
+	entry.routerConfig.IPAccessRules = common.IPAccessRules{}
+	assert.Nil(t, entry.initTrustedProxies())
+	assert.NotNil(t, entry.trustedProxies)
+	assert.Nil(t, entry.ipAccessChecker)
+
+	//the proxies are no longer trusted
+	entry.routerConfig.IPAccessRules.Enabled = true
+	entry.config.NetworkExt.TrustedProxies = nil
+	assert.Nil(t, entry.initTrustedProxies())
+	assert.Nil(t, entry.trustedProxies)
+	assert.Nil(t, entry.ipAccessChecker)
+}
@@ -0,0 +1,139 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+type drainState struct {
+	inflight int64
+	drained  int64
+	draining int32
+}
+
+// resetDrain clears the state of the previous draining, so that a restarted entry serves normally
+func (this *Entrypoint) resetDrain() {
+	atomic.StoreInt64(&this.drainState.drained, 0)
+	atomic.StoreInt32(&this.drainState.draining, 0)
+}
+
+func (this *Entrypoint) acquireRequest() {
+	atomic.AddInt64(&this.drainState.inflight, 1)
+}
+
+// releaseRequest marks the request as finished, the connection will be closed after the response while draining
+func (this *Entrypoint) releaseRequest(ctx *fasthttp.RequestCtx) {
+	if atomic.LoadInt32(&this.drainState.draining) == 1 {
+		ctx.SetConnectionClose()
+		atomic.AddInt64(&this.drainState.drained, 1)
+	}
+	atomic.AddInt64(&this.drainState.inflight, -1)
+}
+
+func (this *Entrypoint) IsDraining() bool {
+	return atomic.LoadInt32(&this.drainState.draining) == 1
+}
+
+// drain stops accepting new connections, asks the keep-alive clients to close their connections,
+// and waits for the in-flight requests to finish, the requests still running after the deadline can't be cancelled,
+// they are counted as timed out and left to finish in background
+func (this *Entrypoint) drain() error {
+	if this.server == nil || !atomic.CompareAndSwapInt32(&this.drainState.draining, 0, 1) {
+		return nil
+	}
+
+	timeout := util.GetDurationOrDefault(this.config.Drain.Timeout, 30*time.Second)
+	log.Infof("draining entry [%s], %v in-flight requests, timeout: %v", this.String(), atomic.LoadInt64(&this.drainState.inflight), timeout)
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		defer func() {
+			if !global.Env().IsDebug {
+				if r := recover(); r != nil {
+					var v string
+					switch r.(type) {
+					case error:
+						v = r.(error).Error()
+					case runtime.Error:
+						v = r.(runtime.Error).Error()
+					case string:
+						v = r.(string)
+					}
+					log.Error(v)
+				}
+			}
+		}()
+		//close the listeners, and wait for the connections to be closed,
+		//idle keep-alive connections are closed at once
+		this.server.Shutdown()
+	}()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var timedOut int64
+	start := time.Now()
+WAIT:
+	for {
+		select {
+		case <-shutdown:
+			shutdown = nil
+		case <-ticker.C:
+		case <-deadline:
+			timedOut = atomic.LoadInt64(&this.drainState.inflight)
+			break WAIT
+		}
+		//the HTTP/2 streams are not tracked by the fasthttp server
+		if shutdown == nil && atomic.LoadInt64(&this.drainState.inflight) == 0 {
+			break
+		}
+	}
+
+	drained := atomic.LoadInt64(&this.drainState.drained)
+	category := fmt.Sprintf("entry.%v.drain", this.GetNameOrID())
+	stats.IncrementBy(category, "drained", drained)
+	stats.IncrementBy(category, "timed_out", timedOut)
+
+	if shutdown != nil || timedOut > 0 {
+		log.Warnf("entry [%s] drain timed out after %v, drained: %v, timed out: %v", this.String(), time.Since(start), drained, timedOut)
+	} else {
+		log.Infof("entry [%s] drained in %v, drained: %v, timed out: %v", this.String(), time.Since(start), drained, timedOut)
+	}
+	return nil
+}
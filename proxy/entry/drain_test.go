@@ -0,0 +1,100 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	config3 "infini.sh/framework/core/config"
+	"infini.sh/framework/lib/fasthttp"
+	r "infini.sh/framework/lib/router"
+	"infini.sh/gateway/common"
+)
+
+func startSlowEntry(t *testing.T, port uint, timeout string, delay time.Duration) *Entrypoint {
+	config := common.EntryConfig{Enabled: true}
+	config.Name = "drain"
+	config.MaxConcurrency = 100
+	config.Drain = common.DrainConfig{Enabled: true, Timeout: timeout}
+	config.NetworkConfig = config3.NetworkConfig{Host: "127.0.0.1", Port: port}
+	entry := &Entrypoint{config: config}
+	assert.Nil(t, entry.Start())
+
+	router := r.New()
+	router.NotFound = func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(delay)
+		ctx.Response.SetStatusCode(200)
+	}
+	entry.routerHolder.Store(router)
+	return entry
+}
+
+func TestDrainWaitsForInflightRequests(t *testing.T) {
+	entry := startSlowEntry(t, 8084, "5s", 500*time.Millisecond)
+
+	result := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:8084/")
+		if err != nil {
+			result <- 0
+			return
+		}
+		res.Body.Close()
+		result <- res.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	assert.Nil(t, entry.Stop())
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+	assert.True(t, entry.IsDraining())
+	assert.Equal(t, 200, <-result)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&entry.drainState.drained))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&entry.drainState.inflight))
+
+	//the restarted entry serves normally
+	assert.Nil(t, entry.Start())
+	assert.False(t, entry.IsDraining())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&entry.drainState.drained))
+	assert.Nil(t, entry.Stop())
+}
+
+func TestDrainTimeout(t *testing.T) {
+	entry := startSlowEntry(t, 8085, "200ms", 2*time.Second)
+
+	go http.Get("http://127.0.0.1:8085/")
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	assert.Nil(t, entry.Stop())
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&entry.drainState.inflight))
+}
@@ -35,6 +35,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	log "github.com/cihub/seelog"
+	"golang.org/x/net/http2"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
@@ -44,11 +45,14 @@ import (
 	"infini.sh/framework/lib/fasthttp/reuseport"
 	r "infini.sh/framework/lib/router"
 	"infini.sh/gateway/common"
+	"infini.sh/gateway/proxy/entry/adapter"
 	"net"
+	"net/http"
 	"os"
 	"path"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -69,8 +73,18 @@ type Entrypoint struct {
 	rootCertPEM   []byte
 	schema string
 	listenAddress string
-	router        *r.Router
+	routerHolder  atomic.Value
 	server        *fasthttp.Server
+	adapter       adapter.Adapter
+	drainState    drainState
+	certStore     *CertificateStore
+
+	trustedProxies  *common.TrustedProxies
+	ipAccessChecker *common.IPAccessChecker
+
+	http2Server     *http2.Server
+	http2BaseServer *http.Server
+	http2Limits     *http2Limits
 }
 
 func (this *Entrypoint) String() string {
@@ -82,13 +96,21 @@ func (this *Entrypoint) Start() error {
 		return nil
 	}
 
+	if this.config.Type != "" && this.config.Type != adapter.HTTP {
+		return this.startAdapter()
+	}
+
 	if this.config.NetworkConfig.ReusePort == this.config.NetworkConfig.SkipOccupiedPort && this.config.NetworkConfig.ReusePort == true {
 		return errors.New("port reuse and skip occupied can't be enabled at the same time for entry:" + this.config.Name)
 	}
 
+	this.resetDrain()
+
 	this.listenAddress = this.config.NetworkConfig.GetBindingAddr()
 
-	if !this.config.NetworkConfig.ReusePort && this.config.NetworkConfig.SkipOccupiedPort {
+	isUnixSocket := common.IsUnixSocket(this.listenAddress)
+
+	if !isUnixSocket && !this.config.NetworkConfig.ReusePort && this.config.NetworkConfig.SkipOccupiedPort {
 		this.listenAddress = util.AutoGetAddress(this.config.NetworkConfig.GetBindingAddr())
 		log.Trace("auto skip address ", this.listenAddress)
 	}
@@ -96,7 +118,10 @@ func (this *Entrypoint) Start() error {
 	var ln net.Listener
 	var err error
 
-	if this.config.NetworkConfig.ReusePort&&!strings.Contains(this.listenAddress,"::") {
+	if isUnixSocket {
+		log.Debug("listen on unix socket ", this.listenAddress)
+		ln, err = common.ListenUnixSocket(this.listenAddress, this.config.NetworkExt.SocketMode)
+	} else if this.config.NetworkConfig.ReusePort&&!strings.Contains(this.listenAddress,"::") {
 		log.Debug("reuse port ", this.listenAddress)
 		ln, err = reuseport.Listen("tcp4", this.config.NetworkConfig.GetBindingAddr())
 	} else {
@@ -107,68 +132,17 @@ func (this *Entrypoint) Start() error {
 		panic(errors.Errorf("error in listener(%v): %s", this.listenAddress,err))
 	}
 
-	this.router = r.New()
-
-	if this.config.RouterConfigName != "" {
-		this.routerConfig = common.GetRouter(this.config.RouterConfigName)
-	}
-
-	if len(this.routerConfig.Rules) > 0 {
-		for _, rule := range this.routerConfig.Rules {
-
-			if this.routerConfig.RuleToggleEnabled && !rule.Enabled{
-				continue
-			}
-
-			flow := common.FilterFlow{}
-			for _, y := range rule.Flow {
-
-				cfg,err := common.GetFlowConfig(y)
-				if err!=nil{
-					panic(err)
-				}
-
-				if len(cfg.Filters) > 0 {
-					flow1, err := pipeline.NewFilter(cfg.GetConfig())
-					if err != nil {
-						panic(err)
-					}
-					flow.JoinFilter(flow1)
-				}
-			}
-
-			for _, v := range rule.Method {
-				for _, u := range rule.PathPattern {
-					log.Debugf("apply filter flow: [%s] [%s] [ %s ]", v, u, flow.ToString())
-					if v == "*" {
-						this.router.ANY(u, flow.Process)
-					} else {
-						this.router.Handle(v, u, flow.Process)
-					}
-				}
-			}
-		}
-	}
-
-	if this.routerConfig.DefaultFlow != "" {
-		this.router.DefaultFlow = this.routerConfig.DefaultFlow
-		if this.router.DefaultFlow!=""{
-			//init func
-			this.router.NotFound=common.GetFlowProcess(this.router.DefaultFlow)
-		}
-	} else {
-		this.router.NotFound = func(ctx *fasthttp.RequestCtx) {
-			ctx.Response.SetBody([]byte("NOT FOUND"))
-			ctx.Response.SetStatusCode(404)
+	if this.config.NetworkExt.ProxyProtocol.Enabled {
+		ln, err = this.wrapProxyProtocol(ln)
+		if err != nil {
+			panic(err)
 		}
 	}
 
-	if this.routerConfig.TracingFlow != "" {
-		if global.Env().IsDebug {
-			log.Debugf("tracing flow placed: %s", this.routerConfig.TracingFlow)
-		}
+	this.initRouter()
 
-		this.UpdateTracingFlow(this.routerConfig.TracingFlow)
+	if err := this.initTrustedProxies(); err != nil {
+		panic(err)
 	}
 
 	if this.config.MaxConcurrency <= 0 {
@@ -222,10 +196,10 @@ func (this *Entrypoint) Start() error {
 		NoDefaultContentType:               true,
 		DisableHeaderNamesNormalizing:      true,
 		DisablePreParseMultipartForm:       true,
-		//CloseOnShutdown:       true, //TODO
+		CloseOnShutdown:                    this.config.Drain.Enabled,
 		//StreamRequestBody:       true, //TODO
-		Handler:                            this.router.Handler,
-		TraceHandler:                       this.router.TraceHandler,
+		Handler:                            this.handle,
+		TraceHandler:                       this.trace,
 		Concurrency:                        this.config.MaxConcurrency,
 		LogAllErrors:                       false,
 		MaxRequestBodySize:                 this.config.MaxRequestBodySize, //200 * 1024 * 1024,
@@ -244,14 +218,15 @@ func (this *Entrypoint) Start() error {
 		MaxConnsPerIP:                      this.config.MaxConnsPerIP,
 	}
 
-	if this.routerConfig.IPAccessRules.Enabled&&len(this.routerConfig.IPAccessRules.ClientIP.DeniedList) > 0 {
+	//checked by the client ip of the requests instead if the proxies are trusted
+	if this.ipAccessChecker == nil && this.routerConfig.IPAccessRules.Enabled&&len(this.routerConfig.IPAccessRules.ClientIP.DeniedList) > 0 {
 		log.Tracef("adding %v client ip to denied list", len(this.routerConfig.IPAccessRules.ClientIP.DeniedList))
 		for _, ip := range this.routerConfig.IPAccessRules.ClientIP.DeniedList {
 			this.server.AddBlackIPList(ip)
 		}
 	}
 
-	if this.routerConfig.IPAccessRules.Enabled&&len(this.routerConfig.IPAccessRules.ClientIP.PermittedList) > 0 {
+	if this.ipAccessChecker == nil && this.routerConfig.IPAccessRules.Enabled&&len(this.routerConfig.IPAccessRules.ClientIP.PermittedList) > 0 {
 		log.Tracef("adding %v client ip to permitted list", len(this.routerConfig.IPAccessRules.ClientIP.PermittedList))
 		for _, ip := range this.routerConfig.IPAccessRules.ClientIP.PermittedList {
 			this.server.AddWhiteIPList(ip)
@@ -268,8 +243,6 @@ func (this *Entrypoint) Start() error {
 			PreferServerCipherSuites: true,
 			InsecureSkipVerify:       this.config.TLSConfig.TLSInsecureSkipVerify,
 			SessionTicketsDisabled:   false,
-			//		ClientAuth:   tls.RequireAndVerifyClientCert,
-			//		ClientCAs:    caCertPool,
 			ClientSessionCache:       tls.NewLRUClientSessionCache(this.config.TLSConfig.ClientSessionCacheSize),
 			CipherSuites: []uint16{
 				//tls.TLS_AES_128_GCM_SHA256,
@@ -359,7 +332,25 @@ func (this *Entrypoint) Start() error {
 
 		cfg.BuildNameToCertificate()
 
-		lnTls := tls.NewListener(ln, cfg)
+		if err := this.configureClientAuth(cfg); err != nil {
+			panic(err)
+		}
+
+		if err := this.configureCertificates(cfg); err != nil {
+			panic(err)
+		}
+
+		if this.config.HTTP2.Enabled {
+			cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		}
+
+		var lnTls net.Listener = tls.NewListener(ln, cfg)
+		if this.config.HTTP2.Enabled {
+			if err := this.initHTTP2(); err != nil {
+				panic(err)
+			}
+			lnTls = newHTTP2Listener(lnTls, false, this)
+		}
 
 		go func() {
 			defer func() {
@@ -386,6 +377,12 @@ func (this *Entrypoint) Start() error {
 
 	} else {
 		log.Trace("starting insecure server")
+		if this.config.HTTP2.H2C {
+			if err := this.initHTTP2(); err != nil {
+				panic(err)
+			}
+			ln = newHTTP2Listener(ln, true, this)
+		}
 		go func() {
 			defer func() {
 				if !global.Env().IsDebug {
@@ -409,9 +406,11 @@ func (this *Entrypoint) Start() error {
 		}()
 	}
 
-	err = util.WaitServerUp(this.listenAddress, 30*time.Second)
-	if err != nil {
-		panic(err)
+	if !isUnixSocket {
+		err = util.WaitServerUp(this.listenAddress, 30*time.Second)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	stats.RegisterStats(fmt.Sprintf("entry.%v.open_connections",this.GetNameOrID()), func() interface{} {
@@ -423,6 +422,190 @@ func (this *Entrypoint) Start() error {
 	return nil
 }
 
+func (this *Entrypoint) initRouter() {
+	if this.config.RouterConfigName != "" {
+		this.routerConfig = common.GetRouter(this.config.RouterConfigName)
+	}
+	this.routerHolder.Store(this.buildRouter(this.routerConfig))
+}
+
+// buildRouter creates the router and the filter flows of the router config
+func (this *Entrypoint) buildRouter(routerConfig common.RouterConfig) *r.Router {
+	router := r.New()
+
+	if routerConfig.DefaultFlow != "" {
+		router.DefaultFlow = routerConfig.DefaultFlow
+		//init func
+		router.NotFound=common.GetFlowProcess(router.DefaultFlow)
+	} else {
+		router.NotFound = func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.SetBody([]byte("NOT FOUND"))
+			ctx.Response.SetStatusCode(404)
+		}
+	}
+
+	if len(routerConfig.Rules) > 0 {
+		//rules of the same method and pattern are grouped, and evaluated by priority
+		handlers := map[string]*ruleHandler{}
+		keys := []string{}
+		routes := []*conditionalRoute{}
+		for _, rule := range common.SortRules(routerConfig.Rules) {
+
+			if routerConfig.RuleToggleEnabled && !rule.Enabled{
+				continue
+			}
+
+			matcher, err := common.NewRuleMatcher(rule)
+			if err != nil {
+				panic(err)
+			}
+
+			flow := common.FilterFlow{}
+			for _, y := range rule.Flow {
+
+				cfg,err := common.GetFlowConfig(y)
+				if err!=nil{
+					panic(err)
+				}
+
+				if len(cfg.Filters) > 0 {
+					flow1, err := pipeline.NewFilter(cfg.GetConfig())
+					if err != nil {
+						panic(err)
+					}
+					flow.JoinFilter(flow1)
+				}
+			}
+
+			for _, v := range rule.Method {
+				for _, u := range rule.PathPattern {
+					log.Debugf("apply filter flow: [%s] [%s] [ %s ]", v, u, flow.ToString())
+					key := v + " " + u
+					handler, ok := handlers[key]
+					if !ok {
+						handler = &ruleHandler{method: v, pattern: u}
+						handlers[key] = handler
+						keys = append(keys, key)
+					}
+					route := newConditionalRoute(v, u, matcher, flow.Process)
+					handler.add(route)
+					routes = append(routes, route)
+				}
+			}
+		}
+
+		for _, key := range keys {
+			handler := handlers[key]
+			if handler.method == "*" {
+				router.ANY(handler.pattern, handler.build(routes, router.NotFound))
+			} else {
+				router.Handle(handler.method, handler.pattern, handler.build(routes, router.NotFound))
+			}
+		}
+	}
+
+	if routerConfig.TracingFlow != "" {
+		if global.Env().IsDebug {
+			log.Debugf("tracing flow placed: %s", routerConfig.TracingFlow)
+		}
+		router.TracingFlow = routerConfig.TracingFlow
+		router.TraceHandler = common.GetFlowProcess(routerConfig.TracingFlow)
+	}
+
+	return router
+}
+
+func (this *Entrypoint) getRouter() *r.Router {
+	return this.routerHolder.Load().(*r.Router)
+}
+
+// handle dispatches the request to the current router, which may be swapped by Reload
+func (this *Entrypoint) handle(ctx *fasthttp.RequestCtx) {
+	this.acquireRequest()
+	defer this.releaseRequest(ctx)
+	requestID := common.InitRequestID(ctx)
+	if common.IsTracingEnabled() {
+		span := this.startRequestSpan(ctx, requestID)
+		defer this.endRequestSpan(ctx, span)
+	}
+	if this.resolveClientIP(ctx) {
+		this.getRouter().Handler(ctx)
+	}
+	ctx.Response.Header.Set(common.HeaderRequestID, requestID)
+}
+
+func (this *Entrypoint) trace(ctx *fasthttp.RequestCtx) {
+	router := this.getRouter()
+	if router.TraceHandler != nil {
+		router.TraceHandler(ctx)
+	}
+}
+
+// Reload rebuilds the router and the filter flows with the latest configs, and swaps them atomically,
+// the listener keeps serving, in-flight requests are finished by the previous router
+func (this *Entrypoint) Reload() (err error) {
+	if !this.config.Enabled || this.routerHolder.Load() == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("failed to reload entry [%v]: %v", this.String(), r)
+		}
+	}()
+
+	routerConfig := this.routerConfig
+	if this.config.RouterConfigName != "" {
+		routerConfig = common.GetRouter(this.config.RouterConfigName)
+	}
+
+	router := this.buildRouter(routerConfig)
+	this.routerConfig = routerConfig
+	this.routerHolder.Store(router)
+
+	log.Debugf("entry [%v] reloaded", this.String())
+	return nil
+}
+
+// UsesFlows checks if any of the flows is referenced by the router of the entry
+func (this *Entrypoint) UsesFlows(flows map[string]bool) bool {
+	if flows[this.routerConfig.DefaultFlow] || flows[this.routerConfig.TracingFlow] {
+		return true
+	}
+	for _, rule := range this.routerConfig.Rules {
+		for _, v := range rule.Flow {
+			if flows[v] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this *Entrypoint) startAdapter() error {
+	this.initRouter()
+
+	a, err := adapter.NewAdapter(this.config.Type, this.config, this.config.AdapterConfig)
+	if err != nil {
+		return err
+	}
+
+	handler := func(ctx *fasthttp.RequestCtx) {
+		this.handle(ctx)
+		this.trace(ctx)
+	}
+
+	if err := a.Start(handler); err != nil {
+		return errors.Errorf("error in adapter(%v) of entry [%v]: %s", this.config.Type, this.String(), err)
+	}
+	this.adapter = a
+	this.listenAddress = this.config.NetworkConfig.GetBindingAddr()
+
+	log.Infof("entry [%s] listen at: %s%s", this.String(), this.GetSchema(), this.listenAddress)
+
+	return nil
+}
+
 func (this *Entrypoint) GetNameOrID()string{
 	if this.config.Name!=""{
 		return this.config.Name
@@ -438,6 +621,9 @@ func (this *Entrypoint) GetSchema()string{
 	if this.schema!=""{
 		return this.schema
 	}
+	if this.adapter != nil {
+		return this.adapter.Name() + "://"
+	}
 	if this.config.TLSConfig.TLSEnabled{
 		return "https://"
 	}else{
@@ -479,6 +665,20 @@ func (this *Entrypoint) Stop() error {
 		return nil
 	}
 
+	if this.adapter != nil {
+		return this.adapter.Stop()
+	}
+
+	if this.certStore != nil {
+		this.certStore.Stop()
+	}
+
+	this.shutdownHTTP2()
+
+	if this.config.Drain.Enabled {
+		return this.drain()
+	}
+
 	if this.config.DirtyShutdown {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Millisecond*5000))
 		defer cancel()
@@ -535,6 +735,9 @@ func (this *Entrypoint) Stop() error {
 			for {
 				select {
 				case <-ticker.C:
+					if common.IsUnixSocket(this.listenAddress) {
+						continue
+					}
 					time.Sleep(1*time.Second)
 					if util.ContainStr(this.listenAddress,"0.0.0.0"){
 						this.listenAddress=strings.Replace(this.listenAddress,"0.0.0.0","127.0.0.1",-1)
@@ -553,49 +756,3 @@ func (this *Entrypoint) Stop() error {
 
 	return nil
 }
-
-func (this *Entrypoint) RefreshTracingFlow() {
-
-	if this.router != nil {
-		if this.router.TracingFlow != "" {
-			this.router.TraceHandler = common.GetFlowProcess(this.routerConfig.TracingFlow)
-			if this.server != nil {
-				this.server.TraceHandler = this.router.TraceHandler
-			}
-		}
-	}
-}
-
-func (this *Entrypoint) RefreshDefaultFlow() {
-
-	if this.router != nil {
-		if this.router.DefaultFlow != "" {
-			this.router.NotFound = common.GetFlowProcess(this.routerConfig.DefaultFlow)
-			if this.server != nil {
-				this.server.Handler = this.router.NotFound
-			}
-		}
-	}
-}
-
-func (this *Entrypoint) UpdateTracingFlow(flow string) {
-	if flow != "" {
-		if this.router != nil {
-			this.router.TracingFlow = this.routerConfig.TracingFlow
-			this.router.TraceHandler = common.GetFlowProcess(this.routerConfig.TracingFlow)
-		}
-		if this.server != nil {
-			this.server.TraceHandler = this.router.TraceHandler
-		}
-	} else {
-		if this.router != nil {
-			this.router.TracingFlow = ""
-			this.router.TraceHandler = nil
-		}
-
-		if this.server != nil {
-			this.server.TraceHandler = nil
-		}
-	}
-
-}
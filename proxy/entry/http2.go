@@ -0,0 +1,384 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/net/http2"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+var http2Preface = []byte("PRI")
+
+const http2HandshakeTimeout = 10 * time.Second
+
+// http2ConnHandler serves the HTTP/2 connections, the connections are checked before serving,
+// as they never reach the fasthttp server, which checks the HTTP/1.1 connections
+type http2ConnHandler interface {
+	acquireHTTP2Conn(conn net.Conn) bool
+	releaseHTTP2Conn(conn net.Conn)
+	serveHTTP2Conn(conn net.Conn)
+}
+
+// http2Listener sends the HTTP/2 connections to the HTTP/2 server, and the rest to the fasthttp server,
+// HTTP/2 is negotiated by ALPN over TLS, or detected by the connection preface over cleartext
+type http2Listener struct {
+	net.Listener
+	h2c     bool
+	handler http2ConnHandler
+	conns   chan net.Conn
+	closed  chan struct{}
+}
+
+func newHTTP2Listener(ln net.Listener, h2c bool, handler http2ConnHandler) net.Listener {
+	l := &http2Listener{
+		Listener: ln,
+		h2c:      h2c,
+		handler:  handler,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (this *http2Listener) acceptLoop() {
+	defer close(this.closed)
+	for {
+		conn, err := this.Listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go this.dispatch(conn)
+	}
+}
+
+func (this *http2Listener) dispatch(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(http2HandshakeTimeout))
+		err := tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Debugf("tls handshake error from [%v]: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+			this.serveHTTP2(conn)
+			return
+		}
+	} else if this.h2c {
+		peeked := &peekedConn{Conn: conn, reader: bufio.NewReader(conn)}
+		conn.SetReadDeadline(time.Now().Add(http2HandshakeTimeout))
+		isHTTP2, err := hasHTTP2Preface(peeked.reader)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return
+		}
+		conn = peeked
+		if isHTTP2 {
+			this.serveHTTP2(conn)
+			return
+		}
+	}
+
+	select {
+	case this.conns <- conn:
+	case <-this.closed:
+		conn.Close()
+	}
+}
+
+// hasHTTP2Preface checks the connection preface byte by byte, so the short HTTP/1 requests are not blocked, they are
+// sent to the fasthttp server as soon as a byte differs from the preface
+func hasHTTP2Preface(reader *bufio.Reader) (bool, error) {
+	for i := 1; i <= len(http2Preface); i++ {
+		prefix, err := reader.Peek(i)
+		if err != nil {
+			return false, err
+		}
+		if prefix[i-1] != http2Preface[i-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (this *http2Listener) serveHTTP2(conn net.Conn) {
+	if !this.handler.acquireHTTP2Conn(conn) {
+		conn.Close()
+		return
+	}
+	defer this.handler.releaseHTTP2Conn(conn)
+	this.handler.serveHTTP2Conn(conn)
+}
+
+func (this *http2Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-this.conns:
+		return conn, nil
+	case <-this.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (this *peekedConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+// http2Conn presents the addresses and the tls state of the HTTP/2 connection to the request context
+type http2Conn struct {
+	net.Conn
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (this *http2Conn) RemoteAddr() net.Addr {
+	return this.remoteAddr
+}
+
+func (this *http2Conn) LocalAddr() net.Addr {
+	return this.localAddr
+}
+
+type http2TLSConn struct {
+	http2Conn
+	state tls.ConnectionState
+}
+
+func (this *http2TLSConn) Handshake() error {
+	return nil
+}
+
+func (this *http2TLSConn) ConnectionState() tls.ConnectionState {
+	return this.state
+}
+
+type http2ConnKey struct{}
+
+var http2CtxPool = &sync.Pool{
+	New: func() interface{} {
+		return &fasthttp.RequestCtx{}
+	},
+}
+
+var http2HopHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"content-length":    true,
+}
+
+// http2Limits applies the connection checks and the concurrency limit of the fasthttp server to HTTP/2,
+// the concurrency is limited by streams, as each stream is a request
+type http2Limits struct {
+	ipAccessChecker *common.IPAccessChecker
+	maxConnsPerIP   int
+	lock            sync.Mutex
+	conns           map[string]int
+	streams         chan struct{}
+}
+
+// initHTTP2 prepares the HTTP/2 server, which dispatches each stream into the router of the entry
+func (this *Entrypoint) initHTTP2() error {
+	this.http2Limits = &http2Limits{
+		maxConnsPerIP: this.config.MaxConnsPerIP,
+		conns:         map[string]int{},
+		streams:       make(chan struct{}, this.config.MaxConcurrency),
+	}
+
+	//checked by the client ip of the requests instead if the proxies are trusted
+	if this.ipAccessChecker == nil && this.routerConfig.IPAccessRules.Enabled {
+		checker, err := common.NewIPAccessChecker(this.routerConfig.IPAccessRules)
+		if err != nil {
+			return err
+		}
+		this.http2Limits.ipAccessChecker = checker
+	}
+
+	this.http2Server = &http2.Server{
+		MaxConcurrentStreams: this.config.HTTP2.MaxConcurrentStreams,
+		IdleTimeout:          time.Duration(this.config.IdleTimeout) * time.Second,
+	}
+	this.http2BaseServer = &http.Server{
+		Handler:      http.HandlerFunc(this.serveHTTP2),
+		ReadTimeout:  time.Duration(this.config.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(this.config.WriteTimeout) * time.Second,
+	}
+	return http2.ConfigureServer(this.http2BaseServer, this.http2Server)
+}
+
+func http2RemoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// acquireHTTP2Conn checks the ip access rules and the connections per ip, returns false if the connection is denied
+func (this *Entrypoint) acquireHTTP2Conn(conn net.Conn) bool {
+	ip := http2RemoteIP(conn)
+	if ip == nil {
+		return true
+	}
+
+	limits := this.http2Limits
+	if limits.ipAccessChecker != nil && !limits.ipAccessChecker.Allow(ip) {
+		log.Debugf("http2 connection from [%v] is not allowed", ip)
+		return false
+	}
+
+	if limits.maxConnsPerIP > 0 {
+		limits.lock.Lock()
+		defer limits.lock.Unlock()
+		if limits.conns[ip.String()] >= limits.maxConnsPerIP {
+			log.Debugf("too many http2 connections from [%v]", ip)
+			return false
+		}
+		limits.conns[ip.String()]++
+	}
+	return true
+}
+
+func (this *Entrypoint) releaseHTTP2Conn(conn net.Conn) {
+	ip := http2RemoteIP(conn)
+	limits := this.http2Limits
+	if ip == nil || limits.maxConnsPerIP <= 0 {
+		return
+	}
+
+	limits.lock.Lock()
+	defer limits.lock.Unlock()
+	if limits.conns[ip.String()] <= 1 {
+		delete(limits.conns, ip.String())
+	} else {
+		limits.conns[ip.String()]--
+	}
+}
+
+func (this *Entrypoint) serveHTTP2Conn(conn net.Conn) {
+	this.http2Server.ServeConn(conn, &http2.ServeConnOpts{
+		Context:    context.WithValue(context.Background(), http2ConnKey{}, conn),
+		BaseConfig: this.http2BaseServer,
+		Handler:    this.http2BaseServer.Handler,
+	})
+}
+
+// shutdownHTTP2 asks the HTTP/2 clients to close the connections after the in-flight streams
+func (this *Entrypoint) shutdownHTTP2() {
+	if this.http2BaseServer != nil {
+		this.http2BaseServer.Shutdown(context.Background())
+	}
+}
+
+// serveHTTP2 converts the HTTP/2 stream into a fasthttp request, and runs it through the router
+func (this *Entrypoint) serveHTTP2(w http.ResponseWriter, r *http.Request) {
+	select {
+	case this.http2Limits.streams <- struct{}{}:
+		defer func() { <-this.http2Limits.streams }()
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := http2CtxPool.Get().(*fasthttp.RequestCtx)
+	ctx.Reset()
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	defer http2CtxPool.Put(ctx)
+
+	conn := &http2Conn{}
+	if c, ok := r.Context().Value(http2ConnKey{}).(net.Conn); ok {
+		conn.Conn = c
+		conn.remoteAddr = c.RemoteAddr()
+		conn.localAddr = c.LocalAddr()
+	}
+	if r.TLS != nil {
+		ctx.Init2(&http2TLSConn{http2Conn: *conn, state: *r.TLS}, nil, false)
+	} else {
+		ctx.Init2(conn, nil, false)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(this.config.MaxRequestBodySize)+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(body) > this.config.MaxRequestBodySize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx.Request.Header.SetMethod(r.Method)
+	ctx.Request.SetRequestURI(r.URL.RequestURI())
+	ctx.Request.Header.SetHost(r.Host)
+	for k, values := range r.Header {
+		for _, v := range values {
+			ctx.Request.Header.Add(k, v)
+		}
+	}
+	ctx.Request.SetBody(body)
+
+	this.handle(ctx)
+	this.trace(ctx)
+
+	header := w.Header()
+	ctx.Response.Header.VisitAll(func(key, value []byte) {
+		k := string(key)
+		if http2HopHeaders[strings.ToLower(k)] {
+			return
+		}
+		header.Add(k, string(value))
+	})
+	w.WriteHeader(ctx.Response.StatusCode())
+	if r.Method != http.MethodHead {
+		w.Write(ctx.Response.Body())
+	}
+}
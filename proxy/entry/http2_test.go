@@ -0,0 +1,163 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	config3 "infini.sh/framework/core/config"
+	"infini.sh/gateway/common"
+)
+
+func newH2CClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+}
+
+func newH2CEntry(port uint, rules common.IPAccessRules) *Entrypoint {
+	config := common.EntryConfig{Enabled: true}
+	config.Name = "h2c"
+	config.MaxConcurrency = 100
+	config.HTTP2.H2C = true
+	config.NetworkConfig = config3.NetworkConfig{Host: "127.0.0.1", Port: port}
+	entry := &Entrypoint{config: config}
+	entry.routerConfig.IPAccessRules = rules
+	return entry
+}
+
+func TestHTTP2RoundTrip(t *testing.T) {
+	entry := newH2CEntry(8082, common.IPAccessRules{})
+	assert.Nil(t, entry.Start())
+	defer entry.Stop()
+
+	res, err := newH2CClient().Get("http://127.0.0.1:8082/")
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "NOT FOUND", string(body))
+	assert.NotEmpty(t, res.Header.Get(common.HeaderRequestID))
+
+	//HTTP/1.1 clients are still served by the fasthttp server
+	res, err = http.Get("http://127.0.0.1:8082/")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 1, res.ProtoMajor)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestHTTP2DeniedClientIP(t *testing.T) {
+	rules := common.IPAccessRules{Enabled: true}
+	rules.ClientIP.DeniedList = []string{"127.0.0.1"}
+	entry := newH2CEntry(8083, rules)
+	assert.Nil(t, entry.Start())
+	defer entry.Stop()
+
+	_, err := newH2CClient().Get("http://127.0.0.1:8083/")
+	assert.NotNil(t, err)
+}
+
+type http2TestConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (this *http2TestConn) RemoteAddr() net.Addr {
+	return this.addr
+}
+
+func TestHTTP2ConnsPerIP(t *testing.T) {
+	entry := &Entrypoint{}
+	entry.config.MaxConnsPerIP = 2
+	entry.config.MaxConcurrency = 1
+	assert.Nil(t, entry.initHTTP2())
+
+	conn1 := &http2TestConn{addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1001}}
+	conn2 := &http2TestConn{addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1002}}
+	conn3 := &http2TestConn{addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1003}}
+	other := &http2TestConn{addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1001}}
+
+	assert.True(t, entry.acquireHTTP2Conn(conn1))
+	assert.True(t, entry.acquireHTTP2Conn(conn2))
+	assert.False(t, entry.acquireHTTP2Conn(conn3))
+	assert.True(t, entry.acquireHTTP2Conn(other))
+
+	entry.releaseHTTP2Conn(conn1)
+	assert.True(t, entry.acquireHTTP2Conn(conn3))
+}
+
+func TestHTTP2ConcurrencyLimit(t *testing.T) {
+	entry := &Entrypoint{}
+	entry.config.MaxConcurrency = 1
+	assert.Nil(t, entry.initHTTP2())
+
+	//the only slot is taken by another stream
+	entry.http2Limits.streams <- struct{}{}
+	w := httptest.NewRecorder()
+	entry.serveHTTP2(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHasHTTP2Preface(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	//a short HTTP/1 request is detected by the first byte, without waiting for more
+	go client.Write([]byte("G"))
+	isHTTP2, err := hasHTTP2Preface(bufio.NewReader(server))
+	assert.Nil(t, err)
+	assert.False(t, isHTTP2)
+
+	isHTTP2, err = hasHTTP2Preface(bufio.NewReader(strings.NewReader(http2.ClientPreface)))
+	assert.Nil(t, err)
+	assert.True(t, isHTTP2)
+
+	isHTTP2, err = hasHTTP2Preface(bufio.NewReader(strings.NewReader("PUT /index HTTP/1.1\r\n\r\n")))
+	assert.Nil(t, err)
+	assert.False(t, isHTTP2)
+
+	_, err = hasHTTP2Preface(bufio.NewReader(strings.NewReader("PR")))
+	assert.Equal(t, io.EOF, err)
+}
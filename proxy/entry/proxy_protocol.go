@@ -0,0 +1,309 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/util"
+	"infini.sh/gateway/common"
+)
+
+var proxyProtocolV1Prefix = []byte("PROXY ")
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyProtocolV1MaxLength = 107
+
+// ReadProxyHeader reads the PROXY protocol v1 or v2 header, and returns the source and destination address,
+// nil addresses are returned if there is no header, or the header is a LOCAL or UNKNOWN one
+func ReadProxyHeader(reader *bufio.Reader) (src net.Addr, dst net.Addr, err error) {
+	sig, err := reader.Peek(len(proxyProtocolV1Prefix))
+	if err != nil {
+		if err == io.EOF || err == bufio.ErrBufferFull {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if bytes.Equal(sig, proxyProtocolV1Prefix) {
+		return readProxyHeaderV1(reader)
+	}
+
+	if bytes.Equal(sig, proxyProtocolV2Signature[:len(sig)]) {
+		sig, err = reader.Peek(len(proxyProtocolV2Signature))
+		if err == nil && bytes.Equal(sig, proxyProtocolV2Signature) {
+			return readProxyHeaderV2(reader)
+		}
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, proxyProtocolV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid proxy protocol v1 header: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, nil, errors.New("proxy protocol v1 header is too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("invalid proxy protocol v1 header, missing CRLF")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v1 header: %v", string(line))
+	}
+
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid address in proxy protocol header: %v", ip)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid port in proxy protocol header: %v", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v2 header: %v", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v2 version: %v", header[12]>>4)
+	}
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v2 header: %v", err)
+	}
+
+	//LOCAL command, the connection is established by the proxy itself
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, fmt.Errorf("invalid proxy protocol v2 command: %v", command)
+	}
+
+	var size int
+	switch family {
+	case 1: //AF_INET
+		size = net.IPv4len
+	case 2: //AF_INET6
+		size = net.IPv6len
+	default:
+		//AF_UNSPEC or AF_UNIX, the address is not usable
+		return nil, nil, nil
+	}
+
+	if length < size*2+4 {
+		return nil, nil, errors.New("proxy protocol v2 address is too short")
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[:size]...)),
+		Port: int(binary.BigEndian.Uint16(payload[size*2:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[size:size*2]...)),
+		Port: int(binary.BigEndian.Uint16(payload[size*2+2:])),
+	}
+	return src, dst, nil
+}
+
+// wrapProxyProtocol makes the real client address of the PROXY protocol header available as the remote address
+func (this *Entrypoint) wrapProxyProtocol(ln net.Listener) (net.Listener, error) {
+	cfg := this.config.NetworkExt.ProxyProtocol
+	//any client could spoof its address without the allowlist
+	if len(cfg.TrustedCIDR) == 0 {
+		return nil, fmt.Errorf("trusted_cidr is required to enable proxy_protocol for entry [%v]", this.String())
+	}
+	trusted := []*net.IPNet{}
+	for _, v := range cfg.TrustedCIDR {
+		cidr, err := common.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		trusted = append(trusted, cidr)
+	}
+	log.Debugf("proxy protocol enabled for entry [%v], trusted sources: %v", this.String(), cfg.TrustedCIDR)
+	return newProxyProtocolListener(ln, trusted, util.GetDurationOrDefault(cfg.Timeout, 5*time.Second)), nil
+}
+
+// proxyProtocolListener accepts the connections from the load balancers, which are prefixed with PROXY protocol headers,
+// the headers are read in the goroutines of the connections, so that the accept loop is not blocked by the slow clients,
+// and the connections are handed to the server with the real client addresses
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+	conns   chan net.Conn
+	closed  chan struct{}
+}
+
+func newProxyProtocolListener(ln net.Listener, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	l := &proxyProtocolListener{
+		Listener: ln,
+		trusted:  trusted,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (this *proxyProtocolListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, cidr := range this.trusted {
+		if cidr.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *proxyProtocolListener) acceptLoop() {
+	defer close(this.closed)
+	for {
+		conn, err := this.Listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go this.dispatch(conn)
+	}
+}
+
+func (this *proxyProtocolListener) dispatch(conn net.Conn) {
+	if this.isTrusted(conn.RemoteAddr()) {
+		reader := bufio.NewReader(conn)
+		if this.timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(this.timeout))
+		}
+		src, dst, err := ReadProxyHeader(reader)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			log.Debugf("failed to read proxy protocol header from [%v]: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn = &proxyProtocolConn{Conn: conn, reader: reader, src: src, dst: dst}
+	}
+
+	select {
+	case this.conns <- conn:
+	case <-this.closed:
+		conn.Close()
+	}
+}
+
+func (this *proxyProtocolListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-this.conns:
+		return conn, nil
+	case <-this.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// proxyProtocolConn presents the addresses of the PROXY protocol header, the addresses of the connection are used
+// for the LOCAL command, eg: the health checks of the load balancers
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	src    net.Addr
+	dst    net.Addr
+}
+
+func (this *proxyProtocolConn) Read(b []byte) (int, error) {
+	return this.reader.Read(b)
+}
+
+func (this *proxyProtocolConn) RemoteAddr() net.Addr {
+	if this.src != nil {
+		return this.src
+	}
+	return this.Conn.RemoteAddr()
+}
+
+func (this *proxyProtocolConn) LocalAddr() net.Addr {
+	if this.dst != nil {
+		return this.dst
+	}
+	return this.Conn.LocalAddr()
+}
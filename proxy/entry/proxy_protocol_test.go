@@ -0,0 +1,176 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readHeader(data string) (net.Addr, net.Addr, string, error) {
+	reader := bufio.NewReader(strings.NewReader(data))
+	src, dst, err := ReadProxyHeader(reader)
+	rest, _ := io.ReadAll(reader)
+	return src, dst, string(rest), err
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	src, dst, rest, err := readHeader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1:56324", src.String())
+	assert.Equal(t, "192.168.0.11:443", dst.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", rest)
+
+	src, _, _, err = readHeader("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "[2001:db8::1]:56324", src.String())
+
+	src, _, rest, err = readHeader("PROXY UNKNOWN\r\nGET /")
+	assert.Nil(t, err)
+	assert.Nil(t, src)
+	assert.Equal(t, "GET /", rest)
+
+	_, _, _, err = readHeader("PROXY TCP4 192.168.0.1 56324 443\r\n")
+	assert.NotNil(t, err)
+
+	_, _, _, err = readHeader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443" + strings.Repeat(" ", 100) + "\r\n")
+	assert.NotNil(t, err)
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	header := string(proxyProtocolV2Signature) +
+		"\x21\x11\x00\x0f" + //PROXY, AF_INET STREAM, 12 bytes of address and 3 bytes of TLV
+		"\xc0\xa8\x00\x01" + "\xc0\xa8\x00\x0b" + "\xdc\x04" + "\x01\xbb" +
+		"\x04\x00\x00"
+	src, dst, rest, err := readHeader(header + "GET /")
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1:56324", src.String())
+	assert.Equal(t, "192.168.0.11:443", dst.String())
+	assert.Equal(t, "GET /", rest)
+
+	local := string(proxyProtocolV2Signature) + "\x20\x00\x00\x00"
+	src, _, rest, err = readHeader(local + "GET /")
+	assert.Nil(t, err)
+	assert.Nil(t, src)
+	assert.Equal(t, "GET /", rest)
+
+	_, _, _, err = readHeader(string(proxyProtocolV2Signature) + "\x21\x11\x00\x0c\xc0\xa8")
+	assert.NotNil(t, err)
+}
+
+func TestReadProxyHeaderWithoutHeader(t *testing.T) {
+	src, _, rest, err := readHeader("GET / HTTP/1.1\r\n\r\n")
+	assert.Nil(t, err)
+	assert.Nil(t, src)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", rest)
+
+	src, _, rest, err = readHeader("GET")
+	assert.Nil(t, err)
+	assert.Nil(t, src)
+	assert.Equal(t, "GET", rest)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	accept := func(trusted *net.IPNet, data string) (string, string) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		var cidrs []*net.IPNet
+		if trusted != nil {
+			cidrs = append(cidrs, trusted)
+		}
+		l := newProxyProtocolListener(ln, cidrs, time.Second)
+		defer l.Close()
+		go func() {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			assert.Nil(t, err)
+			c.Write([]byte(data))
+			c.Close()
+		}()
+		conn, err := l.Accept()
+		assert.Nil(t, err)
+		defer conn.Close()
+		body, _ := io.ReadAll(conn)
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		return host, string(body)
+	}
+
+	host, body := accept(loopback, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping")
+	assert.Equal(t, "192.168.0.1", host)
+	assert.Equal(t, "ping", body)
+
+	//headers from the untrusted sources are ignored
+	host, body = accept(other, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping")
+	assert.Equal(t, "127.0.0.1", host)
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping", body)
+
+	//no source is trusted without the allowlist
+	host, body = accept(nil, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping")
+	assert.Equal(t, "127.0.0.1", host)
+	assert.Equal(t, "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping", body)
+}
+
+func TestProxyProtocolListenerSlowClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	l := newProxyProtocolListener(ln, []*net.IPNet{loopback}, 5*time.Second)
+	defer l.Close()
+
+	//the client which doesn't send the header doesn't block the others
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer slow.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		c.Write([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nping"))
+		c.Close()
+	}()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	select {
+	case conn := <-accepted:
+		assert.Equal(t, "192.168.0.1:56324", conn.RemoteAddr().String())
+		conn.Close()
+	case <-time.After(time.Second):
+		assert.Fail(t, "blocked by the slow client")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	config3 "infini.sh/framework/core/config"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+	"infini.sh/gateway/proxy/entry/adapter"
+)
+
+func TestReloadSwapsRouter(t *testing.T) {
+	common.RegisterRouterConfig(common.RouterConfig{Name: "test_reload"})
+
+	config := common.EntryConfig{Enabled: true, RouterConfigName: "test_reload"}
+	config.Name = "reload"
+	config.MaxConcurrency = 100
+	config.NetworkConfig = config3.NetworkConfig{Host: "127.0.0.1", Port: 8086}
+	entry := &Entrypoint{config: config}
+	assert.Nil(t, entry.Start())
+	defer entry.Stop()
+
+	res, err := http.Get("http://127.0.0.1:8086/")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 404, res.StatusCode)
+
+	//the listener keeps serving with the new router
+	common.RegisterFlowConfigs([]common.FlowConfig{{Name: "test_reload_flow"}})
+	common.RegisterRouterConfig(common.RouterConfig{Name: "test_reload", DefaultFlow: "test_reload_flow"})
+	assert.False(t, entry.UsesFlows(map[string]bool{"test_reload_flow": true}))
+	assert.Nil(t, entry.Reload())
+	assert.True(t, entry.UsesFlows(map[string]bool{"test_reload_flow": true}))
+
+	res, err = http.Get("http://127.0.0.1:8086/")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+type testAdapter struct {
+	handler fasthttp.RequestHandler
+}
+
+func (this *testAdapter) Name() string {
+	return "test"
+}
+
+func (this *testAdapter) Start(handler fasthttp.RequestHandler) error {
+	this.handler = handler
+	return nil
+}
+
+func (this *testAdapter) Stop() error {
+	return nil
+}
+
+func TestReloadAdapterEntry(t *testing.T) {
+	a := &testAdapter{}
+	adapter.RegisterAdapter("test", func(entry common.EntryConfig, cfg *config3.Config) (adapter.Adapter, error) {
+		return a, nil
+	})
+	common.RegisterRouterConfig(common.RouterConfig{Name: "test_reload_adapter"})
+
+	config := common.EntryConfig{Enabled: true, Type: "test", RouterConfigName: "test_reload_adapter"}
+	config.Name = "reload_adapter"
+	entry := &Entrypoint{config: config}
+	assert.Nil(t, entry.Start())
+	defer entry.Stop()
+	assert.Equal(t, "test://", entry.GetSchema())
+
+	request := adapter.RequestConfig{}
+	status := 0
+	callback := func(ctx *fasthttp.RequestCtx) {
+		status = ctx.Response.StatusCode()
+	}
+	request.Process("test", adapter.Message{Body: []byte("{}")}, a.handler, callback)
+	assert.Equal(t, 404, status)
+
+	//the handler of the adapter dispatches to the reloaded router
+	common.RegisterFlowConfigs([]common.FlowConfig{{Name: "test_reload_adapter_flow"}})
+	common.RegisterRouterConfig(common.RouterConfig{Name: "test_reload_adapter", DefaultFlow: "test_reload_adapter_flow"})
+	assert.Nil(t, entry.Reload())
+	request.Process("test", adapter.Message{Body: []byte("{}")}, a.handler, callback)
+	assert.Equal(t, 200, status)
+}
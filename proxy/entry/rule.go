@@ -0,0 +1,105 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"regexp"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+type conditionalRoute struct {
+	method  string
+	pattern string
+	//nil if the pattern is not supported, the route is not evaluated for the other patterns then
+	path    *regexp.Regexp
+	matcher *common.RuleMatcher
+	handler fasthttp.RequestHandler
+}
+
+func newConditionalRoute(method, pattern string, matcher *common.RuleMatcher, handler fasthttp.RequestHandler) *conditionalRoute {
+	path, err := common.CompilePathPattern(pattern)
+	if err != nil {
+		log.Warnf("the rules of pattern [%v] are only evaluated for its own requests: %v", pattern, err)
+	}
+	return &conditionalRoute{method: method, pattern: pattern, path: path, matcher: matcher, handler: handler}
+}
+
+func (this *conditionalRoute) match(ctx *fasthttp.RequestCtx) bool {
+	return this.matcher == nil || this.matcher.Match(ctx)
+}
+
+// ruleHandler holds the rules of the same method and path pattern, the first matched rule takes the request,
+// the rules of the other methods and patterns matching the path are evaluated if none of them are matched
+type ruleHandler struct {
+	method  string
+	pattern string
+	routes  []*conditionalRoute
+}
+
+func (this *ruleHandler) add(route *conditionalRoute) {
+	this.routes = append(this.routes, route)
+}
+
+// build returns the handler of the rules, the routes of the other methods and patterns are evaluated in order when
+// none of the rules are matched, so a request is not sent to the fallback handler just because the router picked a
+// more specific pattern of other hosts, the fallback handler is used at last
+func (this *ruleHandler) build(all []*conditionalRoute, fallback fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if len(this.routes) == 1 && this.routes[0].matcher == nil {
+		return this.routes[0].handler
+	}
+
+	others := []*conditionalRoute{}
+	for _, route := range all {
+		if route.path != nil && (route.method != this.method || route.pattern != this.pattern) {
+			others = append(others, route)
+		}
+	}
+
+	routes := this.routes
+	return func(ctx *fasthttp.RequestCtx) {
+		for _, route := range routes {
+			if route.match(ctx) {
+				route.handler(ctx)
+				return
+			}
+		}
+
+		if len(others) > 0 {
+			method, path := string(ctx.Method()), string(ctx.Path())
+			for _, route := range others {
+				if (route.method == "*" || route.method == method) && route.path.MatchString(path) && route.match(ctx) {
+					route.handler(ctx)
+					return
+				}
+			}
+		}
+		fallback(ctx)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+func newTestRoute(t *testing.T, method, pattern string, hosts []string, handled *string, name string) *conditionalRoute {
+	matcher, err := common.NewRuleMatcher(common.RuleConfig{Host: hosts})
+	assert.Nil(t, err)
+	return newConditionalRoute(method, pattern, matcher, func(ctx *fasthttp.RequestCtx) {
+		*handled = name
+	})
+}
+
+func TestRuleHandlerFallback(t *testing.T) {
+	handled := ""
+	routes := []*conditionalRoute{
+		newTestRoute(t, "GET", "/{index}/_search", []string{"b.com"}, &handled, "b"),
+		newTestRoute(t, "*", "/{any:*}", []string{"a.com"}, &handled, "a"),
+		newTestRoute(t, "POST", "/{any:*}", []string{"c.com"}, &handled, "c"),
+	}
+	handler := &ruleHandler{method: "GET", pattern: "/{index}/_search"}
+	handler.add(routes[0])
+	process := handler.build(routes, func(ctx *fasthttp.RequestCtx) {
+		handled = "default"
+	})
+
+	request := func(host string) string {
+		handled = ""
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI("/logs/_search")
+		ctx.Request.Header.SetHost(host)
+		process(ctx)
+		return handled
+	}
+
+	assert.Equal(t, "b", request("b.com"))
+	//the rules of the less specific pattern are evaluated before the default flow
+	assert.Equal(t, "a", request("a.com"))
+	//the rules of other methods are not
+	assert.Equal(t, "default", request("c.com"))
+	assert.Equal(t, "default", request("d.com"))
+}
@@ -0,0 +1,123 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/gateway/common"
+)
+
+// GetClientAuthType returns the client certificate policy of the mode
+func GetClientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "verify":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid client_auth mode [%v], should be one of none, request, require, verify_if_given or verify", mode)
+}
+
+func (this *Entrypoint) configureClientAuth(cfg *tls.Config) error {
+	clientAuth, err := GetClientAuthType(this.config.TLSClientAuth.ClientAuth)
+	if err != nil {
+		return err
+	}
+	cfg.ClientAuth = clientAuth
+
+	if this.config.TLSClientAuth.ClientCAFile != "" {
+		cfg.ClientCAs, err = common.LoadCertPool(this.config.TLSClientAuth.ClientCAFile)
+		if err != nil {
+			return err
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		//fallback to the auto generated root cert
+		if this.certPool == nil && this.config.TLSConfig.TLSCertFile == "" {
+			ca := path.Join(global.Env().GetDataDir(), "certs", "root.cert")
+			if util.FileExists(ca) {
+				this.certPool, err = common.LoadCertPool(ca)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if this.certPool == nil {
+			return fmt.Errorf("client_ca_file is required to verify the client certificates of entry [%v]", this.GetNameOrID())
+		}
+		cfg.ClientCAs = this.certPool
+	}
+	return nil
+}
+
+// configureCertificates selects the certificates by SNI, and reloads them when the files changed
+func (this *Entrypoint) configureCertificates(cfg *tls.Config) error {
+	certs := this.config.TLSCertificates
+
+	var defaultPair *common.TLSCertificate
+	if this.config.TLSConfig.TLSCertFile != "" && this.config.TLSConfig.TLSKeyFile != "" {
+		defaultPair = &common.TLSCertificate{CertFile: this.config.TLSConfig.TLSCertFile, KeyFile: this.config.TLSConfig.TLSKeyFile}
+	}
+
+	if defaultPair == nil && len(certs.Certificates) == 0 && certs.CertDir == "" {
+		return nil
+	}
+
+	store := NewCertificateStore(defaultPair, certs)
+	if _, err := store.Reload(); err != nil {
+		return err
+	}
+	cfg.GetCertificate = store.GetCertificate
+	store.Watch(util.GetDurationOrDefault(certs.ReloadInterval, 10*time.Second))
+	this.certStore = store
+
+	stats.RegisterStats(fmt.Sprintf("entry.%v.certificates", this.GetNameOrID()), func() interface{} {
+		return store.Certificates()
+	})
+	return nil
+}
+
+// GetCertificates returns the certificates of the entry with the expiry dates
+func (this *Entrypoint) GetCertificates() []CertificateInfo {
+	if this.certStore == nil {
+		return []CertificateInfo{}
+	}
+	return this.certStore.Certificates()
+}
@@ -0,0 +1,57 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package entry
+
+import (
+	"fmt"
+
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// startRequestSpan starts the root span of the request, the filters and the upstream calls are nested in it
+func (this *Entrypoint) startRequestSpan(ctx *fasthttp.RequestCtx, requestID string) common.Span {
+	span := common.StartSpan(ctx, fmt.Sprintf("%s %s", ctx.Method(), this.GetNameOrID()), common.SpanKindServer)
+	span.SetAttribute("entry.name", this.GetNameOrID())
+	span.SetAttribute("request.id", requestID)
+	span.SetAttribute("http.request.method", string(ctx.Method()))
+	span.SetAttribute("url.path", string(ctx.Path()))
+	span.SetAttribute("server.address", string(ctx.Host()))
+	return span
+}
+
+func (this *Entrypoint) endRequestSpan(ctx *fasthttp.RequestCtx, span common.Span) {
+	span.SetAttribute("client.address", common.GetClientIP(ctx).String())
+	span.SetAttribute("http.response.status_code", ctx.Response.StatusCode())
+	if ctx.Has("elastic_cluster_name") {
+		span.SetAttribute("elasticsearch.cluster", ctx.MustGetStringArray("elastic_cluster_name"))
+	}
+	if ctx.Response.StatusCode() >= 500 {
+		span.RecordError(fmt.Errorf("status code: %v", ctx.Response.StatusCode()))
+	}
+	span.End()
+}
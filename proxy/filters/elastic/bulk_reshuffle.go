@@ -322,10 +322,11 @@ func (this *BulkReshuffle) Filter(ctx *fasthttp.RequestCtx) {
 					totalShards := len(table)
 					if totalShards > 1 {
 						//如果 shards=1，则直接找主分片所在节点，否则计算一下。
-						shardID = elastic.GetShardID(metadata.GetMajorVersion(), []byte(id), totalShards)
+						routingNumShards := common.GetRoutingNumShards(metadata, index, totalShards)
+						shardID = common.GetShardID(metadata.GetMajorVersion(), id, routing, totalShards, routingNumShards)
 
 						if global.Env().IsDebug {
-							log.Tracef("%s/%s => %v", index, id, shardID)
+							log.Tracef("%s/%s/%s => %v", index, id, routing, shardID)
 						}
 
 						//check enabled shards
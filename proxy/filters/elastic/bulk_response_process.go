@@ -89,6 +89,13 @@ func (this *BulkResponseProcess) Filter(ctx *fasthttp.RequestCtx) {
 			ctx.Set("bulk_response_status", bulkResults)
 		}
 
+		if common.IsTracingEnabled() {
+			span := common.CurrentSpan(ctx)
+			span.SetAttribute("elasticsearch.bulk.success_items", successItems.GetMessageCount())
+			span.SetAttribute("elasticsearch.bulk.invalid_items", nonRetryableItems.GetMessageCount())
+			span.SetAttribute("elasticsearch.bulk.failure_items", retryableItems.GetMessageCount())
+		}
+
 		//stats only, skip further process
 		if this.config.StatsOnly {
 			return
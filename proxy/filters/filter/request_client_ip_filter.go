@@ -31,6 +31,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type RequestClientIPFilter struct {
@@ -45,7 +46,7 @@ func (filter *RequestClientIPFilter) Name() string {
 
 func (filter *RequestClientIPFilter) Filter(ctx *fasthttp.RequestCtx) {
 
-	clientIP := ctx.RemoteIP().String()
+	clientIP := common.GetClientIP(ctx).String()
 
 	if global.Env().IsDebug {
 		log.Trace("client_ip:", clientIP)
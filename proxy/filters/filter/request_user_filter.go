@@ -31,6 +31,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type RequestUserFilter struct {
@@ -67,15 +68,19 @@ func NewRequestUserFilter(c *config.Config) (pipeline.Filter, error) {
 }
 
 func (filter *RequestUserFilter) Filter(ctx *fasthttp.RequestCtx) {
-	exists, user, _ := ctx.Request.ParseBasicAuth()
-	if !exists {
-		if global.Env().IsDebug {
-			log.Tracef("user not exist")
+	//prefer the user resolved by the authentication filters
+	userStr := common.GetUserName(ctx)
+	if userStr == "" {
+		exists, user, _ := ctx.Request.ParseBasicAuth()
+		if !exists {
+			if global.Env().IsDebug {
+				log.Tracef("user not exist")
+			}
+			return
 		}
-		return
+		userStr = string(user)
 	}
 
-	userStr := string(user)
 	valid, hasRule := CheckExcludeStringRules(userStr, filter.Exclude, ctx)
 	if hasRule && !valid {
 		filter.genericFilter.Filter(ctx)
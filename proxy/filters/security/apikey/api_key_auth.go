@@ -0,0 +1,161 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package apikey
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/rate"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+type APIKeyAuth struct {
+	CacheTTL         string   `config:"cache_ttl"`
+	DefaultRoles     []string `config:"default_roles"`
+	PassThroughOther bool     `config:"pass_through_other"`
+
+	cacheTTL time.Duration
+}
+
+func (filter *APIKeyAuth) Name() string {
+	return "api_key_auth"
+}
+
+func (filter *APIKeyAuth) Filter(ctx *fasthttp.RequestCtx) {
+
+	exists, apiID, apiKey := ctx.ParseAPIKey()
+	if !exists {
+		if filter.PassThroughOther && ctx.Request.ParseAuthorization() != "" {
+			return
+		}
+		filter.deny(ctx, fasthttp.StatusUnauthorized, "missing api key")
+		return
+	}
+
+	id := string(apiID)
+	key, err := common.GetAPIKey(id, filter.cacheTTL)
+	if err != nil {
+		log.Errorf("failed to load api key [%v]: %v", id, err)
+		filter.deny(ctx, fasthttp.StatusInternalServerError, "failed to load api key")
+		return
+	}
+
+	if key == nil || !key.Verify(string(apiKey)) {
+		filter.deny(ctx, fasthttp.StatusUnauthorized, fmt.Sprintf("invalid api key [%v]", id))
+		return
+	}
+
+	if key.Invalidated {
+		filter.deny(ctx, fasthttp.StatusUnauthorized, fmt.Sprintf("api key [%v] has been invalidated", id))
+		return
+	}
+
+	if key.IsExpired() {
+		filter.deny(ctx, fasthttp.StatusUnauthorized, fmt.Sprintf("api key [%v] has expired", id))
+		return
+	}
+
+	if key.RateLimit != nil && !filter.allow(key, ctx) {
+		filter.deny(ctx, fasthttp.StatusTooManyRequests, fmt.Sprintf("api key [%v] reached the rate limit", id))
+		return
+	}
+
+	roles := key.Roles
+	if len(roles) == 0 {
+		roles = filter.DefaultRoles
+	}
+
+	if global.Env().IsDebug {
+		log.Debugf("api key [%v] of %v success authenticated, roles: %v", id, key.Owner, roles)
+	}
+
+	username := key.Owner
+	if username == "" {
+		username = key.Name
+	}
+	ctx.Set(common.UserIDKey, id)
+	ctx.Set(common.UserNameKey, username)
+	ctx.Set(common.UserRolesKey, roles)
+}
+
+func (filter *APIKeyAuth) allow(key *common.APIKey, ctx *fasthttp.RequestCtx) bool {
+	limit := key.RateLimit
+	interval := util.GetDurationOrDefault(limit.Interval, time.Second)
+	//the limits are part of the limiter key, so that updated limits take effect
+	token := fmt.Sprintf("%v_%v_%v_%v", key.ID, limit.MaxRequests, limit.MaxBytes, interval)
+	if limit.MaxRequests > 0 && !rate.GetRateLimiter("api_key_limit_requests", token, limit.MaxRequests, limit.BurstRequests, interval).AllowN(time.Now(), 1) {
+		return false
+	}
+	if limit.MaxBytes > 0 && !rate.GetRateLimiter("api_key_limit_bytes", token, limit.MaxBytes, limit.BurstBytes, interval).AllowN(time.Now(), ctx.Request.GetRequestLength()) {
+		return false
+	}
+	return true
+}
+
+func (filter *APIKeyAuth) deny(ctx *fasthttp.RequestCtx, status int, reason string) {
+	if global.Env().IsDebug {
+		log.Debugf("api key authentication failed: %v", reason)
+	}
+	if status == fasthttp.StatusUnauthorized {
+		ctx.Response.Header.Set("WWW-Authenticate", "ApiKey")
+	}
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(status)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": reason,
+		},
+		"status": status,
+	}))
+	ctx.Finished()
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("api_key_auth", NewAPIKeyAuth, &APIKeyAuth{})
+}
+
+func NewAPIKeyAuth(c *config.Config) (pipeline.Filter, error) {
+
+	runner := APIKeyAuth{
+		CacheTTL: "60s",
+	}
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	runner.cacheTTL = util.GetDurationOrDefault(runner.CacheTTL, time.Minute)
+
+	return &runner, nil
+}
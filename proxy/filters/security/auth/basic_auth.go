@@ -30,6 +30,7 @@ import (
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 	"strings"
 )
 
@@ -82,7 +83,8 @@ func BasicAuth1(h fasthttp.RequestHandler, requiredUser, requiredPassword string
 }
 
 type BasicAuth struct {
-	ValidUsers map[string]string `config:"valid_users"`
+	ValidUsers map[string]string   `config:"valid_users"`
+	UserRoles  map[string][]string `config:"user_roles"`
 }
 
 func (filter *BasicAuth) Name() string {
@@ -96,6 +98,11 @@ func (filter *BasicAuth) Filter(ctx *fasthttp.RequestCtx) {
 		p, ok := filter.ValidUsers[util.UnsafeBytesToString(user)]
 		if ok {
 			if util.UnsafeBytesToString(pass) == p {
+				userName := string(user)
+				ctx.Set(common.UserNameKey, userName)
+				if roles, ok := filter.UserRoles[userName]; ok {
+					ctx.Set(common.UserRolesKey, roles)
+				}
 				return
 			}
 		}
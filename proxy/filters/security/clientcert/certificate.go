@@ -0,0 +1,73 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package clientcert
+
+import (
+	"crypto/x509"
+)
+
+var certificateFields = map[string]func(cert *x509.Certificate) []string{
+	"subject.dn": func(cert *x509.Certificate) []string { return []string{cert.Subject.String()} },
+	"subject.cn": func(cert *x509.Certificate) []string { return nonEmpty(cert.Subject.CommonName) },
+	"subject.o":  func(cert *x509.Certificate) []string { return cert.Subject.Organization },
+	"subject.ou": func(cert *x509.Certificate) []string { return cert.Subject.OrganizationalUnit },
+	"san.email":  func(cert *x509.Certificate) []string { return cert.EmailAddresses },
+	"san.dns":    func(cert *x509.Certificate) []string { return cert.DNSNames },
+	"san.uri": func(cert *x509.Certificate) []string {
+		var result []string
+		for _, v := range cert.URIs {
+			result = append(result, v.String())
+		}
+		return result
+	},
+	"san.ip": func(cert *x509.Certificate) []string {
+		var result []string
+		for _, v := range cert.IPAddresses {
+			result = append(result, v.String())
+		}
+		return result
+	},
+}
+
+// GetCertificateFields extracts the subject and subject alternative name fields of the certificate,
+// keyed by the field names, eg: subject.cn, san.dns
+func GetCertificateFields(cert *x509.Certificate) map[string][]string {
+	fields := map[string][]string{}
+	for k, f := range certificateFields {
+		if values := f(cert); len(values) > 0 {
+			fields[k] = values
+		}
+	}
+	return fields
+}
+
+func nonEmpty(str string) []string {
+	if str == "" {
+		return nil
+	}
+	return []string{str}
+}
@@ -0,0 +1,87 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCertificateFields(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	spiffe, _ := url.Parse("spiffe://example.com/ns/prod/sa/indexer")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "indexer",
+			Organization:       []string{"INFINI"},
+			OrganizationalUnit: []string{"search", "ops"},
+		},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		DNSNames:       []string{"indexer.svc"},
+		EmailAddresses: []string{"indexer@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	fields := GetCertificateFields(cert)
+	assert.Equal(t, []string{"indexer"}, fields["subject.cn"])
+	assert.Equal(t, []string{"CN=indexer,OU=ops+OU=search,O=INFINI"}, fields["subject.dn"])
+	assert.Equal(t, []string{"INFINI"}, fields["subject.o"])
+	assert.ElementsMatch(t, []string{"search", "ops"}, fields["subject.ou"])
+	assert.Equal(t, []string{"indexer.svc"}, fields["san.dns"])
+	assert.Equal(t, []string{"indexer@example.com"}, fields["san.email"])
+	assert.Equal(t, []string{"10.0.0.1"}, fields["san.ip"])
+	assert.Equal(t, []string{"spiffe://example.com/ns/prod/sa/indexer"}, fields["san.uri"])
+
+	tmpl.Subject = pkix.Name{}
+	tmpl.URIs = nil
+	der, _ = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	cert, _ = x509.ParseCertificate(der)
+	fields = GetCertificateFields(cert)
+	_, ok := fields["subject.cn"]
+	assert.False(t, ok)
+	_, ok = fields["san.uri"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,155 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package clientcert
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+type ClientCertAuth struct {
+	UsernameField string   `config:"username_field"`
+	CAFile        string   `config:"ca_file"`
+	DefaultRoles  []string `config:"default_roles"`
+
+	roots *x509.CertPool
+}
+
+func (filter *ClientCertAuth) Name() string {
+	return "client_cert_auth"
+}
+
+func (filter *ClientCertAuth) Filter(ctx *fasthttp.RequestCtx) {
+
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		filter.unauthorized(ctx, "client certificate is required")
+		return
+	}
+
+	cert := state.PeerCertificates[0]
+
+	if filter.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, v := range state.PeerCertificates[1:] {
+			intermediates.AddCert(v)
+		}
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         filter.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			filter.unauthorized(ctx, fmt.Sprintf("invalid client certificate: %v", err))
+			return
+		}
+	} else if len(state.VerifiedChains) == 0 {
+		//without ca_file, only the certificates verified by the tls listener are trusted,
+		//the listener with `client_auth: request` or `require_any` doesn't verify them
+		filter.unauthorized(ctx, "client certificate is not verified, set `ca_file` or verify it by the listener")
+		return
+	}
+
+	fields := GetCertificateFields(cert)
+	username := firstOrEmpty(fields[filter.UsernameField])
+	if username == "" {
+		filter.unauthorized(ctx, fmt.Sprintf("field [%v] is missing in the client certificate", filter.UsernameField))
+		return
+	}
+
+	roles := common.GetMappedRoles(username, fields["subject.ou"], fields)
+	if len(roles) == 0 {
+		roles = filter.DefaultRoles
+	}
+
+	if global.Env().IsDebug {
+		log.Debugf("client %v success authenticated by certificate, roles: %v", fields["subject.dn"], roles)
+	}
+
+	ctx.Set(common.UserIDKey, firstOrEmpty(fields["subject.dn"]))
+	ctx.Set(common.UserNameKey, username)
+	ctx.Set(common.UserRolesKey, roles)
+}
+
+func (filter *ClientCertAuth) unauthorized(ctx *fasthttp.RequestCtx, reason string) {
+	if global.Env().IsDebug {
+		log.Debugf("client certificate authentication failed: %v", reason)
+	}
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": reason,
+		},
+		"status": fasthttp.StatusUnauthorized,
+	}))
+	ctx.Finished()
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("client_cert_auth", NewClientCertAuth, &ClientCertAuth{})
+}
+
+func NewClientCertAuth(c *config.Config) (pipeline.Filter, error) {
+
+	runner := ClientCertAuth{
+		UsernameField: "subject.cn",
+	}
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	if _, ok := certificateFields[runner.UsernameField]; !ok {
+		return nil, fmt.Errorf("invalid username_field [%v]", runner.UsernameField)
+	}
+
+	if runner.CAFile != "" {
+		pool, err := common.LoadCertPool(runner.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		runner.roots = pool
+	}
+
+	return &runner, nil
+}
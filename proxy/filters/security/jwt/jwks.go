@@ -0,0 +1,339 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// KeySet resolves the verification key of a token
+type KeySet interface {
+	GetKey(kid, algorithm string) (interface{}, error)
+}
+
+// SecretKeySet holds the shared secret of HS256 tokens
+type SecretKeySet struct {
+	Secret []byte
+}
+
+func (set *SecretKeySet) GetKey(kid, algorithm string) (interface{}, error) {
+	if algorithm != "HS256" || len(set.Secret) == 0 {
+		return nil, fmt.Errorf("no key for algorithm [%v]", algorithm)
+	}
+	return set.Secret, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// ParseJWKS parses the keys of a JSON Web Key Set, unsupported keys are skipped
+func ParseJWKS(data []byte) ([]jwk, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for _, v := range set.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		key, alg, err := v.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key [%v]: %v", v.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		if v.Alg != "" {
+			alg = v.Alg
+		}
+		keys = append(keys, jwk{kid: v.Kid, alg: alg, key: key})
+	}
+	return keys, nil
+}
+
+func (v *jsonWebKey) parse() (interface{}, string, error) {
+	switch v.Kty {
+	case "RSA":
+		n, err := decodeBigInt(v.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeBigInt(v.E)
+		if err != nil {
+			return nil, "", err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, "RS256", nil
+	case "EC":
+		if v.Crv != "P-256" {
+			return nil, "", nil
+		}
+		x, err := decodeBigInt(v.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeBigInt(v.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, "", errors.New("point is not on curve")
+		}
+		return pub, "ES256", nil
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(v.K)
+		if err != nil {
+			return nil, "", err
+		}
+		return k, "HS256", nil
+	}
+	return nil, "", nil
+}
+
+func decodeBigInt(str string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSKeySet holds the keys loaded from a local file or a remote url, the keys are reloaded periodically in the
+// background, and on demand when an unknown key id shows up, to follow the key rotation
+type JWKSKeySet struct {
+	File            string
+	URL             string
+	RefreshInterval time.Duration
+	MinRefreshDelay time.Duration
+	Client          *http.Client
+
+	lock        sync.RWMutex
+	keys        []jwk
+	lastRefresh time.Time
+
+	//serializes the reloads, so that the concurrent misses fetch the keys only once
+	refreshLock sync.Mutex
+}
+
+var jwksKeySets = sync.Map{}
+
+// getJWKSKeySet returns the key set of the file or the url, which is loaded and started once, and shared by the
+// filters, so the reloads are not piled up when the flows are reloaded
+func getJWKSKeySet(file, url string, refreshInterval time.Duration) *JWKSKeySet {
+	key := fmt.Sprintf("%v|%v|%v", file, url, refreshInterval)
+	if v, ok := jwksKeySets.Load(key); ok {
+		return v.(*JWKSKeySet)
+	}
+
+	set := &JWKSKeySet{
+		File:            file,
+		URL:             url,
+		RefreshInterval: refreshInterval,
+		MinRefreshDelay: time.Minute,
+	}
+	v, loaded := jwksKeySets.LoadOrStore(key, set)
+	if loaded {
+		return v.(*JWKSKeySet)
+	}
+	if err := set.Load(); err != nil {
+		//the key server may be unavailable for now, the keys will be loaded on demand
+		log.Errorf("failed to load jwks: %v", err)
+	}
+	set.Start()
+	return set
+}
+
+// Start reloads the keys every refresh interval in the background, the requests are served with the loaded keys
+func (set *JWKSKeySet) Start() {
+	if set.RefreshInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(set.RefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			set.lock.RLock()
+			lastRefresh := set.lastRefresh
+			set.lock.RUnlock()
+
+			//the keys may have been reloaded on demand
+			if time.Since(lastRefresh) < set.RefreshInterval {
+				continue
+			}
+			if err := set.refresh(lastRefresh); err != nil {
+				log.Warnf("failed to reload jwks: %v", err)
+			}
+		}
+	}()
+}
+
+func (set *JWKSKeySet) Load() error {
+	//record the attempt even if it failed, so that an unavailable key server is not hammered
+	defer func() {
+		set.lock.Lock()
+		set.lastRefresh = time.Now()
+		set.lock.Unlock()
+	}()
+
+	var data []byte
+	var err error
+	if set.URL != "" {
+		data, err = set.fetch()
+	} else {
+		data, err = os.ReadFile(set.File)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	set.lock.Lock()
+	set.keys = keys
+	set.lock.Unlock()
+	return nil
+}
+
+func (set *JWKSKeySet) fetch() ([]byte, error) {
+	client := set.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Get(set.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks from [%v], status: %v", set.URL, res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (set *JWKSKeySet) lookup(kid, algorithm string) interface{} {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	for _, v := range set.keys {
+		if (kid == "" || v.kid == kid) && v.alg == algorithm {
+			return v.key
+		}
+	}
+	return nil
+}
+
+// refresh reloads the keys unless they were reloaded after the given time,
+// the callers waiting for the lock use the keys loaded by the first one
+func (set *JWKSKeySet) refresh(since time.Time) error {
+	set.refreshLock.Lock()
+	defer set.refreshLock.Unlock()
+
+	set.lock.RLock()
+	refreshed := set.lastRefresh.After(since)
+	set.lock.RUnlock()
+	if refreshed {
+		return nil
+	}
+	return set.Load()
+}
+
+// GetKey returns the loaded key, the keys are only reloaded in the request path for an unknown key id, the requests
+// of the known keys are not blocked by the reloads
+func (set *JWKSKeySet) GetKey(kid, algorithm string) (interface{}, error) {
+	key := set.lookup(kid, algorithm)
+	if key == nil {
+		set.lock.RLock()
+		lastRefresh := set.lastRefresh
+		set.lock.RUnlock()
+
+		//the keys may have been rotated
+		if time.Since(lastRefresh) > set.MinRefreshDelay {
+			if err := set.refresh(lastRefresh); err == nil {
+				key = set.lookup(kid, algorithm)
+			}
+		}
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("no key found for kid [%v] and algorithm [%v]", kid, algorithm)
+	}
+	return key, nil
+}
+
+// MultiKeySet tries the key sets in order
+type MultiKeySet []KeySet
+
+func (sets MultiKeySet) GetKey(kid, algorithm string) (interface{}, error) {
+	var err error
+	for _, v := range sets {
+		var key interface{}
+		key, err = v.GetKey(kid, algorithm)
+		if err == nil {
+			return key, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no key configured")
+	}
+	return nil, err
+}
@@ -0,0 +1,218 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package jwt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+type JWTAuth struct {
+	Algorithms          []string `config:"algorithms"`
+	Secret              string   `config:"secret"`
+	JWKSFile            string   `config:"jwks_file"`
+	JWKSURL             string   `config:"jwks_url"`
+	JWKSRefreshInterval string   `config:"jwks_refresh_interval"`
+	Issuer              []string `config:"issuer"`
+	Audience            []string `config:"audience"`
+	Leeway              string   `config:"leeway"`
+	UsernameClaim       string   `config:"username_claim"`
+	GroupsClaim         string   `config:"groups_claim"`
+	RolesClaim          string   `config:"roles_claim"`
+	Claims              []string `config:"claims"`
+	DefaultRoles        []string `config:"default_roles"`
+	BypassAPIKey        bool     `config:"bypass_api_key"`
+	AllowMissingExp     bool     `config:"allow_missing_exp"`
+
+	keys       KeySet
+	validation Validation
+}
+
+func (filter *JWTAuth) Name() string {
+	return "jwt_auth"
+}
+
+var bearerPrefix = []byte("Bearer ")
+
+func (filter *JWTAuth) Filter(ctx *fasthttp.RequestCtx) {
+
+	if filter.BypassAPIKey && ctx.Request.ParseAuthorization() == "ApiKey" {
+		return
+	}
+
+	header := ctx.Request.Header.Peek("Authorization")
+	if len(header) <= len(bearerPrefix) || !bytes.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		filter.unauthorized(ctx, "", errors.New("bearer token is missing"))
+		return
+	}
+
+	token, err := Parse(strings.TrimSpace(string(header[len(bearerPrefix):])), filter.keys, filter.Algorithms)
+	if err == nil {
+		err = token.Validate(filter.validation)
+	}
+	if err != nil {
+		filter.unauthorized(ctx, "invalid_token", err)
+		return
+	}
+
+	username := token.GetString(filter.UsernameClaim)
+	if username == "" {
+		filter.unauthorized(ctx, "invalid_token", fmt.Errorf("claim [%v] is missing", filter.UsernameClaim))
+		return
+	}
+
+	var groups []string
+	if filter.GroupsClaim != "" {
+		groups = token.GetStrings(filter.GroupsClaim)
+	}
+
+	attributes := map[string][]string{}
+	for _, claim := range filter.Claims {
+		values := token.GetStrings(claim)
+		if len(values) == 0 {
+			if v := token.GetString(claim); v != "" {
+				values = []string{v}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		attributes[claim] = values
+		//expose the claims for templating, eg: {{user.department}}
+		if len(values) == 1 {
+			ctx.Set("user_"+claim, values[0])
+		} else {
+			ctx.Set("user_"+claim, values)
+		}
+	}
+
+	var roles []string
+	if filter.RolesClaim != "" {
+		roles = token.GetStrings(filter.RolesClaim)
+	}
+	roles = append(roles, common.GetMappedRoles(username, groups, attributes)...)
+	if len(roles) == 0 {
+		roles = filter.DefaultRoles
+	}
+
+	if global.Env().IsDebug {
+		log.Debugf("user %s authenticated by jwt, groups: %v, roles: %v", username, groups, roles)
+	}
+
+	ctx.Set(common.UserIDKey, token.GetString("sub"))
+	ctx.Set(common.UserNameKey, username)
+	ctx.Set(common.UserRolesKey, roles)
+	ctx.Set("user_groups", groups)
+}
+
+func (filter *JWTAuth) unauthorized(ctx *fasthttp.RequestCtx, code string, err error) {
+	if global.Env().IsDebug {
+		log.Debugf("jwt authentication failed: %v", err)
+	}
+
+	challenge := "Bearer"
+	if code != "" {
+		challenge = fmt.Sprintf("Bearer error=\"%v\", error_description=\"%v\"", code, strings.ReplaceAll(err.Error(), "\"", "'"))
+	}
+	ctx.Response.Header.Set("WWW-Authenticate", challenge)
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": fmt.Sprintf("unable to authenticate with the bearer token: %v", err),
+		},
+		"status": fasthttp.StatusUnauthorized,
+	}))
+	ctx.Finished()
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("jwt_auth", NewJWTAuth, &JWTAuth{})
+}
+
+func NewJWTAuth(c *config.Config) (pipeline.Filter, error) {
+
+	runner := JWTAuth{
+		JWKSRefreshInterval: "1h",
+		Leeway:              "30s",
+		UsernameClaim:       "sub",
+		GroupsClaim:         "groups",
+	}
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	var keys MultiKeySet
+	if runner.Secret != "" {
+		keys = append(keys, &SecretKeySet{Secret: []byte(runner.Secret)})
+	}
+
+	if runner.JWKSFile != "" || runner.JWKSURL != "" {
+		//keys of a local file are not rotated remotely, only refreshed on demand
+		var refreshInterval time.Duration
+		if runner.JWKSURL != "" {
+			refreshInterval = util.GetDurationOrDefault(runner.JWKSRefreshInterval, time.Hour)
+		}
+		keys = append(keys, getJWKSKeySet(runner.JWKSFile, runner.JWKSURL, refreshInterval))
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("either secret, jwks_file or jwks_url must be configured")
+	}
+	runner.keys = keys
+
+	if len(runner.Algorithms) == 0 {
+		if runner.Secret != "" {
+			runner.Algorithms = append(runner.Algorithms, "HS256")
+		}
+		if runner.JWKSFile != "" || runner.JWKSURL != "" {
+			runner.Algorithms = append(runner.Algorithms, "RS256", "ES256")
+		}
+	}
+
+	runner.validation = Validation{
+		Issuers:   runner.Issuer,
+		Audiences: runner.Audience,
+		Leeway:    util.GetDurationOrDefault(runner.Leeway, 30*time.Second),
+
+		AllowMissingExp: runner.AllowMissingExp,
+	}
+
+	return &runner, nil
+}
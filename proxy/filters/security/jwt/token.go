@@ -0,0 +1,251 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var errMalformedToken = errors.New("malformed token")
+var errInvalidSignature = errors.New("invalid signature")
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+type Token struct {
+	Header Header
+	Claims map[string]interface{}
+}
+
+// Validation holds the expectations on the registered claims
+type Validation struct {
+	Issuers   []string
+	Audiences []string
+	Leeway    time.Duration
+	//accept the tokens without the exp claim, which never expire
+	AllowMissingExp bool
+	Now             func() time.Time
+}
+
+// Parse decodes the token and verifies its signature with the key resolved by the key set
+func Parse(token string, keys KeySet, algorithms []string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	t := &Token{}
+	if err := json.Unmarshal(headerBytes, &t.Header); err != nil {
+		return nil, errMalformedToken
+	}
+
+	allowed := false
+	for _, v := range algorithms {
+		if v == t.Header.Algorithm {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("algorithm [%v] is not allowed", t.Header.Algorithm)
+	}
+
+	key, err := keys.GetKey(t.Header.KeyID, t.Header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(t.Header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(claimsBytes)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&t.Claims); err != nil {
+		return nil, errMalformedToken
+	}
+
+	return t, nil
+}
+
+func verify(algorithm string, key interface{}, signed, signature []byte) error {
+	hash := sha256.Sum256(signed)
+	switch algorithm {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("invalid key for HS256")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errInvalidSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("invalid key for RS256")
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature) != nil {
+			return errInvalidSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("invalid key for ES256")
+		}
+		if len(signature) != 64 {
+			return errInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return errInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm [%v]", algorithm)
+	}
+	return nil
+}
+
+// Validate checks the exp, nbf, iss and aud claims
+func (t *Token) Validate(v Validation) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	exp, ok := t.GetTime("exp")
+	if !ok && !v.AllowMissingExp {
+		return errors.New("token has no expiration")
+	}
+	if ok && now.After(exp.Add(v.Leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if nbf, ok := t.GetTime("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+
+	if len(v.Issuers) > 0 {
+		iss := t.GetString("iss")
+		valid := false
+		for _, x := range v.Issuers {
+			if x == iss {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid issuer [%v]", iss)
+		}
+	}
+
+	if len(v.Audiences) > 0 {
+		valid := false
+		for _, aud := range t.GetStrings("aud") {
+			for _, x := range v.Audiences {
+				if x == aud {
+					valid = true
+				}
+			}
+		}
+		if !valid {
+			return errors.New("invalid audience")
+		}
+	}
+
+	return nil
+}
+
+func (t *Token) GetTime(claim string) (time.Time, bool) {
+	v, ok := t.Claims[claim].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := v.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func (t *Token) GetString(claim string) string {
+	switch v := t.Claims[claim].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// GetStrings returns the claim as a list, a single string claim is also accepted
+func (t *Token) GetStrings(claim string) []string {
+	switch v := t.Claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var result []string
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		s, err := rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, hash[:])
+		assert.NoError(t, err)
+		signature = s
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), hash[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encode(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestParseHS256(t *testing.T) {
+	secret := []byte("secret")
+	token := sign(t, "HS256", "", secret, map[string]interface{}{"sub": "medcl", "groups": []string{"admin", "dev"}})
+
+	parsed, err := Parse(token, &SecretKeySet{Secret: secret}, []string{"HS256"})
+	assert.NoError(t, err)
+	assert.Equal(t, "medcl", parsed.GetString("sub"))
+	assert.Equal(t, []string{"admin", "dev"}, parsed.GetStrings("groups"))
+
+	_, err = Parse(token, &SecretKeySet{Secret: []byte("wrong")}, []string{"HS256"})
+	assert.Error(t, err)
+
+	//algorithm not allowed
+	_, err = Parse(token, &SecretKeySet{Secret: secret}, []string{"RS256"})
+	assert.Error(t, err)
+
+	_, err = Parse("a.b", &SecretKeySet{Secret: secret}, []string{"HS256"})
+	assert.Error(t, err)
+}
+
+func TestJWKSKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa1","use":"sig","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec1","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"%s"}
+	]}`, encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))), encode(ecKey.X), encode(ecKey.Y),
+		encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))))
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(file, []byte(jwks), 0644))
+
+	set := &JWKSKeySet{File: file, MinRefreshDelay: time.Hour}
+	assert.NoError(t, set.Load())
+	assert.Equal(t, 2, len(set.keys))
+
+	algorithms := []string{"RS256", "ES256"}
+	token, err := Parse(sign(t, "RS256", "rsa1", rsaKey, map[string]interface{}{"sub": "a"}), set, algorithms)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", token.GetString("sub"))
+
+	token, err = Parse(sign(t, "ES256", "ec1", ecKey, map[string]interface{}{"sub": "b"}), set, algorithms)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", token.GetString("sub"))
+
+	//signed by another key
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = Parse(sign(t, "ES256", "ec1", otherKey, map[string]interface{}{"sub": "b"}), set, algorithms)
+	assert.Error(t, err)
+
+	//unknown key id
+	_, err = Parse(sign(t, "RS256", "rsa2", rsaKey, map[string]interface{}{"sub": "a"}), set, algorithms)
+	assert.Error(t, err)
+
+	//rotated keys are picked up once the refresh delay passed
+	rotated := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"rsa2","n":"%s","e":"%s"}]}`, encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))))
+	assert.NoError(t, os.WriteFile(file, []byte(rotated), 0644))
+	set.MinRefreshDelay = 0
+	_, err = Parse(sign(t, "RS256", "rsa2", rsaKey, map[string]interface{}{"sub": "a"}), set, algorithms)
+	assert.NoError(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("secret")
+	parse := func(claims map[string]interface{}) *Token {
+		token, err := Parse(sign(t, "HS256", "", secret, claims), &SecretKeySet{Secret: secret}, []string{"HS256"})
+		assert.NoError(t, err)
+		return token
+	}
+	v := Validation{
+		Issuers:   []string{"https://idp.example.com"},
+		Audiences: []string{"gateway"},
+		Leeway:    30 * time.Second,
+		Now:       func() time.Time { return now },
+	}
+
+	valid := map[string]interface{}{"iss": "https://idp.example.com", "aud": []string{"gateway", "other"}, "exp": now.Unix() + 60, "nbf": now.Unix() - 60}
+	assert.NoError(t, parse(valid).Validate(v))
+
+	//within leeway
+	assert.NoError(t, parse(map[string]interface{}{"iss": "https://idp.example.com", "aud": "gateway", "exp": now.Unix() - 10}).Validate(v))
+
+	assert.Error(t, parse(map[string]interface{}{"iss": "https://idp.example.com", "aud": "gateway", "exp": now.Unix() - 60}).Validate(v))
+	assert.Error(t, parse(map[string]interface{}{"iss": "https://idp.example.com", "aud": "gateway", "nbf": now.Unix() + 60}).Validate(v))
+	assert.Error(t, parse(map[string]interface{}{"iss": "https://other.example.com", "aud": "gateway"}).Validate(v))
+	assert.Error(t, parse(map[string]interface{}{"iss": "https://idp.example.com", "aud": "other"}).Validate(v))
+
+	//tokens without exp are rejected unless allowed
+	noExp := map[string]interface{}{"iss": "https://idp.example.com", "aud": "gateway"}
+	assert.Error(t, parse(noExp).Validate(v))
+	v.AllowMissingExp = true
+	assert.NoError(t, parse(noExp).Validate(v))
+}
+
+func TestJWKSConcurrentRefresh(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"rsa1","n":"%s","e":"%s"}]}`, encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E))))
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(jwks))
+	}))
+	defer server.Close()
+
+	set := &JWKSKeySet{URL: server.URL, MinRefreshDelay: time.Hour}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := set.GetKey("rsa1", "RS256")
+			assert.NoError(t, err)
+			assert.NotNil(t, key)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	//unknown keys don't trigger more fetches within the refresh delay
+	_, err = set.GetKey("rsa2", "RS256")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKSBackgroundRefresh(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks := func(kid string) []byte {
+		return []byte(fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"%s","n":"%s","e":"%s"}]}`, kid, encode(rsaKey.N), encode(big.NewInt(int64(rsaKey.E)))))
+	}
+
+	var fetches int32
+	kid := atomic.Value{}
+	kid.Store("rsa1")
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-block
+		}
+		w.Write(jwks(kid.Load().(string)))
+	}))
+	defer server.Close()
+	defer close(block)
+
+	set := &JWKSKeySet{URL: server.URL, RefreshInterval: 50 * time.Millisecond, MinRefreshDelay: time.Hour}
+	assert.NoError(t, set.Load())
+	set.Start()
+
+	//the known keys are served while the background reload is blocked
+	kid.Store("rsa2")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	key, err := set.GetKey("rsa1", "RS256")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+}
@@ -57,6 +57,7 @@ type LDAPFilter struct {
 	GroupAttribute string   `config:"group_attribute"`
 	Attributes     []string `config:"attributes"`
 	RequireGroup   bool     `config:"require_group"`
+	DefaultRoles   []string `config:"default_roles"`
 	MaxCacheItems   int     `config:"max_cache_items"`
 	CacheTTL   string     `config:"cache_ttl"`
 
@@ -106,9 +107,16 @@ func (filter *LDAPFilter) Filter(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
-	ctx.Set("user_id", user.GetID())
-	ctx.Set("user_name", user.GetUserName())
-	ctx.Set("user_roles", common.GetLDAPGroupsMappingRoles(user.GetGroups()))
+	ctx.Set(common.UserIDKey, user.GetID())
+	ctx.Set(common.UserNameKey, user.GetUserName())
+	roles := common.GetMappedRoles(user.GetUserName(), user.GetGroups(), user.GetExtensions())
+	if len(roles) == 0 {
+		roles = filter.DefaultRoles
+	}
+	if global.Env().IsDebug {
+		log.Debugf("user %s mapped to roles: %v", user.GetUserName(), roles)
+	}
+	ctx.Set(common.UserRolesKey, roles)
 
 }
 
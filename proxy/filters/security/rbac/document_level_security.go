@@ -0,0 +1,227 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// DocumentLevelSecurity injects the role queries into search requests,
+// so that users only see the documents permitted by their roles
+type DocumentLevelSecurity struct {
+	Status int `config:"status"`
+}
+
+func (filter *DocumentLevelSecurity) Name() string {
+	return "document_level_security"
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("document_level_security", NewDocumentLevelSecurity, &DocumentLevelSecurity{})
+}
+
+func NewDocumentLevelSecurity(c *config.Config) (pipeline.Filter, error) {
+
+	runner := DocumentLevelSecurity{
+		Status: 400,
+	}
+
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	return &runner, nil
+}
+
+var securedSearchAPIs = map[string]bool{
+	"_search":       true,
+	"_count":        true,
+	"_async_search": true,
+}
+
+func (filter *DocumentLevelSecurity) Filter(ctx *fasthttp.RequestCtx) {
+
+	method := string(ctx.Method())
+	path := string(ctx.PhantomURI().Path())
+
+	req := ParseElasticsearchRequest(method, path, nil)
+	if req.Cluster {
+		return
+	}
+
+	roles := common.GetRoles(common.GetUserRoles(ctx))
+	build := func(indices []string) []byte {
+		return BuildSecurityQuery(GetIndexQueries(ctx, roles, indices))
+	}
+
+	restricted := func() bool {
+		indices := ParseElasticsearchRequest(method, path, ctx.Request.Body()).GetIndices()
+		if len(indices) == 0 {
+			indices = []string{"_all"}
+		}
+		return build(indices) != nil
+	}
+
+	//the search templates and the knn search api can't be rewritten, they are rejected if any document is restricted
+	if err := CheckSecuredSearchAPI(req.API, path); err != nil {
+		if restricted() {
+			filter.error(ctx, err)
+		}
+		return
+	}
+
+	//the documents read by id are not filtered, they are rejected if any document is restricted
+	if err := CheckSecuredDocumentAPI(method, req.API); err != nil {
+		if restricted() {
+			filter.forbidden(ctx, err)
+		}
+		return
+	}
+
+	if req.API == "_msearch" {
+		body, err := RewriteMsearchBody(ctx.Request.Body(), req.GetIndices(), func(indices []string, search []byte) ([]byte, error) {
+			security := build(indices)
+			if security == nil {
+				return search, nil
+			}
+			return ApplySecurityQuery(search, security, "")
+		})
+		if err != nil {
+			filter.error(ctx, err)
+			return
+		}
+		ctx.Request.SetRawBody(body)
+		return
+	}
+
+	//scroll continuation and async search fetch have no indices, they inherit the rewritten query
+	if !securedSearchAPIs[req.API] || len(req.Indices) == 0 {
+		return
+	}
+
+	security := build(req.GetIndices())
+	if security == nil {
+		return
+	}
+
+	var queryString string
+	clonedURI := ctx.Request.CloneURI()
+	defer fasthttp.ReleaseURI(clonedURI)
+	args := clonedURI.QueryArgs()
+	if args.Has("q") {
+		queryString = string(args.Peek("q"))
+		args.Del("q")
+		clonedURI.SetQueryString(args.String())
+		ctx.Request.SetURI(clonedURI)
+	}
+
+	body, err := ApplySecurityQuery(ctx.Request.Body(), security, queryString)
+	if err != nil {
+		filter.error(ctx, err)
+		return
+	}
+
+	if global.Env().IsDebug {
+		log.Tracef("document level security applied to [%v]: %v", path, string(body))
+	}
+
+	ctx.Request.SetRawBody(body)
+}
+
+// GetIndexQueries collects the rendered role queries which grant read access to each index expression
+func GetIndexQueries(ctx *fasthttp.RequestCtx, roles []common.Role, indices []string) []IndexQueries {
+	lookup := func(tag string) string {
+		if !strings.HasPrefix(tag, "user.") {
+			return ""
+		}
+		v := ctx.Get("user_" + strings.TrimPrefix(tag, "user."))
+		if x, ok := v.([]string); ok {
+			return strings.Join(x, ",")
+		}
+		if v == nil {
+			return ""
+		}
+		return util.ToString(v)
+	}
+
+	var items []IndexQueries
+	for _, index := range indices {
+		item := IndexQueries{Index: index}
+		for _, role := range roles {
+			for _, perm := range role.GetIndexPermissions(index) {
+				if !perm.HasPrivilege("read") {
+					continue
+				}
+				if perm.Query == "" {
+					item.Unrestricted = true
+					continue
+				}
+				item.Queries = append(item.Queries, RenderQuery(perm.Query, lookup))
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (filter *DocumentLevelSecurity) forbidden(ctx *fasthttp.RequestCtx, err error) {
+	ctx.SetDestination("filtered")
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(403)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": err.Error(),
+		},
+		"status": 403,
+	}))
+	ctx.Finished()
+}
+
+func (filter *DocumentLevelSecurity) error(ctx *fasthttp.RequestCtx, err error) {
+	log.Debug("failed to apply document level security, ", err)
+	ctx.SetDestination("filtered")
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(filter.Status)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "parse_exception",
+			"reason": fmt.Sprintf("failed to apply document level security: %v", err),
+		},
+		"status": filter.Status,
+	}))
+	ctx.Finished()
+}
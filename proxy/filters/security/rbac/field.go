@@ -0,0 +1,576 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"infini.sh/gateway/common"
+)
+
+// FieldRules describes which fields of the documents are visible to the user,
+// field names are dotted paths and support the `*` wildcard
+type FieldRules struct {
+	Grants []string //nil means all fields are granted
+	Denies []string
+	Masks  []string
+}
+
+// MergeFieldRules combines the rules of multiple permissions on the same index,
+// access is the union of the permissions, so a field is only denied or masked when all of them say so,
+// returns nil if any permission is unrestricted
+func MergeFieldRules(items []*FieldRules) *FieldRules {
+	if len(items) == 0 {
+		return nil
+	}
+	for _, v := range items {
+		if v == nil {
+			return nil
+		}
+	}
+
+	merged := &FieldRules{
+		Denies: items[0].Denies,
+		Masks:  items[0].Masks,
+	}
+	allGranted := false
+	for _, v := range items {
+		if v.Grants == nil {
+			allGranted = true
+		}
+		merged.Grants = append(merged.Grants, v.Grants...)
+		merged.Denies = intersect(merged.Denies, v.Denies)
+		merged.Masks = intersect(merged.Masks, v.Masks)
+	}
+	if allGranted {
+		merged.Grants = nil
+	} else {
+		merged.Grants = dedupStrings(merged.Grants)
+	}
+	return merged
+}
+
+func intersect(a, b []string) []string {
+	var result []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				result = append(result, x)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func dedupStrings(items []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, v := range items {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Equals checks if two rules are identical, nil means unrestricted
+func (rules *FieldRules) Equals(target *FieldRules) bool {
+	if rules == nil || target == nil {
+		return rules == target
+	}
+	return (rules.Grants == nil) == (target.Grants == nil) &&
+		strings.Join(rules.Grants, ",") == strings.Join(target.Grants, ",") &&
+		strings.Join(rules.Denies, ",") == strings.Join(target.Denies, ",") &&
+		strings.Join(rules.Masks, ",") == strings.Join(target.Masks, ",")
+}
+
+func matchField(patterns []string, field string) bool {
+	for _, v := range patterns {
+		if common.WildcardMatch(v, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Masker replaces the value of a masked field
+type Masker func(v interface{}) interface{}
+
+// NewMasker returns a masker which replaces values with a salted sha256 hash, or a fixed token
+func NewMasker(method, token, salt string) Masker {
+	if method == "hash" {
+		return func(v interface{}) interface{} {
+			h := sha256.Sum256([]byte(salt + fmt.Sprint(v)))
+			return hex.EncodeToString(h[:])
+		}
+	}
+	return func(v interface{}) interface{} {
+		return token
+	}
+}
+
+func maskValue(v interface{}, masker Masker) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, y := range x {
+			x[k] = maskValue(y, masker)
+		}
+		return x
+	case []interface{}:
+		for i, y := range x {
+			x[i] = maskValue(y, masker)
+		}
+		return x
+	case nil:
+		return nil
+	}
+	return masker(v)
+}
+
+// FilterSource removes the denied and not granted fields from the document source, and masks the masked fields
+func (rules *FieldRules) FilterSource(obj map[string]interface{}, masker Masker) {
+	rules.filterObject(obj, "", rules.Grants == nil, masker)
+}
+
+func (rules *FieldRules) filterObject(obj map[string]interface{}, prefix string, granted bool, masker Masker) {
+	for k, v := range obj {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		if matchField(rules.Denies, path) {
+			delete(obj, k)
+			continue
+		}
+
+		fieldGranted := granted || matchField(rules.Grants, path)
+
+		if matchField(rules.Masks, path) {
+			if fieldGranted {
+				obj[k] = maskValue(v, masker)
+			} else {
+				delete(obj, k)
+			}
+			continue
+		}
+
+		switch x := v.(type) {
+		case map[string]interface{}:
+			rules.filterObject(x, path, fieldGranted, masker)
+			if !fieldGranted && len(x) == 0 {
+				delete(obj, k)
+			}
+		case []interface{}:
+			var items []interface{}
+			for _, item := range x {
+				if child, ok := item.(map[string]interface{}); ok {
+					rules.filterObject(child, path, fieldGranted, masker)
+					if fieldGranted || len(child) > 0 {
+						items = append(items, child)
+					}
+				} else if fieldGranted {
+					items = append(items, item)
+				}
+			}
+			if !fieldGranted && len(items) == 0 {
+				delete(obj, k)
+			} else {
+				obj[k] = items
+			}
+		default:
+			if !fieldGranted {
+				delete(obj, k)
+			}
+		}
+	}
+}
+
+// FilterFlatFields applies the rules to sections keyed by the full field path, eg: `fields` and `highlight`
+func (rules *FieldRules) FilterFlatFields(obj map[string]interface{}, masker Masker) {
+	for k, v := range obj {
+		if matchField(rules.Denies, k) || (rules.Grants != nil && !matchField(rules.Grants, k)) {
+			delete(obj, k)
+			continue
+		}
+		if matchField(rules.Masks, k) {
+			obj[k] = maskValue(v, masker)
+		}
+	}
+}
+
+// FilterDocument applies the rules to a search hit or a get response, the `fields` section carries the
+// `docvalue_fields` and `stored_fields` too, and the inner hits share the rules of the hit
+func (rules *FieldRules) FilterDocument(doc map[string]interface{}, masker Masker) {
+	if source, ok := doc["_source"].(map[string]interface{}); ok {
+		rules.FilterSource(source, masker)
+	}
+	for _, section := range []string{"fields", "highlight"} {
+		if fields, ok := doc[section].(map[string]interface{}); ok {
+			rules.FilterFlatFields(fields, masker)
+		}
+	}
+	if innerHits, ok := doc["inner_hits"].(map[string]interface{}); ok {
+		for _, v := range innerHits {
+			for _, hit := range getHits(v) {
+				if x, ok := hit.(map[string]interface{}); ok {
+					rules.FilterDocument(x, masker)
+				}
+			}
+		}
+	}
+}
+
+// getHits returns the hits of the search response or the `top_hits` aggregation
+func getHits(v interface{}) []interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	hits, ok := obj["hits"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	items, _ := hits["hits"].([]interface{})
+	return items
+}
+
+// walkTopHits calls the func with the hits of all the `top_hits` aggregations of the aggregations
+func walkTopHits(v interface{}, f func(hit interface{})) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		hits := getHits(x)
+		for _, hit := range hits {
+			f(hit)
+		}
+		for k, y := range x {
+			//the aggregations may be named `hits` too
+			if k == "hits" && hits != nil {
+				continue
+			}
+			walkTopHits(y, f)
+		}
+	case []interface{}:
+		for _, y := range x {
+			walkTopHits(y, f)
+		}
+	}
+}
+
+// the options of the `_geo_distance` sort, the other keys are the fields
+var geoDistanceSortOptions = map[string]bool{
+	"order":           true,
+	"unit":            true,
+	"mode":            true,
+	"distance_type":   true,
+	"ignore_unmapped": true,
+	"nested":          true,
+}
+
+// the pipeline aggregations whose scripts only read the bucket values
+var bucketScriptAggregations = map[string]bool{
+	"bucket_script":   true,
+	"bucket_selector": true,
+}
+
+// CheckFieldReferences rejects the search which reads the restricted fields in other ways than fetching them,
+// the aggregations, sorts and collapse on the denied, not granted or masked fields reveal their values, and the
+// scripts can read any field
+func (rules *FieldRules) CheckFieldReferences(body []byte) error {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+
+	search := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&search); err != nil {
+		return err
+	}
+
+	for _, k := range []string{"script_fields", "runtime_mappings"} {
+		if _, ok := search[k]; ok {
+			return fmt.Errorf("[%v] is not supported by field level security", k)
+		}
+	}
+
+	for _, k := range []string{"aggs", "aggregations"} {
+		if err := rules.checkAggregations(search[k], ""); err != nil {
+			return err
+		}
+	}
+
+	if err := rules.checkSort(search["sort"]); err != nil {
+		return err
+	}
+
+	if collapse, ok := search["collapse"].(map[string]interface{}); ok {
+		if field, ok := collapse["field"].(string); ok {
+			if err := rules.checkField("collapse", field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rules *FieldRules) checkAggregations(v interface{}, parent string) error {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, y := range x {
+			switch k {
+			case "script":
+				if !bucketScriptAggregations[parent] {
+					return fmt.Errorf("scripts of the aggregations are not supported by field level security")
+				}
+			case "field":
+				if field, ok := y.(string); ok {
+					if err := rules.checkField("aggregations", field); err != nil {
+						return err
+					}
+				}
+			}
+			if err := rules.checkAggregations(y, k); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, y := range x {
+			if err := rules.checkAggregations(y, parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rules *FieldRules) checkSort(v interface{}) error {
+	switch x := v.(type) {
+	case string:
+		if x == "_score" || x == "_doc" {
+			return nil
+		}
+		//eg: `field:desc` of the uri search
+		return rules.checkField("sort", strings.Split(x, ":")[0])
+	case []interface{}:
+		for _, y := range x {
+			if err := rules.checkSort(y); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for k, y := range x {
+			switch k {
+			case "_score", "_doc":
+			case "_script":
+				return fmt.Errorf("script sorts are not supported by field level security")
+			case "_geo_distance":
+				if options, ok := y.(map[string]interface{}); ok {
+					for field := range options {
+						if geoDistanceSortOptions[field] {
+							continue
+						}
+						if err := rules.checkField("sort", field); err != nil {
+							return err
+						}
+					}
+				}
+			default:
+				if err := rules.checkField("sort", k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (rules *FieldRules) checkField(section, field string) error {
+	//the metadata fields, eg: `_id`, `_index`
+	if strings.HasPrefix(field, "_") {
+		return nil
+	}
+	if rules.IsRestricted(field) {
+		return fmt.Errorf("field [%v] of [%v] is restricted by field level security", field, section)
+	}
+	return nil
+}
+
+// IsRestricted checks if the field or any of its parent objects is denied, masked or not granted,
+// eg: `title.keyword` is restricted by the rules of `title`
+func (rules *FieldRules) IsRestricted(field string) bool {
+	parts := strings.Split(field, ".")
+	granted := rules.Grants == nil
+	for i := range parts {
+		path := strings.Join(parts[:i+1], ".")
+		if matchField(rules.Denies, path) || matchField(rules.Masks, path) {
+			return true
+		}
+		if !granted && matchField(rules.Grants, path) {
+			granted = true
+		}
+	}
+	return !granted
+}
+
+// FilterResponse applies the rules of each document's index to the response of _search, _msearch, _get, _source or _mget,
+// the index is only used by _source responses which carry no metadata, rulesOf returns nil if the index is unrestricted
+func FilterResponse(api, index string, body []byte, rulesOf func(index string) *FieldRules, masker Masker) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	response := map[string]interface{}{}
+	if err := decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+
+	filterDoc := func(v interface{}) {
+		doc, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		index, _ := doc["_index"].(string)
+		if rules := rulesOf(index); rules != nil {
+			rules.FilterDocument(doc, masker)
+		}
+	}
+
+	filterHits := func(v interface{}) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		//async search wraps the search response
+		if inner, ok := obj["response"].(map[string]interface{}); ok {
+			obj = inner
+		}
+		for _, hit := range getHits(obj) {
+			filterDoc(hit)
+		}
+		if aggregations, ok := obj["aggregations"]; ok {
+			walkTopHits(aggregations, filterDoc)
+		}
+	}
+
+	switch api {
+	case "_search", "_async_search":
+		filterHits(response)
+	case "_msearch":
+		if items, ok := response["responses"].([]interface{}); ok {
+			for _, item := range items {
+				filterHits(item)
+			}
+		}
+	case "_mget":
+		if items, ok := response["docs"].([]interface{}); ok {
+			for _, item := range items {
+				filterDoc(item)
+			}
+		}
+	case "_doc":
+		filterDoc(response)
+	case "_source":
+		if rules := rulesOf(index); rules != nil {
+			rules.FilterSource(response, masker)
+		}
+	default:
+		return body, nil
+	}
+
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n"), nil
+}
+
+// ApplySourceFilter adds `_source` includes and excludes to the search body, so the denied fields are never fetched,
+// fields requested explicitly by the user are kept and left to the response filtering
+func ApplySourceFilter(body []byte, rules *FieldRules) ([]byte, error) {
+	if rules == nil || (rules.Grants == nil && len(rules.Denies) == 0) {
+		return body, nil
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	var includes, excludes []string
+	value, dataType, _, err := jsonparser.Get(body, "_source")
+	if err != nil && err != jsonparser.KeyPathNotFoundError {
+		return nil, err
+	}
+
+	if err == nil {
+		switch dataType {
+		case jsonparser.Boolean:
+			if string(value) == "false" {
+				return body, nil
+			}
+		case jsonparser.String, jsonparser.Array:
+			includes = getStringOrArray(body, "_source")
+		case jsonparser.Object:
+			for _, k := range []string{"includes", "include"} {
+				includes = append(includes, getStringOrArray(value, k)...)
+			}
+			for _, k := range []string{"excludes", "exclude"} {
+				excludes = append(excludes, getStringOrArray(value, k)...)
+			}
+		}
+	}
+
+	if len(includes) == 0 && rules.Grants != nil {
+		includes = rules.Grants
+	}
+	excludes = append(excludes, rules.Denies...)
+
+	source := map[string][]string{}
+	if len(includes) > 0 {
+		source["includes"] = includes
+	}
+	if len(excludes) > 0 {
+		source["excludes"] = excludes
+	}
+	v, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	return jsonparser.Set(body, v, "_source")
+}
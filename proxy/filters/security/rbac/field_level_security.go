@@ -0,0 +1,322 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// RequestFieldSecurity adds `_source` filtering to search and get requests,
+// so the fields denied by the user's roles are never fetched from the cluster
+type RequestFieldSecurity struct {
+	Status int `config:"status"`
+}
+
+func (filter *RequestFieldSecurity) Name() string {
+	return "request_field_security"
+}
+
+// ResponseFieldSecurity strips the denied fields and masks the masked fields of search and get responses
+type ResponseFieldSecurity struct {
+	MaskMethod string `config:"mask_method"`
+	MaskToken  string `config:"mask_token"`
+	MaskSalt   string `config:"mask_salt"`
+	masker     Masker
+}
+
+func (filter *ResponseFieldSecurity) Name() string {
+	return "response_field_security"
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("request_field_security", NewRequestFieldSecurity, &RequestFieldSecurity{})
+	pipeline.RegisterFilterPluginWithConfigMetadata("response_field_security", NewResponseFieldSecurity, &ResponseFieldSecurity{})
+}
+
+func NewRequestFieldSecurity(c *config.Config) (pipeline.Filter, error) {
+
+	runner := RequestFieldSecurity{
+		Status: 403,
+	}
+
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	return &runner, nil
+}
+
+func NewResponseFieldSecurity(c *config.Config) (pipeline.Filter, error) {
+
+	runner := ResponseFieldSecurity{
+		MaskMethod: "token",
+		MaskToken:  "******",
+	}
+
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	if runner.MaskMethod != "hash" && runner.MaskMethod != "token" {
+		return nil, fmt.Errorf("invalid mask_method [%v], should be hash or token", runner.MaskMethod)
+	}
+
+	runner.masker = NewMasker(runner.MaskMethod, runner.MaskToken, runner.MaskSalt)
+
+	return &runner, nil
+}
+
+// GetFieldRules returns the merged field rules of the roles on the index, nil if the index is unrestricted
+func GetFieldRules(roles []common.Role, index string) *FieldRules {
+	var items []*FieldRules
+	for _, role := range roles {
+		for _, perm := range role.GetIndexPermissions(index) {
+			if !perm.HasPrivilege("read") {
+				continue
+			}
+			if len(perm.FieldSecurity) == 0 {
+				return nil
+			}
+			rules := &FieldRules{}
+			for _, v := range perm.FieldSecurity {
+				switch v.Type {
+				case "deny":
+					rules.Denies = append(rules.Denies, v.Name...)
+				case "mask":
+					rules.Masks = append(rules.Masks, v.Name...)
+				default:
+					rules.Grants = append(rules.Grants, v.Name...)
+				}
+			}
+			//masked fields are visible, just not in clear text
+			if rules.Grants != nil {
+				rules.Grants = append(rules.Grants, rules.Masks...)
+			}
+			items = append(items, rules)
+		}
+	}
+
+	//no read access at all, nothing is visible
+	if len(items) == 0 {
+		return &FieldRules{Grants: []string{}}
+	}
+
+	return MergeFieldRules(items)
+}
+
+func (filter *RequestFieldSecurity) Filter(ctx *fasthttp.RequestCtx) {
+
+	method := string(ctx.Method())
+	path := string(ctx.PhantomURI().Path())
+
+	req := ParseElasticsearchRequest(method, path, nil)
+	if req.Cluster {
+		return
+	}
+
+	roles := common.GetRoles(common.GetUserRoles(ctx))
+
+	switch req.API {
+	case "_search", "_async_search":
+		if len(req.Indices) == 0 {
+			return
+		}
+		if err := checkFieldReferences(roles, req.GetIndices(), ctx.Request.Body()); err != nil {
+			filter.deny(ctx, err)
+			return
+		}
+		rules, ok := sameFieldRules(roles, req.GetIndices())
+		if !ok {
+			//different rules on the target indices, leave them to response_field_security
+			return
+		}
+		body, err := ApplySourceFilter(ctx.Request.Body(), rules)
+		if err != nil {
+			log.Debug("failed to apply field level security, ", err)
+			return
+		}
+		ctx.Request.SetRawBody(body)
+	case "_msearch":
+		var referenceErr error
+		body, err := RewriteMsearchBody(ctx.Request.Body(), req.GetIndices(), func(indices []string, search []byte) ([]byte, error) {
+			if referenceErr = checkFieldReferences(roles, indices, search); referenceErr != nil {
+				return nil, referenceErr
+			}
+			rules, ok := sameFieldRules(roles, indices)
+			if !ok {
+				return search, nil
+			}
+			return ApplySourceFilter(search, rules)
+		})
+		if referenceErr != nil {
+			filter.deny(ctx, referenceErr)
+			return
+		}
+		if err != nil {
+			log.Debug("failed to apply field level security, ", err)
+			return
+		}
+		ctx.Request.SetRawBody(body)
+	case "_doc", "_source", "_mget":
+		if method != "GET" && method != "HEAD" && req.API != "_mget" {
+			return
+		}
+		indices := req.GetIndices()
+		if req.API == "_mget" {
+			indices = ParseElasticsearchRequest(method, path, ctx.Request.Body()).GetIndices()
+		}
+		rules, ok := sameFieldRules(roles, indices)
+		if !ok || rules == nil {
+			return
+		}
+
+		clonedURI := ctx.Request.CloneURI()
+		defer fasthttp.ReleaseURI(clonedURI)
+		args := clonedURI.QueryArgs()
+		if rules.Grants != nil && !args.Has("_source_includes") {
+			args.Set("_source_includes", strings.Join(rules.Grants, ","))
+		}
+		if len(rules.Denies) > 0 {
+			excludes := strings.Join(rules.Denies, ",")
+			if v := args.Peek("_source_excludes"); len(v) > 0 {
+				excludes = string(v) + "," + excludes
+			}
+			args.Set("_source_excludes", excludes)
+		}
+		clonedURI.SetQueryString(args.String())
+		ctx.Request.SetURI(clonedURI)
+	}
+
+	if global.Env().IsDebug {
+		log.Tracef("field level security applied to [%v]", path)
+	}
+}
+
+// checkFieldReferences checks the references of the restricted fields in the search against the rules of each index
+func checkFieldReferences(roles []common.Role, indices []string, body []byte) error {
+	for _, index := range indices {
+		rules := GetFieldRules(roles, index)
+		if rules == nil {
+			continue
+		}
+		if err := rules.CheckFieldReferences(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (filter *RequestFieldSecurity) deny(ctx *fasthttp.RequestCtx, err error) {
+	if global.Env().IsDebug {
+		log.Debug("request denied by field level security, ", err)
+	}
+	ctx.SetDestination("filtered")
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(filter.Status)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": err.Error(),
+		},
+		"status": filter.Status,
+	}))
+	ctx.Finished()
+}
+
+// sameFieldRules returns the rules shared by all the indices, or false if they have different rules
+func sameFieldRules(roles []common.Role, indices []string) (*FieldRules, bool) {
+	var rules *FieldRules
+	for i, index := range indices {
+		v := GetFieldRules(roles, index)
+		if i > 0 && !rules.Equals(v) {
+			return nil, false
+		}
+		rules = v
+	}
+	return rules, true
+}
+
+func (filter *ResponseFieldSecurity) Filter(ctx *fasthttp.RequestCtx) {
+
+	if ctx.Response.StatusCode() != 200 {
+		return
+	}
+
+	method := string(ctx.Method())
+	path := string(ctx.PhantomURI().Path())
+
+	req := ParseElasticsearchRequest(method, path, nil)
+	if req.Cluster {
+		return
+	}
+
+	switch req.API {
+	case "_search", "_async_search", "_msearch", "_mget":
+	case "_doc", "_source":
+		if method != "GET" {
+			return
+		}
+	default:
+		return
+	}
+
+	roles := common.GetRoles(common.GetUserRoles(ctx))
+	cache := map[string]*FieldRules{}
+	rulesOf := func(index string) *FieldRules {
+		v, ok := cache[index]
+		if !ok {
+			v = GetFieldRules(roles, index)
+			cache[index] = v
+		}
+		return v
+	}
+
+	body := ctx.Response.GetRawBody()
+	if len(body) == 0 {
+		return
+	}
+
+	newBody, err := FilterResponse(req.API, firstOrEmpty(req.GetIndices()), body, rulesOf, filter.masker)
+	if err != nil {
+		log.Debug("failed to apply field level security on response, ", err)
+		//never leak the unfiltered response
+		ctx.Response.SetStatusCode(500)
+		ctx.Response.SetRawBody([]byte("{\"error\":{\"type\":\"security_exception\",\"reason\":\"failed to apply field level security\"},\"status\":500}"))
+		return
+	}
+
+	ctx.Response.SetRawBody(newBody)
+}
@@ -0,0 +1,153 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var tokenMasker = NewMasker("token", "***", "")
+
+func TestMergeFieldRules(t *testing.T) {
+	assert.Nil(t, MergeFieldRules([]*FieldRules{{Denies: []string{"ssn"}}, nil}))
+
+	rules := MergeFieldRules([]*FieldRules{
+		{Grants: []string{"name", "email"}, Denies: []string{"ssn"}, Masks: []string{"email"}},
+		{Grants: []string{"phone"}, Denies: []string{"ssn", "salary"}},
+	})
+	assert.Equal(t, []string{"email", "name", "phone"}, rules.Grants)
+	assert.Equal(t, []string{"ssn"}, rules.Denies)
+	assert.Equal(t, 0, len(rules.Masks))
+
+	rules = MergeFieldRules([]*FieldRules{{Grants: []string{"name"}}, {Denies: []string{"ssn"}}})
+	assert.Nil(t, rules.Grants)
+}
+
+func TestFilterSearchResponse(t *testing.T) {
+	body := []byte(`{"took":1,"hits":{"total":{"value":2},"hits":[` +
+		`{"_index":"users","_id":"1","_source":{"name":"a","ssn":"123","contact":{"email":"a@b.c","phone":"1"}},"highlight":{"ssn":["<em>123</em>"],"name":["<em>a</em>"]},"fields":{"contact.email":["a@b.c"]}},` +
+		`{"_index":"public","_id":"2","_source":{"name":"b","ssn":"456"}}]}}`)
+
+	rules := &FieldRules{Denies: []string{"ssn"}, Masks: []string{"contact.email"}}
+	newBody, err := FilterResponse("_search", "", body, func(index string) *FieldRules {
+		if index == "users" {
+			return rules
+		}
+		return nil
+	}, tokenMasker)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"hits":{"hits":[`+
+		`{"_id":"1","_index":"users","_source":{"contact":{"email":"***","phone":"1"},"name":"a"},"fields":{"contact.email":["***"]},"highlight":{"name":["<em>a</em>"]}},`+
+		`{"_id":"2","_index":"public","_source":{"name":"b","ssn":"456"}}],"total":{"value":2}},"took":1}`, string(newBody))
+}
+
+func TestFilterSourceWithGrants(t *testing.T) {
+	body := []byte(`{"_index":"users","_id":"1","found":true,"_source":{"name":"a","ssn":"123","contact":{"email":"a@b.c","phone":"1"},"tags":[{"k":"x","secret":"y"}]}}`)
+	rules := &FieldRules{Grants: []string{"name", "contact.phone", "tags.k"}}
+	newBody, err := FilterResponse("_doc", "", body, func(index string) *FieldRules { return rules }, tokenMasker)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_id":"1","_index":"users","_source":{"contact":{"phone":"1"},"name":"a","tags":[{"k":"x"}]},"found":true}`, string(newBody))
+
+	newBody, err = FilterResponse("_source", "users", []byte(`{"name":"a","ssn":"123"}`), func(index string) *FieldRules { return rules }, tokenMasker)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"a"}`, string(newBody))
+}
+
+func TestMaskWithHash(t *testing.T) {
+	masker := NewMasker("hash", "", "salt")
+	assert.Equal(t, masker("a"), masker("a"))
+	assert.NotEqual(t, masker("a"), masker("b"))
+	assert.Equal(t, 64, len(masker("a").(string)))
+}
+
+func TestApplySourceFilter(t *testing.T) {
+	rules := &FieldRules{Grants: []string{"name", "email"}, Denies: []string{"ssn"}}
+
+	newBody, err := ApplySourceFilter([]byte(`{"query":{"match_all":{}}}`), rules)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"query":{"match_all":{}},"_source":{"excludes":["ssn"],"includes":["name","email"]}}`, string(newBody))
+
+	newBody, err = ApplySourceFilter([]byte(`{"_source":["name"]}`), rules)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_source":{"excludes":["ssn"],"includes":["name"]}}`, string(newBody))
+
+	newBody, err = ApplySourceFilter([]byte(`{"_source":{"excludes":["a"]}}`), &FieldRules{Denies: []string{"ssn"}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_source":{"excludes":["a","ssn"]}}`, string(newBody))
+
+	newBody, err = ApplySourceFilter([]byte(`{"_source":false}`), rules)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_source":false}`, string(newBody))
+}
+
+func TestFilterTopHitsAndInnerHits(t *testing.T) {
+	body := []byte(`{"hits":{"hits":[{"_index":"users","_id":"1","_source":{"name":"a","ssn":"1"},` +
+		`"inner_hits":{"comments":{"hits":{"hits":[{"_index":"users","_id":"1","_source":{"text":"x","ssn":"1"}}]}}}}]},` +
+		`"aggregations":{"by_name":{"buckets":[{"key":"a","top":{"hits":{"hits":[{"_index":"users","_id":"1","_source":{"name":"a","ssn":"1"},"fields":{"ssn":["1"]}}]}}}]}}}`)
+	rules := &FieldRules{Denies: []string{"ssn", "comments.ssn"}}
+	newBody, err := FilterResponse("_search", "", body, func(index string) *FieldRules { return rules }, tokenMasker)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(newBody), "ssn")
+	assert.Contains(t, string(newBody), `"text":"x"`)
+}
+
+func TestCheckFieldReferences(t *testing.T) {
+	rules := &FieldRules{Grants: []string{"name", "title", "email", "location"}, Denies: []string{"ssn"}, Masks: []string{"email"}}
+
+	assert.Nil(t, rules.CheckFieldReferences(nil))
+	assert.Nil(t, rules.CheckFieldReferences([]byte(`{"aggs":{"names":{"terms":{"field":"title.keyword"},"aggs":{"top":{"top_hits":{}}}}},"sort":["_score",{"name":"asc"},{"_id":"asc"}]}`)))
+	assert.Nil(t, rules.CheckFieldReferences([]byte(`{"aggs":{"h":{"histogram":{"field":"name"},"aggs":{"s":{"bucket_script":{"buckets_path":{"c":"_count"},"script":"params.c"}}}}}}`)))
+	assert.Nil(t, rules.CheckFieldReferences([]byte(`{"sort":{"_geo_distance":{"location":[0,0],"order":"asc","unit":"km"}}}`)))
+
+	rejected := []string{
+		`{"aggs":{"ssn":{"terms":{"field":"ssn"}}}}`,
+		`{"aggregations":{"a":{"terms":{"field":"name"},"aggs":{"b":{"max":{"field":"salary"}}}}}}`,
+		`{"aggs":{"emails":{"terms":{"field":"email"}}}}`,
+		`{"aggs":{"a":{"multi_terms":{"terms":[{"field":"name"},{"field":"ssn"}]}}}}`,
+		`{"aggs":{"a":{"terms":{"script":"doc['ssn'].value"}}}}`,
+		`{"sort":"ssn"}`,
+		`{"sort":[{"email":"asc"}]}`,
+		`{"sort":{"_script":{"type":"number","script":"doc['ssn'].value"}}}`,
+		`{"sort":{"_geo_distance":{"home":[0,0]}}}`,
+		`{"collapse":{"field":"salary"}}`,
+		`{"script_fields":{"s":{"script":"doc['ssn'].value"}}}`,
+		`{"runtime_mappings":{"s":{"type":"keyword"}}}`,
+	}
+	for _, v := range rejected {
+		assert.NotNil(t, rules.CheckFieldReferences([]byte(v)), v)
+	}
+}
+
+func TestFilterDocvalueFields(t *testing.T) {
+	//docvalue_fields and stored_fields are returned in the fields section
+	body := []byte(`{"hits":{"hits":[{"_index":"users","_id":"1","fields":{"name":["a"],"ssn":["1"],"email":["a@b.c"]}}]}}`)
+	rules := &FieldRules{Denies: []string{"ssn"}, Masks: []string{"email"}}
+	newBody, err := FilterResponse("_search", "", body, func(index string) *FieldRules { return rules }, tokenMasker)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"hits":{"hits":[{"_id":"1","_index":"users","fields":{"email":["***"],"name":["a"]}}]}}`, string(newBody))
+}
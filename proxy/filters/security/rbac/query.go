@@ -0,0 +1,297 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/valyala/fasttemplate"
+)
+
+// IndexQueries holds the document level security queries of an index expression
+type IndexQueries struct {
+	Index        string
+	Unrestricted bool     //granted by a role without query
+	Queries      []string //granted by roles with these queries, any of them is visible
+}
+
+var matchAllQuery = `{"match_all":{}}`
+var matchNoneQuery = `{"bool":{"must_not":[{"match_all":{}}]}}`
+
+// BuildSecurityQuery returns the filter query which limits the documents visible on these index expressions,
+// returns nil if all of them are unrestricted
+func BuildSecurityQuery(items []IndexQueries) []byte {
+	if len(items) == 0 {
+		return nil
+	}
+
+	unrestricted := true
+	sameQueries := true
+	for i := range items {
+		sort.Strings(items[i].Queries)
+		if !items[i].Unrestricted {
+			unrestricted = false
+		}
+		if items[i].Unrestricted != items[0].Unrestricted || strings.Join(items[i].Queries, "\n") != strings.Join(items[0].Queries, "\n") {
+			sameQueries = false
+		}
+	}
+
+	if unrestricted {
+		return nil
+	}
+
+	if sameQueries {
+		return []byte(anyOfQueries(items[0].Queries))
+	}
+
+	//different indices have different restrictions, limit each query to its own index
+	var clauses []string
+	for _, v := range items {
+		if v.Unrestricted {
+			clauses = append(clauses, indexQuery(v.Index))
+		} else if len(v.Queries) > 0 {
+			clauses = append(clauses, `{"bool":{"filter":[`+indexQuery(v.Index)+`,`+anyOfQueries(v.Queries)+`]}}`)
+		}
+	}
+	return []byte(anyOfQueries(clauses))
+}
+
+func anyOfQueries(queries []string) string {
+	queries = dedupQueries(queries)
+	switch len(queries) {
+	case 0:
+		return matchNoneQuery
+	case 1:
+		return queries[0]
+	}
+	return `{"bool":{"should":[` + strings.Join(queries, ",") + `],"minimum_should_match":1}}`
+}
+
+func dedupQueries(queries []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, v := range queries {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func indexQuery(index string) string {
+	if index == "_all" || index == "*" {
+		return matchAllQuery
+	}
+	v := jsonString(index)
+	if strings.Contains(index, "*") {
+		return `{"wildcard":{"_index":{"value":` + v + `}}}`
+	}
+	return `{"term":{"_index":` + v + `}}`
+}
+
+func jsonString(str string) string {
+	v, _ := json.Marshal(str)
+	return string(v)
+}
+
+// ApplySecurityQuery wraps the query of the search body with the security query as a filter,
+// the lucene query string passed by `q` is merged into the body as well, the `knn` searches are
+// filtered too, and the `suggest` which can't be filtered is rejected
+func ApplySecurityQuery(body []byte, security []byte, queryString string) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	if _, _, _, err := jsonparser.Get(body, "suggest"); err == nil {
+		return nil, errors.New("[suggest] is not supported by document level security")
+	}
+
+	var must []string
+	original, _, _, err := jsonparser.Get(body, "query")
+	if err == nil {
+		must = append(must, string(original))
+	} else if err != jsonparser.KeyPathNotFoundError {
+		return nil, err
+	}
+
+	if queryString != "" {
+		must = append(must, `{"query_string":{"query":`+jsonString(queryString)+`}}`)
+	}
+
+	query := `{"bool":{`
+	if len(must) > 0 {
+		query += `"must":[` + strings.Join(must, ",") + `],`
+	}
+	query += `"filter":[` + string(security) + `]}}`
+
+	body, err = applyKnnFilter(body, security)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonparser.Set(body, []byte(query), "query")
+}
+
+// applyKnnFilter adds the security query to the filter of each `knn` search, which is not limited by the `query`
+func applyKnnFilter(body []byte, security []byte) ([]byte, error) {
+	knn, dataType, _, err := jsonparser.Get(body, "knn")
+	if err == jsonparser.KeyPathNotFoundError {
+		return body, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch dataType {
+	case jsonparser.Object:
+		knn, err = addKnnFilter(knn, security)
+	case jsonparser.Array:
+		var items []string
+		var itemErr error
+		_, err = jsonparser.ArrayEach(knn, func(value []byte, dataType jsonparser.ValueType, offset int, e error) {
+			if itemErr != nil {
+				return
+			}
+			value, itemErr = addKnnFilter(value, security)
+			items = append(items, string(value))
+		})
+		if err == nil {
+			err = itemErr
+		}
+		knn = []byte("[" + strings.Join(items, ",") + "]")
+	default:
+		return nil, errors.New("invalid [knn] section")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonparser.Set(body, knn, "knn")
+}
+
+func addKnnFilter(knn []byte, security []byte) ([]byte, error) {
+	knn = append([]byte{}, knn...)
+	var filters []string
+	v, dataType, _, err := jsonparser.Get(knn, "filter")
+	if err == nil {
+		if dataType == jsonparser.Array {
+			jsonparser.ArrayEach(v, func(value []byte, dataType jsonparser.ValueType, offset int, e error) {
+				filters = append(filters, string(value))
+			})
+		} else {
+			filters = append(filters, string(v))
+		}
+	} else if err != jsonparser.KeyPathNotFoundError {
+		return nil, err
+	}
+	filters = append(filters, string(security))
+	return jsonparser.Set(knn, []byte("["+strings.Join(filters, ",")+"]"), "filter")
+}
+
+// CheckSecuredSearchAPI rejects the search apis which can't be rewritten with the security query,
+// eg: the search templates render the query from the script, and the deprecated `_knn_search` api
+func CheckSecuredSearchAPI(api, path string) error {
+	if api == "_knn_search" {
+		return errors.New("[_knn_search] is not supported by document level security, use the [knn] option of [_search]")
+	}
+	if (api == "_search" || api == "_msearch") && strings.HasSuffix(strings.TrimRight(path, "/"), "/template") {
+		return errors.New("search templates are not supported by document level security")
+	}
+	return nil
+}
+
+// the apis which read the documents by id, they can't be limited by the security query
+var securedDocumentAPIs = map[string]bool{
+	"_mget":         true,
+	"_explain":      true,
+	"_termvectors":  true,
+	"_mtermvectors": true,
+}
+
+// CheckSecuredDocumentAPI rejects the apis which return the documents without a search, eg: `GET /<index>/_doc/<id>`,
+// as the documents are not filtered by the security query
+func CheckSecuredDocumentAPI(method, api string) error {
+	if securedDocumentAPIs[api] || ((api == "_doc" || api == "_source") && (method == "GET" || method == "HEAD")) {
+		return fmt.Errorf("[%v] is not supported by document level security, use [_search] instead", api)
+	}
+	return nil
+}
+
+// RewriteMsearchBody rewrites each search of the _msearch body, with the indices of its header
+func RewriteMsearchBody(body []byte, defaultIndices []string, rewrite func(indices []string, search []byte) ([]byte, error)) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	var indices []string
+	header := true
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if header {
+			indices = defaultIndices
+			if v := getStringOrArray(line, "index"); len(v) > 0 {
+				indices = nil
+				for _, x := range v {
+					indices = append(indices, parseIndexExpression(x)...)
+				}
+			}
+			if len(indices) == 0 {
+				indices = []string{"_all"}
+			}
+		} else {
+			var err error
+			line, err = rewrite(indices, line)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+		header = !header
+	}
+	return buffer.Bytes(), nil
+}
+
+// RenderQuery renders the `{{user.*}}` variables in the query template, values are escaped as json strings
+func RenderQuery(query string, lookup func(tag string) string) string {
+	if !strings.Contains(query, "{{") {
+		return query
+	}
+	return fasttemplate.ExecuteFuncString(query, "{{", "}}", func(w io.Writer, tag string) (int, error) {
+		v := jsonString(lookup(strings.TrimSpace(tag)))
+		return w.Write([]byte(v[1 : len(v)-1]))
+	})
+}
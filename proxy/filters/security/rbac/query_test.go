@@ -0,0 +1,138 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const tenantQuery = `{"term":{"tenant":"a"}}`
+
+func TestBuildSecurityQuery(t *testing.T) {
+	assert.Nil(t, BuildSecurityQuery([]IndexQueries{{Index: "a", Unrestricted: true}}))
+
+	q := BuildSecurityQuery([]IndexQueries{{Index: "a", Queries: []string{tenantQuery}}, {Index: "b", Queries: []string{tenantQuery}}})
+	assert.Equal(t, tenantQuery, string(q))
+
+	q = BuildSecurityQuery([]IndexQueries{{Index: "a", Queries: []string{tenantQuery, `{"term":{"public":true}}`}}})
+	assert.Equal(t, `{"bool":{"should":[{"term":{"public":true}},{"term":{"tenant":"a"}}],"minimum_should_match":1}}`, string(q))
+
+	q = BuildSecurityQuery([]IndexQueries{{Index: "logs-*", Queries: []string{tenantQuery}}, {Index: "public", Unrestricted: true}, {Index: "secret"}})
+	assert.Equal(t, `{"bool":{"should":[{"bool":{"filter":[{"wildcard":{"_index":{"value":"logs-*"}}},{"term":{"tenant":"a"}}]}},{"term":{"_index":"public"}}],"minimum_should_match":1}}`, string(q))
+
+	q = BuildSecurityQuery([]IndexQueries{{Index: "secret"}})
+	assert.Equal(t, matchNoneQuery, string(q))
+}
+
+func TestApplySecurityQuery(t *testing.T) {
+	body := []byte(`{"query":{"match":{"title":"hello"}},"size":10}`)
+	newBody, err := ApplySecurityQuery(body, []byte(tenantQuery), "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"query":{"bool":{"must":[{"match":{"title":"hello"}}],"filter":[{"term":{"tenant":"a"}}]}},"size":10}`, string(newBody))
+
+	newBody, err = ApplySecurityQuery(nil, []byte(tenantQuery), "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"tenant":"a"}}]}}}`, string(newBody))
+
+	newBody, err = ApplySecurityQuery([]byte(`{"aggs":{"a":{"terms":{"field":"f"}}}}`), []byte(tenantQuery), "title:\"hello\"")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"aggs":{"a":{"terms":{"field":"f"}}},"query":{"bool":{"must":[{"query_string":{"query":"title:\"hello\""}}],"filter":[{"term":{"tenant":"a"}}]}}}`, string(newBody))
+
+	_, err = ApplySecurityQuery([]byte(`{"query":`), []byte(tenantQuery), "")
+	assert.NotNil(t, err)
+}
+
+func TestApplySecurityQueryToKnn(t *testing.T) {
+	body := []byte(`{"knn":{"field":"v","query_vector":[1,2],"k":10}}`)
+	newBody, err := ApplySecurityQuery(body, []byte(tenantQuery), "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"knn":{"field":"v","query_vector":[1,2],"k":10,"filter":[{"term":{"tenant":"a"}}]},"query":{"bool":{"filter":[{"term":{"tenant":"a"}}]}}}`, string(newBody))
+
+	body = []byte(`{"knn":[{"field":"v","k":1,"filter":{"term":{"f":1}}},{"field":"w","k":2,"filter":[{"term":{"f":2}}]}]}`)
+	newBody, err = ApplySecurityQuery(body, []byte(tenantQuery), "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"knn":[{"field":"v","k":1,"filter":[{"term":{"f":1}},{"term":{"tenant":"a"}}]},{"field":"w","k":2,"filter":[{"term":{"f":2}},{"term":{"tenant":"a"}}]}],"query":{"bool":{"filter":[{"term":{"tenant":"a"}}]}}}`, string(newBody))
+
+	_, err = ApplySecurityQuery([]byte(`{"knn":"v"}`), []byte(tenantQuery), "")
+	assert.NotNil(t, err)
+}
+
+func TestApplySecurityQueryToRescore(t *testing.T) {
+	//the rescore only works on the hits of the filtered query
+	body := []byte(`{"rescore":{"window_size":10,"query":{"rescore_query":{"match":{"title":"hello"}}}}}`)
+	newBody, err := ApplySecurityQuery(body, []byte(tenantQuery), "")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"rescore":{"window_size":10,"query":{"rescore_query":{"match":{"title":"hello"}}}},"query":{"bool":{"filter":[{"term":{"tenant":"a"}}]}}}`, string(newBody))
+}
+
+func TestRejectUnsupportedSecuredSearch(t *testing.T) {
+	_, err := ApplySecurityQuery([]byte(`{"suggest":{"s":{"text":"hel","completion":{"field":"title"}}}}`), []byte(tenantQuery), "")
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, CheckSecuredSearchAPI("_search", "/index/_search/template"))
+	assert.NotNil(t, CheckSecuredSearchAPI("_msearch", "/_msearch/template/"))
+	assert.NotNil(t, CheckSecuredSearchAPI("_knn_search", "/index/_knn_search"))
+	assert.Nil(t, CheckSecuredSearchAPI("_search", "/index/_search"))
+	assert.Nil(t, CheckSecuredSearchAPI("_doc", "/index/_doc/template"))
+
+	//the documents read by id
+	assert.NotNil(t, CheckSecuredDocumentAPI("GET", "_doc"))
+	assert.NotNil(t, CheckSecuredDocumentAPI("HEAD", "_source"))
+	assert.NotNil(t, CheckSecuredDocumentAPI("POST", "_mget"))
+	assert.NotNil(t, CheckSecuredDocumentAPI("GET", "_explain"))
+	assert.NotNil(t, CheckSecuredDocumentAPI("POST", "_termvectors"))
+	assert.NotNil(t, CheckSecuredDocumentAPI("GET", "_mtermvectors"))
+	assert.Nil(t, CheckSecuredDocumentAPI("PUT", "_doc"))
+	assert.Nil(t, CheckSecuredDocumentAPI("GET", "_search"))
+}
+
+func TestRewriteMsearchBody(t *testing.T) {
+	body := []byte("{\"index\":\"secured\"}\n{\"query\":{\"match_all\":{}}}\n{\"index\":\"public\"}\n{\"size\":0}\n{}\n{}\n")
+	rewrite := func(indices []string, search []byte) ([]byte, error) {
+		if indices[0] == "public" {
+			return search, nil
+		}
+		return ApplySecurityQuery(search, []byte(tenantQuery), "")
+	}
+	newBody, err := RewriteMsearchBody(body, []string{"default"}, rewrite)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"index\":\"secured\"}\n{\"query\":{\"bool\":{\"must\":[{\"match_all\":{}}],\"filter\":[{\"term\":{\"tenant\":\"a\"}}]}}}\n"+
+		"{\"index\":\"public\"}\n{\"size\":0}\n"+
+		"{}\n{\"query\":{\"bool\":{\"filter\":[{\"term\":{\"tenant\":\"a\"}}]}}}\n", string(newBody))
+}
+
+func TestRenderQuery(t *testing.T) {
+	lookup := func(tag string) string {
+		if tag == "user.name" {
+			return "tes\"la"
+		}
+		return ""
+	}
+	assert.Equal(t, `{"term":{"owner":"tes\"la"}}`, RenderQuery(`{"term":{"owner":"{{user.name}}"}}`, lookup))
+	assert.Equal(t, `{"term":{"owner":""}}`, RenderQuery(`{"term":{"owner":"{{ user.unknown }}"}}`, lookup))
+}
@@ -0,0 +1,460 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+// IndexAccess is the privilege required on a single index expression
+type IndexAccess struct {
+	Index     string
+	Privilege string
+}
+
+// ElasticsearchRequest describes what a request is going to do to the cluster
+type ElasticsearchRequest struct {
+	API       string //the api endpoint, eg: _search, _bulk, _cluster
+	Cluster   bool   //whether this is a cluster level request
+	Privilege string //the privilege required for cluster requests or for the index in path
+	Indices   []IndexAccess
+}
+
+// GetIndices returns the distinct index expressions of this request
+func (req *ElasticsearchRequest) GetIndices() []string {
+	var indices []string
+	seen := map[string]bool{}
+	for _, v := range req.Indices {
+		if !seen[v.Index] {
+			seen[v.Index] = true
+			indices = append(indices, v.Index)
+		}
+	}
+	return indices
+}
+
+var readAPIs = map[string]bool{
+	"_search":       true,
+	"_msearch":      true,
+	"_count":        true,
+	"_mget":         true,
+	"_async_search": true,
+	"_field_caps":   true,
+	"_validate":     true,
+	"_explain":      true,
+	"_termvectors":  true,
+	"_mtermvectors": true,
+	"_rank_eval":    true,
+	"_knn_search":   true,
+	"_terms_enum":   true,
+	"_pit":          true,
+}
+
+// the query language apis reference the indices in the query, which is not parsed, so they are checked against all the indices
+var queryLanguageAPIs = map[string]bool{
+	"_sql":   true,
+	"_query": true,
+	"_eql":   true,
+}
+
+// the cluster apis which can be read with the monitor privilege, any other unknown api requires the manage privilege
+var clusterMonitorAPIs = map[string]bool{
+	"_cluster": true,
+	"_nodes":   true,
+	"_tasks":   true,
+	"_license": true,
+	"_xpack":   true,
+	"_remote":  true,
+}
+
+var indexMonitorAPIs = map[string]bool{
+	"_stats":        true,
+	"_segments":     true,
+	"_recovery":     true,
+	"_shard_stores": true,
+}
+
+var indexMetadataAPIs = map[string]bool{
+	"_mapping":  true,
+	"_mappings": true,
+	"_settings": true,
+	"_alias":    true,
+	"_aliases":  true,
+}
+
+// ParseElasticsearchRequest classifies the request into cluster or index actions,
+// indices referenced in the body of _bulk, _mget, _msearch and _reindex are also collected
+func ParseElasticsearchRequest(method, path string, body []byte) *ElasticsearchRequest {
+	method = strings.ToUpper(method)
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	var segments []string
+	for _, v := range strings.Split(path, "/") {
+		if v != "" {
+			segments = append(segments, v)
+		}
+	}
+
+	req := &ElasticsearchRequest{}
+
+	if len(segments) == 0 {
+		req.API = "/"
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage")
+		return req
+	}
+
+	if strings.HasPrefix(segments[0], "_") && !isIndexExpression(segments[0]) {
+		parseRootAPI(req, method, segments, body)
+		return req
+	}
+
+	indices := parseIndexExpression(segments[0])
+
+	if len(segments) == 1 {
+		req.API = segments[0]
+		switch method {
+		case "GET", "HEAD":
+			req.Privilege = "view_index_metadata"
+		case "PUT":
+			req.Privilege = "create_index"
+		case "DELETE":
+			req.Privilege = "delete_index"
+		default:
+			req.Privilege = "manage"
+		}
+		req.addIndices(indices, req.Privilege)
+		return req
+	}
+
+	api := segments[1]
+	//legacy typed apis, eg: /index/type/_search or /index/type/id
+	if !strings.HasPrefix(api, "_") {
+		if len(segments) > 2 && strings.HasPrefix(segments[2], "_") {
+			api = segments[2]
+		} else {
+			api = "_doc"
+		}
+	}
+	req.API = api
+
+	switch {
+	case readAPIs[api]:
+		req.Privilege = "read"
+	case api == "_doc" || api == "_source":
+		switch method {
+		case "GET", "HEAD":
+			req.Privilege = "read"
+		case "DELETE":
+			req.Privilege = "delete"
+		default:
+			req.Privilege = "index"
+		}
+	case api == "_create":
+		req.Privilege = "create_doc"
+	case api == "_update" || api == "_update_by_query":
+		req.Privilege = "index"
+	case api == "_delete_by_query":
+		req.Privilege = "delete"
+	case api == "_bulk":
+		req.Privilege = "write"
+		parseBulkIndices(req, body, firstOrEmpty(indices))
+		return req
+	case indexMonitorAPIs[api]:
+		req.Privilege = "monitor"
+	case indexMetadataAPIs[api] && (method == "GET" || method == "HEAD"):
+		req.Privilege = "view_index_metadata"
+	default:
+		req.Privilege = "manage"
+	}
+
+	req.addIndices(indices, req.Privilege)
+
+	switch api {
+	case "_mget":
+		parseMgetIndices(req, body, firstOrEmpty(indices))
+	case "_msearch":
+		parseMsearchIndices(req, body, firstOrEmpty(indices))
+	}
+
+	return req
+}
+
+func parseRootAPI(req *ElasticsearchRequest, method string, segments []string, body []byte) {
+	api := segments[0]
+	req.API = api
+
+	switch {
+	case api == "_search" && len(segments) > 1 && segments[1] == "scroll":
+		//scroll continuation carries no index, the scroll was checked when it was opened
+		req.Privilege = "read"
+	case api == "_async_search" && len(segments) > 1:
+		//fetch or delete an existing async search by id
+		req.Privilege = "read"
+	case readAPIs[api]:
+		req.Privilege = "read"
+		switch api {
+		case "_mget":
+			parseMgetIndices(req, body, "")
+		case "_msearch":
+			parseMsearchIndices(req, body, "")
+		default:
+			req.addIndices([]string{"_all"}, "read")
+		}
+	case queryLanguageAPIs[api]:
+		req.Privilege = "read"
+		req.addIndices([]string{"_all"}, "read")
+	case api == "_bulk":
+		req.Privilege = "write"
+		parseBulkIndices(req, body, "")
+	case api == "_reindex":
+		req.Privilege = "write"
+		parseReindexIndices(req, body)
+	case indexMonitorAPIs[api]:
+		req.Privilege = "monitor"
+		req.addIndices([]string{"_all"}, "monitor")
+	case indexMetadataAPIs[api]:
+		if method == "GET" || method == "HEAD" {
+			req.Privilege = "view_index_metadata"
+			req.addIndices([]string{"_all"}, req.Privilege)
+		} else {
+			req.Privilege = "manage"
+			parseAliasesIndices(req, body)
+		}
+	case api == "_refresh" || api == "_flush" || api == "_forcemerge" || api == "_cache":
+		req.Privilege = "manage"
+		req.addIndices([]string{"_all"}, "manage")
+	case api == "_template" || api == "_index_template" || api == "_component_template":
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage_index_templates")
+	case api == "_ingest":
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage_pipeline")
+	case api == "_ilm":
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage_ilm")
+	case api == "_snapshot" || api == "_slm":
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage_snapshot")
+	case api == "_security":
+		req.Cluster = true
+		req.Privilege = "manage_security"
+	case api == "_cat":
+		req.Cluster = true
+		req.Privilege = "monitor"
+	case clusterMonitorAPIs[api]:
+		req.Cluster = true
+		req.Privilege = clusterPrivilegeByMethod(method, "manage")
+	default:
+		//unknown apis are never granted by the monitor privilege
+		req.Cluster = true
+		req.Privilege = "manage"
+	}
+}
+
+// IsWildcardExpression checks if the index expression is expanded to the matched indices
+func IsWildcardExpression(index string) bool {
+	return index == "_all" || strings.Contains(index, "*")
+}
+
+// ExpandsHiddenIndices checks if the `expand_wildcards` parameter expands the wildcards to the hidden indices,
+// which include the system and the `.` prefixed indices
+func ExpandsHiddenIndices(expandWildcards string) bool {
+	for _, v := range strings.Split(expandWildcards, ",") {
+		v = strings.TrimSpace(v)
+		if v == "all" || v == "hidden" {
+			return true
+		}
+	}
+	return false
+}
+
+// isIndexExpression checks if the first segment starting with `_` is an index expression, eg: `_all`, `_*`, `_all,logs`
+func isIndexExpression(str string) bool {
+	if strings.ContainsAny(str, "*,") {
+		return true
+	}
+	return str == "_all"
+}
+
+func clusterPrivilegeByMethod(method, privilege string) string {
+	if method == "GET" || method == "HEAD" {
+		return "monitor"
+	}
+	return privilege
+}
+
+func parseIndexExpression(str string) []string {
+	var indices []string
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		//exclusions never grant access to anything
+		if v == "" || strings.HasPrefix(v, "-") {
+			continue
+		}
+		indices = append(indices, v)
+	}
+	return indices
+}
+
+func firstOrEmpty(indices []string) string {
+	if len(indices) > 0 {
+		return indices[0]
+	}
+	return ""
+}
+
+func (req *ElasticsearchRequest) addIndices(indices []string, privilege string) {
+	for _, v := range indices {
+		req.Indices = append(req.Indices, IndexAccess{Index: v, Privilege: privilege})
+	}
+}
+
+func (req *ElasticsearchRequest) addIndex(index, defaultIndex, privilege string) {
+	if index == "" {
+		index = defaultIndex
+	}
+	if index == "" {
+		index = "_all"
+	}
+	req.Indices = append(req.Indices, IndexAccess{Index: index, Privilege: privilege})
+}
+
+var bulkPrivileges = map[string]string{
+	"index":  "index",
+	"create": "create_doc",
+	"update": "index",
+	"delete": "delete",
+}
+
+func parseBulkIndices(req *ElasticsearchRequest, body []byte, defaultIndex string) {
+	expectSource := false
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if expectSource {
+			expectSource = false
+			continue
+		}
+		jsonparser.ObjectEach(line, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+			action := string(key)
+			privilege, ok := bulkPrivileges[action]
+			if !ok {
+				privilege = "write"
+			}
+			index, _ := jsonparser.GetString(value, "_index")
+			req.addIndex(index, defaultIndex, privilege)
+			expectSource = action != "delete"
+			return nil
+		})
+	}
+}
+
+func parseMsearchIndices(req *ElasticsearchRequest, body []byte, defaultIndex string) {
+	header := true
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if header {
+			indices := getStringOrArray(line, "index")
+			if len(indices) == 0 {
+				req.addIndex("", defaultIndex, "read")
+			}
+			for _, v := range indices {
+				req.addIndices(parseIndexExpression(v), "read")
+			}
+		}
+		header = !header
+	}
+}
+
+func parseMgetIndices(req *ElasticsearchRequest, body []byte, defaultIndex string) {
+	hasDocs := false
+	jsonparser.ArrayEach(body, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		hasDocs = true
+		index, _ := jsonparser.GetString(value, "_index")
+		req.addIndex(index, defaultIndex, "read")
+	}, "docs")
+	if !hasDocs && defaultIndex == "" {
+		req.addIndex("", "", "read")
+	}
+}
+
+func parseReindexIndices(req *ElasticsearchRequest, body []byte) {
+	for _, v := range getStringOrArray(body, "source", "index") {
+		req.addIndices(parseIndexExpression(v), "read")
+	}
+	dest, _ := jsonparser.GetString(body, "dest", "index")
+	req.addIndex(dest, "", "index")
+}
+
+func parseAliasesIndices(req *ElasticsearchRequest, body []byte) {
+	found := false
+	jsonparser.ArrayEach(body, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
+		jsonparser.ObjectEach(value, func(key []byte, action []byte, dataType jsonparser.ValueType, offset int) error {
+			for _, field := range []string{"index", "indices"} {
+				for _, v := range getStringOrArray(action, field) {
+					found = true
+					req.addIndices(parseIndexExpression(v), "manage")
+				}
+			}
+			return nil
+		})
+	}, "actions")
+	if !found {
+		req.addIndex("", "", "manage")
+	}
+}
+
+func getStringOrArray(data []byte, keys ...string) []string {
+	value, dataType, _, err := jsonparser.Get(data, keys...)
+	if err != nil {
+		return nil
+	}
+	switch dataType {
+	case jsonparser.String:
+		return []string{string(value)}
+	case jsonparser.Array:
+		var result []string
+		jsonparser.ArrayEach(value, func(v []byte, t jsonparser.ValueType, offset int, err error) {
+			if t == jsonparser.String {
+				result = append(result, string(v))
+			}
+		})
+		return result
+	}
+	return nil
+}
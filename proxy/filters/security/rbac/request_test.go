@@ -0,0 +1,146 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseClusterRequest(t *testing.T) {
+	req := ParseElasticsearchRequest("GET", "/", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "monitor", req.Privilege)
+
+	req = ParseElasticsearchRequest("GET", "/_cluster/health?pretty", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "monitor", req.Privilege)
+
+	req = ParseElasticsearchRequest("PUT", "/_cluster/settings", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "manage", req.Privilege)
+
+	req = ParseElasticsearchRequest("PUT", "/_index_template/logs", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "manage_index_templates", req.Privilege)
+
+	req = ParseElasticsearchRequest("GET", "/_cat/indices/logs-*", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "monitor", req.Privilege)
+
+	//unknown apis require the manage privilege
+	req = ParseElasticsearchRequest("GET", "/_unknown_api", nil)
+	assert.Equal(t, true, req.Cluster)
+	assert.Equal(t, "manage", req.Privilege)
+}
+
+func TestParseWildcardIndexRequest(t *testing.T) {
+	req := ParseElasticsearchRequest("GET", "/_all/_search", nil)
+	assert.Equal(t, false, req.Cluster)
+	assert.Equal(t, []IndexAccess{{"_all", "read"}}, req.Indices)
+
+	req = ParseElasticsearchRequest("GET", "/_*/_doc/1", nil)
+	assert.Equal(t, false, req.Cluster)
+	assert.Equal(t, []IndexAccess{{"_*", "read"}}, req.Indices)
+
+	req = ParseElasticsearchRequest("DELETE", "/_all", nil)
+	assert.Equal(t, false, req.Cluster)
+	assert.Equal(t, []IndexAccess{{"_all", "delete_index"}}, req.Indices)
+
+	for _, path := range []string{"/_sql", "/_query", "/_eql/search/id", "/_knn_search"} {
+		req = ParseElasticsearchRequest("GET", path, nil)
+		assert.Equal(t, false, req.Cluster, path)
+		assert.Equal(t, []IndexAccess{{"_all", "read"}}, req.Indices, path)
+	}
+}
+
+func TestParseIndexRequest(t *testing.T) {
+	req := ParseElasticsearchRequest("POST", "/logs-a,logs-b,-logs-c/_search", nil)
+	assert.Equal(t, false, req.Cluster)
+	assert.Equal(t, "_search", req.API)
+	assert.Equal(t, []string{"logs-a", "logs-b"}, req.GetIndices())
+	assert.Equal(t, "read", req.Indices[0].Privilege)
+
+	req = ParseElasticsearchRequest("GET", "/_search", nil)
+	assert.Equal(t, []string{"_all"}, req.GetIndices())
+
+	req = ParseElasticsearchRequest("PUT", "/index/_doc/1", nil)
+	assert.Equal(t, "index", req.Privilege)
+
+	req = ParseElasticsearchRequest("GET", "/index/doc/1", nil)
+	assert.Equal(t, "_doc", req.API)
+	assert.Equal(t, "read", req.Privilege)
+
+	req = ParseElasticsearchRequest("DELETE", "/index/_doc/1", nil)
+	assert.Equal(t, "delete", req.Privilege)
+
+	req = ParseElasticsearchRequest("DELETE", "/index", nil)
+	assert.Equal(t, "delete_index", req.Privilege)
+
+	req = ParseElasticsearchRequest("GET", "/index/_mapping", nil)
+	assert.Equal(t, "view_index_metadata", req.Privilege)
+
+	req = ParseElasticsearchRequest("PUT", "/index/_mapping", nil)
+	assert.Equal(t, "manage", req.Privilege)
+
+	req = ParseElasticsearchRequest("POST", "/_search/scroll", nil)
+	assert.Equal(t, false, req.Cluster)
+	assert.Equal(t, 0, len(req.Indices))
+}
+
+func TestParseBodyIndices(t *testing.T) {
+	bulk := []byte("{\"index\":{\"_index\":\"a\"}}\n{\"f\":1}\n{\"delete\":{\"_index\":\"b\",\"_id\":\"1\"}}\n{\"create\":{\"_id\":\"2\"}}\n{\"f\":2}\n")
+	req := ParseElasticsearchRequest("POST", "/_bulk", bulk)
+	assert.Equal(t, []IndexAccess{{"a", "index"}, {"b", "delete"}, {"_all", "create_doc"}}, req.Indices)
+
+	req = ParseElasticsearchRequest("POST", "/default/_bulk", bulk)
+	assert.Equal(t, IndexAccess{"default", "create_doc"}, req.Indices[2])
+
+	msearch := []byte("{\"index\":\"a\"}\n{\"query\":{\"match_all\":{}}}\n{}\n{\"query\":{\"match_all\":{}}}\n{\"index\":[\"b\",\"c\"]}\n{}\n")
+	req = ParseElasticsearchRequest("POST", "/_msearch", msearch)
+	assert.Equal(t, []string{"a", "_all", "b", "c"}, req.GetIndices())
+
+	mget := []byte("{\"docs\":[{\"_index\":\"a\",\"_id\":\"1\"},{\"_id\":\"2\"}]}")
+	req = ParseElasticsearchRequest("POST", "/x/_mget", mget)
+	assert.Equal(t, []string{"x", "a"}, req.GetIndices())
+
+	reindex := []byte("{\"source\":{\"index\":[\"a\",\"b\"]},\"dest\":{\"index\":\"c\"}}")
+	req = ParseElasticsearchRequest("POST", "/_reindex", reindex)
+	assert.Equal(t, []IndexAccess{{"a", "read"}, {"b", "read"}, {"c", "index"}}, req.Indices)
+}
+
+func TestExpandsHiddenIndices(t *testing.T) {
+	assert.True(t, ExpandsHiddenIndices("all"))
+	assert.True(t, ExpandsHiddenIndices("open,hidden"))
+	assert.True(t, ExpandsHiddenIndices("open, all"))
+	assert.False(t, ExpandsHiddenIndices("open,closed"))
+	assert.False(t, ExpandsHiddenIndices(""))
+
+	assert.True(t, IsWildcardExpression("_all"))
+	assert.True(t, IsWildcardExpression("logs-*"))
+	assert.False(t, IsWildcardExpression("logs"))
+}
@@ -0,0 +1,183 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+type RoleAuthorization struct {
+	UserRoles    map[string][]string `config:"user_roles"`
+	APIKeyRoles  map[string][]string `config:"api_key_roles"`
+	DefaultRoles []string            `config:"default_roles"`
+	Status       int                 `config:"status"`
+}
+
+func (filter *RoleAuthorization) Name() string {
+	return "role_authorization"
+}
+
+func init() {
+	pipeline.RegisterFilterPluginWithConfigMetadata("role_authorization", NewRoleAuthorization, &RoleAuthorization{})
+}
+
+func NewRoleAuthorization(c *config.Config) (pipeline.Filter, error) {
+
+	runner := RoleAuthorization{
+		Status: 403,
+	}
+
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
+	}
+
+	return &runner, nil
+}
+
+func (filter *RoleAuthorization) Filter(ctx *fasthttp.RequestCtx) {
+
+	user, roleNames := filter.ResolveRoles(ctx)
+	roles := common.GetRoles(roleNames)
+
+	//share the resolved identity with downstream filters, eg: document_level_security
+	if len(common.GetUserRoles(ctx)) == 0 && len(roleNames) > 0 {
+		ctx.Set(common.UserRolesKey, roleNames)
+	}
+	if common.GetUserName(ctx) == "" && user != "" {
+		ctx.Set(common.UserNameKey, user)
+	}
+
+	if global.Env().IsDebug {
+		log.Tracef("user [%v] with roles [%v], %v roles resolved", user, roleNames, len(roles))
+	}
+
+	method := string(ctx.Method())
+	path := string(ctx.PhantomURI().Path())
+
+	req := ParseElasticsearchRequest(method, path, ctx.Request.Body())
+
+	if req.Cluster {
+		for _, role := range roles {
+			if role.IsClusterActionPermitted(req.Privilege, path) {
+				return
+			}
+		}
+		filter.deny(ctx, fmt.Sprintf("action [%v %v] requires cluster privilege [%v], is unauthorized for user [%v] with roles [%v]",
+			method, path, req.Privilege, user, strings.Join(roleNames, ",")))
+		return
+	}
+
+	//requests carrying no index, eg: scroll continuation, only need the privilege on any index
+	if len(req.Indices) == 0 {
+		for _, role := range roles {
+			for _, perm := range role.Indices {
+				if perm.HasPrivilege(req.Privilege) {
+					return
+				}
+			}
+		}
+		filter.deny(ctx, fmt.Sprintf("action [%v %v] requires index privilege [%v], is unauthorized for user [%v] with roles [%v]",
+			method, path, req.Privilege, user, strings.Join(roleNames, ",")))
+		return
+	}
+
+	//the wildcards expanded to the hidden indices are only permitted for the roles which allow the restricted indices
+	expandHidden := ExpandsHiddenIndices(string(ctx.PhantomURI().QueryArgs().Peek("expand_wildcards")))
+	for _, item := range req.Indices {
+		permitted := false
+		for _, role := range roles {
+			if expandHidden && IsWildcardExpression(item.Index) {
+				permitted = role.IsRestrictedIndexActionPermitted(item.Privilege, item.Index)
+			} else {
+				permitted = role.IsIndexActionPermitted(item.Privilege, item.Index)
+			}
+			if permitted {
+				break
+			}
+		}
+		if !permitted {
+			filter.deny(ctx, fmt.Sprintf("action [%v %v] requires index privilege [%v] on [%v], is unauthorized for user [%v] with roles [%v]",
+				method, path, item.Privilege, item.Index, user, strings.Join(roleNames, ",")))
+			return
+		}
+	}
+}
+
+// ResolveRoles returns the user and the role names from the request context,
+// which were placed by ldap_auth, basic_auth or other authentication filters,
+// or falls back to the static mappings of this filter, only the identities verified
+// by the authentication filters are mapped, the credentials of the request are never trusted
+func (filter *RoleAuthorization) ResolveRoles(ctx *fasthttp.RequestCtx) (string, []string) {
+	user := common.GetUserName(ctx)
+	roles := common.GetUserRoles(ctx)
+	if len(roles) > 0 {
+		return user, roles
+	}
+
+	if user != "" {
+		if v, ok := filter.UserRoles[user]; ok {
+			return user, v
+		}
+	}
+
+	if id := common.GetUserID(ctx); id != "" {
+		if v, ok := filter.APIKeyRoles[id]; ok {
+			if user == "" {
+				user = id
+			}
+			return user, v
+		}
+	}
+
+	return user, filter.DefaultRoles
+}
+
+func (filter *RoleAuthorization) deny(ctx *fasthttp.RequestCtx, reason string) {
+	if global.Env().IsDebug {
+		log.Debug(reason)
+	}
+	ctx.SetDestination("filtered")
+	ctx.SetContentType(util.ContentTypeJson)
+	ctx.Response.SetStatusCode(filter.Status)
+	ctx.Response.SetBody(util.MustToJSONBytes(util.MapStr{
+		"error": util.MapStr{
+			"type":   "security_exception",
+			"reason": reason,
+		},
+		"status": filter.Status,
+	}))
+	ctx.Finished()
+}
@@ -30,6 +30,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type RequestClientIPLimitFilter struct {
@@ -43,7 +44,7 @@ func init() {
 
 func NewRequestClientIPLimitFilter(c *config.Config) (pipeline.Filter, error) {
 
-	runner := RequestHostLimitFilter{}
+	runner := RequestClientIPLimitFilter{}
 
 	if err := c.Unpack(&runner); err != nil {
 		return nil, fmt.Errorf("failed to unpack the filter configuration : %s", err)
@@ -67,7 +68,7 @@ func (filter *RequestClientIPLimitFilter) Name() string {
 
 func (filter *RequestClientIPLimitFilter) Filter(ctx *fasthttp.RequestCtx) {
 
-	clientIP := ctx.RemoteIP().String()
+	clientIP := common.GetClientIP(ctx).String()
 
 	if global.Env().IsDebug {
 		log.Trace("ips rules: ", len(filter.IP), ", client_ip: ", clientIP)
@@ -30,6 +30,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type RequestUserLimitFilter struct {
@@ -67,15 +68,18 @@ func (filter *RequestUserLimitFilter) Name() string {
 
 func (filter *RequestUserLimitFilter) Filter(ctx *fasthttp.RequestCtx) {
 
-	exists, user, _ := ctx.Request.ParseBasicAuth()
-	if !exists {
-		if global.Env().IsDebug {
-			log.Tracef("user not exist")
+	//prefer the user resolved by the authentication filters
+	userStr := common.GetUserName(ctx)
+	if userStr == "" {
+		exists, user, _ := ctx.Request.ParseBasicAuth()
+		if !exists {
+			if global.Env().IsDebug {
+				log.Tracef("user not exist")
+			}
+			return
 		}
-		return
+		userStr = string(user)
 	}
-
-	userStr := string(user)
 	if global.Env().IsDebug {
 		log.Trace("user rules: ", len(filter.User), ", user: ", userStr)
 	}
@@ -25,6 +25,7 @@ package proxy
 
 import (
 	"runtime"
+	"sync"
 
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/api"
@@ -95,7 +96,6 @@ func (module *GatewayModule) handleConfigureChange() {
 		}()
 
 		if cCfg != nil {
-			//TODO diff previous and current config
 			newConfig := []common.FlowConfig{}
 			err := cCfg.Unpack(&newConfig)
 			if err != nil {
@@ -103,25 +103,26 @@ func (module *GatewayModule) handleConfigureChange() {
 				return
 			}
 
-			for _, v := range newConfig {
-				common.RegisterFlowConfig(v)
+			changed := common.RegisterFlowConfigs(newConfig)
+			if len(changed) == 0 {
+				log.Debug("no flow changed")
+				return
 			}
+			log.Debug("flows changed: ", changed)
 
-			//just in case
-			for _, v := range module.entryPoints {
-				v.RefreshDefaultFlow()
-				v.RefreshTracingFlow()
+			flows := map[string]bool{}
+			for _, v := range changed {
+				flows[v] = true
 			}
 
-			//修改完Flow，需要重启服务入口
+			//only the entries using the changed flows are reloaded, the listeners keep serving
 			for _, v := range module.entryPoints {
-				//TODO skip unnecessary restart
-				log.Trace("stopping ", v.GetNameOrID())
-				v.Stop()
-				log.Trace("stopped ", v.GetNameOrID())
-				v.Start()
-				log.Trace("started ", v.GetNameOrID())
-
+				if !v.UsesFlows(flows) {
+					continue
+				}
+				if err := v.Reload(); err != nil {
+					log.Error(err)
+				}
 			}
 		}
 	})
@@ -152,27 +153,73 @@ func (module *GatewayModule) handleConfigureChange() {
 				return
 			}
 
-			keys := map[string]string{}
+			changed := map[string]bool{}
+			restart := map[string]bool{}
 			for _, v := range newConfig {
 				if v.ID == "" && v.Name != "" {
 					v.ID = v.Name
 				}
 
-				keys[v.ID] = v.ID
+				old, ok := common.GetRouterConfig(v.ID)
+				if ok && old.Equals(&v) {
+					continue
+				}
+				changed[v.ID] = true
+				//the ip access rules are applied to the listener
+				if ok && util.MustToJSON(old.IPAccessRules) != util.MustToJSON(v.IPAccessRules) {
+					restart[v.ID] = true
+				}
 				common.RegisterRouterConfig(v)
 			}
 
-			//修改完路由，需要重启服务入口
 			for _, v := range module.entryPoints {
-				_, ok := keys[v.GetConfig().RouterConfigName]
-				if ok {
+				name := v.GetConfig().RouterConfigName
+				if !changed[name] {
+					continue
+				}
+				if restart[name] {
 					v.Stop()
 					v.Start()
+					continue
+				}
+				if err := v.Reload(); err != nil {
+					log.Error(err)
 				}
 			}
 		}
 	})
 
+	NotifyOnConfigSectionChange("role", func(pCfg, cCfg *Config) {
+		newConfig := []common.Role{}
+		if cCfg != nil {
+			err := cCfg.Unpack(&newConfig)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+
+		//roles are looked up on each request, no need to restart entries
+		common.RegisterRoles(newConfig)
+	})
+
+	NotifyOnConfigSectionChange("role_mapping", func(pCfg, cCfg *Config) {
+		//the mappings are cleared when the section is removed
+		newConfig := []common.RoleMapping{}
+		if cCfg != nil {
+			err := cCfg.Unpack(&newConfig)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+
+		err := common.RegisterRoleMappings(newConfig)
+		if err != nil {
+			log.Error("error on apply role mapping change,", err)
+		}
+	})
+
 	NotifyOnConfigSectionChange("entry", func(pCfg, cCfg *Config) {
 
 		defer func() {
@@ -260,6 +307,8 @@ func (module *GatewayModule) loadEntryPoints() map[string]*entry.Entrypoint {
 	routerConfigs := []common.RouterConfig{}
 	flowConfigs := []common.FlowConfig{}
 	entryConfigs := []common.EntryConfig{}
+	roleConfigs := []common.Role{}
+	roleMappingConfigs := []common.RoleMapping{}
 
 	ok, err := env.ParseConfig("gateway", &module)
 	if ok && err != nil  &&global.Env().SystemConfig.Configs.PanicOnConfigError{
@@ -295,6 +344,30 @@ func (module *GatewayModule) loadEntryPoints() map[string]*entry.Entrypoint {
 		}
 	}
 
+	ok, err = env.ParseConfig("role", &roleConfigs)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+
+	if ok {
+		common.RegisterRoles(roleConfigs)
+	}
+
+	ok, err = env.ParseConfig("role_mapping", &roleMappingConfigs)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+
+	if ok {
+		err = common.RegisterRoleMappings(roleMappingConfigs)
+		if err != nil {
+			if global.Env().SystemConfig.Configs.PanicOnConfigError {
+				panic(err)
+			}
+			log.Error("error on apply role mapping,", err)
+		}
+	}
+
 	log.Trace("num of entry configs:", len(entryConfigs))
 	entryPoints := map[string]*entry.Entrypoint{}
 	for _, v := range entryConfigs {
@@ -330,11 +403,23 @@ func (module *GatewayModule) Start() error {
 
 func (module *GatewayModule) Stop() error {
 
+	//stop the entries in parallel, so the draining entries share the same deadline
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(module.entryPoints))
 	for _, v := range module.entryPoints {
-		err := v.Stop()
-		if err != nil {
-			panic(err)
-		}
+		wg.Add(1)
+		go func(e *entry.Entrypoint) {
+			defer wg.Done()
+			if err := e.Stop(); err != nil {
+				errs <- err
+			}
+		}(v)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		panic(err)
 	}
 
 	return nil
@@ -0,0 +1,379 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+type CircuitBreakerConfig struct {
+	Enabled bool `config:"enabled"`
+	// open after the consecutive connection errors or `502`, `503`, `504` responses, `0` to disable
+	ConsecutiveFailures int `config:"consecutive_failures"`
+	// open after the consecutive `429` rejections, `0` to disable
+	ConsecutiveRejections int `config:"consecutive_rejections"`
+	// open when the ratio of the failures in the window reaches the ratio, `0` to disable
+	ErrorRatio  float64 `config:"error_ratio"`
+	MinRequests int     `config:"min_requests"`
+	Window      string  `config:"window"`
+	// the node is ejected for the back-off, which is doubled each time the probes fail
+	Backoff    string `config:"backoff"`
+	MaxBackoff string `config:"max_backoff"`
+	// the successful probes to close the breaker
+	HalfOpenRequests int `config:"half_open_requests"`
+	// the max percent of the nodes to be ejected
+	MaxEjectionPercent int `config:"max_ejection_percent"`
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerState is the state of the circuit breaker of a node
+type BreakerState struct {
+	Endpoint              string     `json:"endpoint"`
+	State                 string     `json:"state"`
+	ConsecutiveFailures   int        `json:"consecutive_failures"`
+	ConsecutiveRejections int        `json:"consecutive_rejections"`
+	Trips                 int        `json:"trips"`
+	OpenedAt              *time.Time `json:"opened_at,omitempty"`
+	RetryAt               *time.Time `json:"retry_at,omitempty"`
+}
+
+type circuitBreaker struct {
+	endpoint              string
+	state                 string
+	consecutiveFailures   int
+	consecutiveRejections int
+	windowStart           time.Time
+	windowRequests        int
+	windowFailures        int
+	trips                 int
+	openedAt              time.Time
+	retryAt               time.Time
+	probing               bool
+	probeSuccesses        int
+}
+
+// CircuitBreakers tracks the circuit breakers of the nodes of a cluster, shared by the elasticsearch filters
+type CircuitBreakers struct {
+	cluster    string
+	config     *CircuitBreakerConfig
+	window     time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+	//bumped when a node is ejected or recovered
+	version int64
+	//unix nano of the earliest open breaker to be half-open, 0 if none
+	nextRetry int64
+	//the number of the half-open breakers
+	halfOpen int64
+}
+
+var circuitBreakers = sync.Map{}
+
+// getCircuitBreakers returns the circuit breakers of the cluster, the config of the first filter is used
+func getCircuitBreakers(cluster string, cfg *CircuitBreakerConfig) *CircuitBreakers {
+	v, ok := circuitBreakers.Load(cluster)
+	if ok {
+		return v.(*CircuitBreakers)
+	}
+	v, loaded := circuitBreakers.LoadOrStore(cluster, NewCircuitBreakers(cluster, cfg))
+	breakers := v.(*CircuitBreakers)
+	if !loaded {
+		stats.RegisterStats(fmt.Sprintf("elasticsearch.%v.circuit_breakers", cluster), func() interface{} {
+			return breakers.States()
+		})
+	}
+	return breakers
+}
+
+// GetCircuitBreakerStates returns the states of the circuit breakers of the clusters
+func GetCircuitBreakerStates() map[string][]BreakerState {
+	states := map[string][]BreakerState{}
+	circuitBreakers.Range(func(key, value interface{}) bool {
+		states[key.(string)] = value.(*CircuitBreakers).States()
+		return true
+	})
+	return states
+}
+
+func NewCircuitBreakers(cluster string, cfg *CircuitBreakerConfig) *CircuitBreakers {
+	breakers := CircuitBreakers{
+		cluster:    cluster,
+		config:     cfg,
+		window:     util.GetDurationOrDefault(cfg.Window, 10*time.Second),
+		backoff:    util.GetDurationOrDefault(cfg.Backoff, 30*time.Second),
+		maxBackoff: util.GetDurationOrDefault(cfg.MaxBackoff, 5*time.Minute),
+		breakers:   map[string]*circuitBreaker{},
+	}
+	if breakers.maxBackoff < breakers.backoff {
+		breakers.maxBackoff = breakers.backoff
+	}
+	return &breakers
+}
+
+func (this *CircuitBreakers) get(endpoint string) *circuitBreaker {
+	b, ok := this.breakers[endpoint]
+	if !ok {
+		b = &circuitBreaker{endpoint: endpoint, state: BreakerClosed}
+		this.breakers[endpoint] = b
+	}
+	return b
+}
+
+// Version returns the version of the ejected nodes, the open breakers are turned to half-open after the back-off
+func (this *CircuitBreakers) Version() int64 {
+	next := atomic.LoadInt64(&this.nextRetry)
+	if next > 0 && time.Now().UnixNano() >= next {
+		this.lock.Lock()
+		this.sweep(time.Now())
+		this.lock.Unlock()
+	}
+	return atomic.LoadInt64(&this.version)
+}
+
+func (this *CircuitBreakers) sweep(now time.Time) {
+	var next int64
+	for _, b := range this.breakers {
+		if b.state != BreakerOpen {
+			continue
+		}
+		if !now.Before(b.retryAt) {
+			b.state = BreakerHalfOpen
+			b.probing = false
+			b.probeSuccesses = 0
+			atomic.AddInt64(&this.halfOpen, 1)
+			log.Infof("circuit breaker of [%v][%v] is half-open, probing", this.cluster, b.endpoint)
+			continue
+		}
+		if next == 0 || b.retryAt.UnixNano() < next {
+			next = b.retryAt.UnixNano()
+		}
+	}
+	atomic.StoreInt64(&this.nextRetry, next)
+}
+
+// Available returns the nodes to be balanced, the open and half-open nodes are ejected, up to `max_ejection_percent`
+func (this *CircuitBreakers) Available(endpoints []string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	maxEjection := len(endpoints) * this.config.MaxEjectionPercent / 100
+	ejected := []*circuitBreaker{}
+	for _, endpoint := range endpoints {
+		if b, ok := this.breakers[endpoint]; ok && b.state != BreakerClosed {
+			ejected = append(ejected, b)
+		}
+	}
+	if len(ejected) == 0 {
+		return endpoints
+	}
+
+	//the nodes opened earlier are ejected first
+	sort.SliceStable(ejected, func(i, j int) bool {
+		return ejected[i].openedAt.Before(ejected[j].openedAt)
+	})
+	if len(ejected) > maxEjection {
+		ejected = ejected[:maxEjection]
+	}
+	excluded := map[string]bool{}
+	for _, b := range ejected {
+		excluded[b.endpoint] = true
+	}
+
+	available := []string{}
+	for _, endpoint := range endpoints {
+		if !excluded[endpoint] {
+			available = append(available, endpoint)
+		}
+	}
+	return available
+}
+
+// AcquireProbe picks a half-open node of the candidates which is not being probed, the request is sent to it as a probe
+func (this *CircuitBreakers) AcquireProbe(candidates []string) (string, bool) {
+	if atomic.LoadInt64(&this.halfOpen) == 0 {
+		return "", false
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, endpoint := range candidates {
+		b, ok := this.breakers[endpoint]
+		if ok && b.state == BreakerHalfOpen && !b.probing {
+			b.probing = true
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+// ReleaseProbe releases the probe which is not sent to the node
+func (this *CircuitBreakers) ReleaseProbe(endpoint string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if b, ok := this.breakers[endpoint]; ok && b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// Report records the result of the request sent to the node
+func (this *CircuitBreakers) Report(endpoint string, probe, failure, rejection bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := time.Now()
+	b := this.get(endpoint)
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if !probe {
+			return
+		}
+		b.probing = false
+		if failure || rejection {
+			atomic.AddInt64(&this.halfOpen, -1)
+			this.open(b, now, "probe failed")
+			return
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= this.config.HalfOpenRequests {
+			atomic.AddInt64(&this.halfOpen, -1)
+			this.close(b)
+		}
+		return
+	case BreakerOpen:
+		return
+	}
+
+	if now.Sub(b.windowStart) > this.window {
+		b.windowStart = now
+		b.windowRequests = 0
+		b.windowFailures = 0
+	}
+	b.windowRequests++
+
+	if failure {
+		b.consecutiveFailures++
+		b.windowFailures++
+	} else {
+		b.consecutiveFailures = 0
+	}
+	if rejection {
+		b.consecutiveRejections++
+	} else {
+		b.consecutiveRejections = 0
+	}
+
+	cfg := this.config
+	if cfg.ConsecutiveFailures > 0 && b.consecutiveFailures >= cfg.ConsecutiveFailures {
+		this.open(b, now, fmt.Sprintf("%v consecutive failures", b.consecutiveFailures))
+	} else if cfg.ConsecutiveRejections > 0 && b.consecutiveRejections >= cfg.ConsecutiveRejections {
+		this.open(b, now, fmt.Sprintf("%v consecutive rejections", b.consecutiveRejections))
+	} else if cfg.ErrorRatio > 0 && b.windowRequests >= cfg.MinRequests &&
+		float64(b.windowFailures)/float64(b.windowRequests) >= cfg.ErrorRatio {
+		this.open(b, now, fmt.Sprintf("%v of %v requests failed", b.windowFailures, b.windowRequests))
+	}
+}
+
+func (this *CircuitBreakers) open(b *circuitBreaker, now time.Time, reason string) {
+	b.trips++
+	backoff := this.backoff
+	for i := 1; i < b.trips && backoff < this.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > this.maxBackoff {
+		backoff = this.maxBackoff
+	}
+
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.retryAt = now.Add(backoff)
+	b.probing = false
+	b.consecutiveFailures = 0
+	b.consecutiveRejections = 0
+	b.windowRequests = 0
+	b.windowFailures = 0
+
+	next := atomic.LoadInt64(&this.nextRetry)
+	if next == 0 || b.retryAt.UnixNano() < next {
+		atomic.StoreInt64(&this.nextRetry, b.retryAt.UnixNano())
+	}
+	atomic.AddInt64(&this.version, 1)
+	stats.Increment(fmt.Sprintf("elasticsearch.%v.circuit_breakers", this.cluster), "opened")
+	log.Warnf("circuit breaker of [%v][%v] is open for %v, %v", this.cluster, b.endpoint, backoff, reason)
+}
+
+func (this *CircuitBreakers) close(b *circuitBreaker) {
+	b.state = BreakerClosed
+	b.trips = 0
+	b.probing = false
+	b.windowStart = time.Time{}
+	atomic.AddInt64(&this.version, 1)
+	stats.Increment(fmt.Sprintf("elasticsearch.%v.circuit_breakers", this.cluster), "closed")
+	log.Infof("circuit breaker of [%v][%v] is closed", this.cluster, b.endpoint)
+}
+
+func (this *CircuitBreakers) States() []BreakerState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	states := []BreakerState{}
+	for _, b := range this.breakers {
+		state := BreakerState{
+			Endpoint:              b.endpoint,
+			State:                 b.state,
+			ConsecutiveFailures:   b.consecutiveFailures,
+			ConsecutiveRejections: b.consecutiveRejections,
+			Trips:                 b.trips,
+		}
+		if b.state != BreakerClosed {
+			openedAt, retryAt := b.openedAt, b.retryAt
+			state.OpenedAt = &openedAt
+			state.RetryAt = &retryAt
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Endpoint < states[j].Endpoint
+	})
+	return states
+}
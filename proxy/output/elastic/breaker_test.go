@@ -0,0 +1,147 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreakers() *CircuitBreakers {
+	return NewCircuitBreakers("test", &CircuitBreakerConfig{
+		ConsecutiveFailures:   3,
+		ConsecutiveRejections: 2,
+		ErrorRatio:            0.5,
+		MinRequests:           10,
+		Backoff:               "1s",
+		MaxBackoff:            "3s",
+		HalfOpenRequests:      2,
+		MaxEjectionPercent:    50,
+	})
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	breakers := newTestBreakers()
+	endpoints := []string{"node1:9200", "node2:9200", "node3:9200", "node4:9200"}
+
+	breakers.Report("node1:9200", false, true, false)
+	breakers.Report("node1:9200", false, true, false)
+	breakers.Report("node1:9200", false, false, false)
+	breakers.Report("node1:9200", false, true, false)
+	assert.Equal(t, int64(0), breakers.Version())
+	assert.Equal(t, endpoints, breakers.Available(endpoints))
+
+	breakers.Report("node1:9200", false, true, false)
+	breakers.Report("node1:9200", false, true, false)
+	assert.Equal(t, int64(1), breakers.Version())
+	assert.Equal(t, []string{"node2:9200", "node3:9200", "node4:9200"}, breakers.Available(endpoints))
+
+	//the ejected node is not probed before the back-off
+	_, ok := breakers.AcquireProbe(endpoints)
+	assert.False(t, ok)
+}
+
+func TestCircuitBreakerRejectionsAndErrorRatio(t *testing.T) {
+	breakers := newTestBreakers()
+
+	breakers.Report("node1:9200", false, false, true)
+	breakers.Report("node1:9200", false, false, true)
+	assert.Equal(t, BreakerOpen, breakers.States()[0].State)
+
+	for i := 0; i < 10; i++ {
+		breakers.Report("node2:9200", false, i%2 == 0, false)
+	}
+	states := breakers.States()
+	assert.Equal(t, "node2:9200", states[1].Endpoint)
+	assert.Equal(t, BreakerOpen, states[1].State)
+}
+
+func TestCircuitBreakerMaxEjection(t *testing.T) {
+	breakers := newTestBreakers()
+	endpoints := []string{"node1:9200", "node2:9200", "node3:9200"}
+	for _, endpoint := range endpoints {
+		for i := 0; i < 3; i++ {
+			breakers.Report(endpoint, false, true, false)
+		}
+	}
+	//only one of the three nodes is ejected, the earliest one
+	assert.Equal(t, []string{"node2:9200", "node3:9200"}, breakers.Available(endpoints))
+
+	//a single node is never ejected
+	assert.Equal(t, []string{"node1:9200"}, breakers.Available([]string{"node1:9200"}))
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	breakers := newTestBreakers()
+	endpoints := []string{"node1:9200", "node2:9200"}
+	for i := 0; i < 3; i++ {
+		breakers.Report("node1:9200", false, true, false)
+	}
+
+	//turn to half-open after the back-off
+	breakers.lock.Lock()
+	breakers.breakers["node1:9200"].retryAt = time.Now().Add(-time.Millisecond)
+	breakers.nextRetry = time.Now().UnixNano() - 1
+	breakers.lock.Unlock()
+	breakers.Version()
+	assert.Equal(t, BreakerHalfOpen, breakers.States()[0].State)
+	assert.Equal(t, []string{"node2:9200"}, breakers.Available(endpoints))
+
+	//one probe at a time
+	probe, ok := breakers.AcquireProbe(endpoints)
+	assert.True(t, ok)
+	assert.Equal(t, "node1:9200", probe)
+	_, ok = breakers.AcquireProbe(endpoints)
+	assert.False(t, ok)
+
+	//the failed probe opens the breaker with a longer back-off
+	breakers.Report(probe, true, true, false)
+	state := breakers.States()[0]
+	assert.Equal(t, BreakerOpen, state.State)
+	assert.Equal(t, 2, state.Trips)
+	assert.InDelta(t, float64(2*time.Second), float64(state.RetryAt.Sub(*state.OpenedAt)), float64(time.Millisecond))
+
+	breakers.lock.Lock()
+	breakers.breakers["node1:9200"].retryAt = time.Now().Add(-time.Millisecond)
+	breakers.nextRetry = time.Now().UnixNano() - 1
+	breakers.lock.Unlock()
+	version := breakers.Version()
+
+	//the non-probe requests are ignored, the breaker is closed after the successful probes
+	for i := 0; i < 2; i++ {
+		probe, ok = breakers.AcquireProbe(endpoints)
+		assert.True(t, ok)
+		breakers.Report("node1:9200", false, true, false)
+		breakers.Report(probe, true, false, false)
+	}
+	assert.Equal(t, BreakerClosed, breakers.States()[0].State)
+	assert.Equal(t, 0, breakers.States()[0].Trips)
+	assert.Equal(t, version+1, breakers.Version())
+	assert.Equal(t, endpoints, breakers.Available(endpoints))
+}
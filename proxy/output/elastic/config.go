@@ -59,6 +59,20 @@ type ProxyConfig struct {
 
 	Weights map[string]int `config:"weights"`
 
+	//send the single document requests to the node holding the primary shard
+	ShardRouting bool `config:"shard_routing"`
+
+	//endpoint => unix domain socket, eg: 127.0.0.1:9200 => unix:///var/run/elasticsearch.sock
+	UnixSockets map[string]string `config:"unix_sockets"`
+
+	CircuitBreaker CircuitBreakerConfig `config:"circuit_breaker"`
+
+	//send a second copy of the slow read requests to another node
+	Hedging HedgingConfig `config:"hedging"`
+
+	//fail over to the secondary clusters when the primary cluster is unhealthy
+	Failover FailoverConfig `config:"failover"`
+
 	Refresh struct {
 		Enabled  bool   `config:"enabled"`
 		Interval string `config:"interval"`
@@ -44,6 +44,9 @@ type Elasticsearch struct {
 	config   *ProxyConfig
 	instance *ReverseProxy
 	metadata *elastic.ElasticsearchMetadata
+	failover *FailoverGroup
+	//the proxies of the secondary clusters
+	secondaries map[string]*ReverseProxy
 }
 
 func (filter *Elasticsearch) Name() string {
@@ -62,26 +65,38 @@ func (filter *Elasticsearch) Filter(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	if !filter.config.SkipAvailableCheck && filter.getMetadata() != nil && !filter.getMetadata().IsAvailable() {
+	cluster, metadata, instance := filter.config.Elasticsearch, filter.getMetadata(), filter.instance
+	if filter.failover != nil {
+		active, primary := filter.failover.Active()
+		if !primary {
+			if filter.config.Failover.ReadOnly && !isReadRequest(string(ctx.Method()), string(ctx.Request.PhantomURI().Path())) {
+				filter.failover.Divert(ctx, active)
+				return
+			}
+			cluster, metadata, instance = active, elastic.GetMetadata(active), filter.secondaries[active]
+		}
+	}
+
+	if !filter.config.SkipAvailableCheck && metadata != nil && !metadata.IsAvailable() {
 		if filter.config.CheckClusterHealthWhenNotAvailable {
-			if rate.GetRateLimiter("cluster_check_health", filter.getMetadata().Config.ID, 1, 1, time.Second*1).Allow() {
-				log.Debugf("Elasticsearch [%v] not available", filter.config.Elasticsearch)
-				result, err := elastic.GetClient(filter.getMetadata().Config.Name).ClusterHealth(nil)
+			if rate.GetRateLimiter("cluster_check_health", metadata.Config.ID, 1, 1, time.Second*1).Allow() {
+				log.Debugf("Elasticsearch [%v] not available", cluster)
+				result, err := elastic.GetClient(metadata.Config.Name).ClusterHealth(nil)
 				if err != nil && result != nil && result.StatusCode == 200 {
-					filter.getMetadata().ReportSuccess()
+					metadata.ReportSuccess()
 				}
 			}
 		}
 
 		ctx.SetContentType(util.ContentTypeJson)
-		ctx.Response.SwapBody([]byte(fmt.Sprintf("{\"error\":true,\"message\":\"Elasticsearch [%v] Service Unavailable\"}", filter.config.Elasticsearch)))
+		ctx.Response.SwapBody([]byte(fmt.Sprintf("{\"error\":true,\"message\":\"Elasticsearch [%v] Service Unavailable\"}", cluster)))
 		ctx.SetStatusCode(503)
 		ctx.Finished()
 		return
 	}
 
 	//TODO move clients selection async
-	filter.instance.DelegateRequest(filter.config.Elasticsearch, filter.getMetadata(), ctx)
+	instance.DelegateRequest(cluster, metadata, ctx)
 }
 
 func init() {
@@ -112,6 +127,33 @@ func New(c *config.Config) (pipeline.Filter, error) {
 		WriteTimeout: util.GetDurationOrDefault("0s", 0*time.Hour), //same as read timeout
 		//idle alive connection will be closed
 		MaxIdleConnDuration: util.GetDurationOrDefault("30s", 30*time.Second),
+
+		CircuitBreaker: CircuitBreakerConfig{
+			ConsecutiveFailures: 5,
+			MinRequests:         20,
+			Window:              "10s",
+			Backoff:             "30s",
+			MaxBackoff:          "5m",
+			HalfOpenRequests:    1,
+			MaxEjectionPercent:  50,
+		},
+
+		Hedging: HedgingConfig{
+			APIs:          []string{"_search", "_get", "_mget", "_count"},
+			Percentile:    95,
+			MinDelay:      "5ms",
+			MaxDelay:      "1s",
+			MinSamples:    100,
+			BudgetPercent: 5,
+		},
+
+		Failover: FailoverConfig{
+			Interval:           "5s",
+			UnhealthyThreshold: 3,
+			HealthyThreshold:   5,
+			FailbackDelay:      "1m",
+			UnhealthyStatus:    []string{"red"},
+		},
 	}
 
 	if err := c.Unpack(&cfg); err != nil {
@@ -123,6 +165,14 @@ func New(c *config.Config) (pipeline.Filter, error) {
 
 	runner.instance = NewReverseProxy(&cfg)
 
+	if cfg.Failover.Enabled && len(cfg.Failover.Clusters) > 0 {
+		runner.failover = getFailoverGroup(&cfg)
+		runner.secondaries = map[string]*ReverseProxy{}
+		for _, v := range getSecondaryClusters(&cfg) {
+			runner.secondaries[v] = newSecondaryProxy(&cfg, v)
+		}
+	}
+
 	log.Debugf("init elasticsearch proxy instance: %v", cfg.Elasticsearch)
 
 	return &runner, nil
@@ -0,0 +1,490 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	task2 "infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+type FailoverConfig struct {
+	Enabled bool `config:"enabled"`
+	// the secondary clusters, in the order of priority, the primary cluster is the `elasticsearch`
+	Clusters []string `config:"clusters"`
+	// the interval to check the health of the clusters
+	Interval string `config:"interval"`
+	// the cluster is unhealthy after the consecutive failed checks
+	UnhealthyThreshold int `config:"unhealthy_threshold"`
+	// the cluster is healthy again after the consecutive successful checks
+	HealthyThreshold int `config:"healthy_threshold"`
+	// the min time to stay on a lower priority cluster before failing back
+	FailbackDelay string `config:"failback_delay"`
+	// the cluster health status treated as failed checks
+	UnhealthyStatus []string `config:"unhealthy_status"`
+	// only the read requests fail over, the write requests are sent to the queue
+	ReadOnly bool   `config:"read_only"`
+	Queue    string `config:"queue"`
+}
+
+// FailoverState is the state of a cluster of the failover group
+type FailoverState struct {
+	Cluster              string `json:"cluster"`
+	Priority             int    `json:"priority"`
+	Active               bool   `json:"active"`
+	Healthy              bool   `json:"healthy"`
+	ConsecutiveSuccesses int    `json:"consecutive_successes"`
+	ConsecutiveFailures  int    `json:"consecutive_failures"`
+}
+
+type failoverCluster struct {
+	name                 string
+	healthy              bool
+	consecutiveSuccesses int
+	consecutiveFailures  int
+}
+
+// FailoverGroup elects the healthy cluster with the highest priority, shared by the elasticsearch filters of the same clusters
+type FailoverGroup struct {
+	locker        sync.RWMutex
+	config        *FailoverConfig
+	clusters      []*failoverCluster
+	active        int
+	switchedAt    time.Time
+	failbackDelay time.Duration
+	category      string
+	check         func(cluster string) bool
+}
+
+var failoverGroups = sync.Map{}
+
+// getFailoverGroup returns the failover group of the clusters, the group is started once and the config of the first
+// filter is used, so the health checks are not piled up when the flows are reloaded
+func getFailoverGroup(cfg *ProxyConfig) *FailoverGroup {
+	key := strings.Join(append([]string{cfg.Elasticsearch}, cfg.Failover.Clusters...), ",")
+	v, ok := failoverGroups.Load(key)
+	if ok {
+		return v.(*FailoverGroup)
+	}
+	v, loaded := failoverGroups.LoadOrStore(key, NewFailoverGroup(cfg))
+	group := v.(*FailoverGroup)
+	if !loaded {
+		group.Start()
+	}
+	return group
+}
+
+// NewFailoverGroup creates the failover group of the primary cluster and the secondary clusters
+func NewFailoverGroup(cfg *ProxyConfig) *FailoverGroup {
+	group := FailoverGroup{
+		config:        &cfg.Failover,
+		switchedAt:    time.Now(),
+		failbackDelay: util.GetDurationOrDefault(cfg.Failover.FailbackDelay, time.Minute),
+		category:      fmt.Sprintf("elasticsearch.%v.failover", cfg.Elasticsearch),
+	}
+	group.check = group.checkClusterHealth
+
+	group.clusters = append(group.clusters, &failoverCluster{name: cfg.Elasticsearch, healthy: true})
+	for _, v := range getSecondaryClusters(cfg) {
+		group.clusters = append(group.clusters, &failoverCluster{name: v, healthy: true})
+	}
+	return &group
+}
+
+// getSecondaryClusters returns the secondary clusters without the empty and the primary one
+func getSecondaryClusters(cfg *ProxyConfig) []string {
+	clusters := []string{}
+	for _, v := range cfg.Failover.Clusters {
+		if v == "" || v == cfg.Elasticsearch {
+			continue
+		}
+		clusters = append(clusters, v)
+	}
+	return clusters
+}
+
+// newSecondaryProxy creates the proxy of the secondary cluster, the settings of the primary hosts are not inherited
+func newSecondaryProxy(cfg *ProxyConfig, cluster string) *ReverseProxy {
+	secondary := *cfg
+	secondary.Elasticsearch = cluster
+	secondary.Weights = nil
+	secondary.UnixSockets = nil
+	secondary.Filter.Hosts.Include = nil
+	secondary.Filter.Hosts.Exclude = nil
+	secondary.Failover = FailoverConfig{}
+	return NewReverseProxy(&secondary)
+}
+
+// Start checks the health of the clusters once, then periodically
+func (g *FailoverGroup) Start() {
+	stats.RegisterStats(g.category, func() interface{} {
+		return g.States()
+	})
+
+	//the clusters are assumed healthy until checked, don't wait for the thresholds to find out the unhealthy ones
+	g.InitHealth()
+
+	task := task2.ScheduleTask{
+		Description: fmt.Sprintf("check health of the failover clusters of elasticsearch [%v]", g.clusters[0].name),
+		Type:        "interval",
+		Interval:    g.config.Interval,
+		Task: func(ctx context.Context) {
+			g.CheckHealth()
+		},
+	}
+	task2.RegisterScheduleTask(task)
+}
+
+// checkClusterHealth checks if the cluster is reachable and the health status is acceptable
+func (g *FailoverGroup) checkClusterHealth(cluster string) bool {
+	metadata := elastic.GetMetadata(cluster)
+	if metadata == nil {
+		return false
+	}
+
+	result, err := elastic.GetClient(cluster).ClusterHealth(nil)
+	if err != nil || result == nil || result.StatusCode != 200 {
+		log.Debugf("failed to check health of elasticsearch [%v], %v", cluster, err)
+		return false
+	}
+	for _, v := range g.config.UnhealthyStatus {
+		if strings.EqualFold(v, result.Status) {
+			return false
+		}
+	}
+
+	if !metadata.IsAvailable() {
+		metadata.ReportSuccess()
+	}
+	return true
+}
+
+// CheckHealth checks the health of all the clusters and elects the active cluster
+func (g *FailoverGroup) CheckHealth() {
+	results := make([]bool, len(g.clusters))
+	for i, v := range g.clusters {
+		results[i] = g.check(v.name)
+	}
+
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	for i, v := range g.clusters {
+		g.report(v, results[i])
+	}
+	g.elect()
+}
+
+// InitHealth checks the health of all the clusters and elects the active cluster, the thresholds and the failback
+// delay are not applied to the first check
+func (g *FailoverGroup) InitHealth() {
+	results := make([]bool, len(g.clusters))
+	for i, v := range g.clusters {
+		results[i] = g.check(v.name)
+	}
+
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	for i, v := range g.clusters {
+		v.healthy = results[i]
+		if results[i] {
+			v.consecutiveSuccesses, v.consecutiveFailures = 1, 0
+		} else {
+			v.consecutiveSuccesses, v.consecutiveFailures = 0, 1
+			log.Warnf("elasticsearch [%v] is unhealthy", v.name)
+		}
+	}
+	g.elect()
+}
+
+// report updates the health of the cluster, the thresholds avoid flapping between the clusters
+func (g *FailoverGroup) report(cluster *failoverCluster, success bool) {
+	if success {
+		cluster.consecutiveSuccesses++
+		cluster.consecutiveFailures = 0
+		if !cluster.healthy && cluster.consecutiveSuccesses >= g.config.HealthyThreshold {
+			cluster.healthy = true
+			log.Infof("elasticsearch [%v] is healthy again", cluster.name)
+		}
+		return
+	}
+
+	cluster.consecutiveFailures++
+	cluster.consecutiveSuccesses = 0
+	if cluster.healthy && cluster.consecutiveFailures >= g.config.UnhealthyThreshold {
+		cluster.healthy = false
+		log.Warnf("elasticsearch [%v] is unhealthy after [%v] failed checks", cluster.name, cluster.consecutiveFailures)
+	}
+}
+
+// elect fails over to the next healthy cluster when the active one is unhealthy, and fails back to the healthy cluster
+// with a higher priority after the failback delay, the locker is held
+func (g *FailoverGroup) elect() {
+	target := -1
+	for i, v := range g.clusters {
+		if v.healthy {
+			target = i
+			break
+		}
+	}
+
+	//no healthy cluster, stay on the current one
+	if target < 0 || target == g.active {
+		return
+	}
+
+	if target < g.active && time.Since(g.switchedAt) < g.failbackDelay {
+		return
+	}
+
+	if target > g.active {
+		log.Warnf("elasticsearch [%v] is unhealthy, fail over to [%v]", g.clusters[g.active].name, g.clusters[target].name)
+		stats.Increment(g.category, "failover")
+	} else {
+		log.Infof("elasticsearch [%v] is healthy, fail back from [%v]", g.clusters[target].name, g.clusters[g.active].name)
+		stats.Increment(g.category, "failback")
+	}
+	g.active = target
+	g.switchedAt = time.Now()
+}
+
+// Active returns the name of the active cluster, and whether it's the primary cluster
+func (g *FailoverGroup) Active() (string, bool) {
+	g.locker.RLock()
+	defer g.locker.RUnlock()
+	return g.clusters[g.active].name, g.active == 0
+}
+
+// States returns the states of the clusters of the failover group
+func (g *FailoverGroup) States() []FailoverState {
+	g.locker.RLock()
+	defer g.locker.RUnlock()
+
+	states := make([]FailoverState, 0, len(g.clusters))
+	for i, v := range g.clusters {
+		states = append(states, FailoverState{
+			Cluster:              v.name,
+			Priority:             i,
+			Active:               i == g.active,
+			Healthy:              v.healthy,
+			ConsecutiveSuccesses: v.consecutiveSuccesses,
+			ConsecutiveFailures:  v.consecutiveFailures,
+		})
+	}
+	return states
+}
+
+// the apis which only read the data, the searches, the scroll and the point in time contexts included
+var readOnlyAPIs = map[string]bool{
+	"_search":        true,
+	"_msearch":       true,
+	"_async_search":  true,
+	"_knn_search":    true,
+	"_pit":           true,
+	"_sql":           true,
+	"_eql":           true,
+	"_query":         true,
+	"_count":         true,
+	"_mget":          true,
+	"_explain":       true,
+	"_field_caps":    true,
+	"_validate":      true,
+	"_termvectors":   true,
+	"_mtermvectors":  true,
+	"_analyze":       true,
+	"_render":        true,
+	"_terms_enum":    true,
+	"_rank_eval":     true,
+	"_search_shards": true,
+}
+
+// the apis which write the data or manage the indices and aliases, which can be queued and replayed
+var writeAPIs = map[string]bool{
+	"_bulk":            true,
+	"_doc":             true,
+	"_create":          true,
+	"_update":          true,
+	"_update_by_query": true,
+	"_delete_by_query": true,
+	"_alias":           true,
+	"_aliases":         true,
+	"_mapping":         true,
+	"_settings":        true,
+}
+
+// getAPI returns the index and the first `_` prefixed segment of the path, eg: `_search` of `/index/_search/template`
+func getAPI(path string) (index, api string) {
+	for i, v := range strings.Split(strings.Trim(path, "/"), "/") {
+		if strings.HasPrefix(v, "_") {
+			return index, v
+		}
+		if i == 0 {
+			index = v
+		}
+	}
+	return index, ""
+}
+
+// isReadRequest checks if the request only reads the data, which can be sent to the secondary clusters in read only mode
+func isReadRequest(method, path string) bool {
+	_, api := getAPI(path)
+	switch method {
+	case "GET", "HEAD":
+		return true
+	case "POST", "DELETE":
+		//deleting the scroll, point in time and async search contexts are also reads
+		return readOnlyAPIs[api]
+	}
+	return false
+}
+
+// getWriteAPI returns the write api of the request which can be queued, or empty for the unknown requests,
+// `index` for creating or deleting an index
+func getWriteAPI(method, path string) string {
+	index, api := getAPI(path)
+	if api == "" {
+		if index != "" && (method == "PUT" || method == "DELETE") {
+			return "index"
+		}
+		return ""
+	}
+	if writeAPIs[api] {
+		return api
+	}
+	return ""
+}
+
+// Divert sends the write request to the queue while failing over in read only mode, to be replayed to the primary
+// cluster later, the unknown requests are rejected, as they may not be safe to replay
+func (g *FailoverGroup) Divert(ctx *fasthttp.RequestCtx, cluster string) {
+	ctx.SetContentType(util.ContentTypeJson)
+
+	method, path := string(ctx.Method()), string(ctx.Request.PhantomURI().Path())
+	api := getWriteAPI(method, path)
+	if g.config.Queue == "" || api == "" {
+		ctx.Response.SwapBody([]byte(fmt.Sprintf("{\"error\":true,\"message\":\"Elasticsearch [%v] is read only while failing over\"}", cluster)))
+		ctx.SetStatusCode(503)
+		ctx.Finished()
+		return
+	}
+
+	err := queue.Push(queue.GetOrInitConfig(g.config.Queue), ctx.Request.Encode())
+	if err != nil {
+		log.Errorf("failed to divert request to queue [%v], %v", g.config.Queue, err)
+		ctx.Response.SwapBody([]byte(fmt.Sprintf("{\"error\":true,\"message\":\"failed to divert request to queue [%v]\"}", g.config.Queue)))
+		ctx.SetStatusCode(503)
+		ctx.Finished()
+		return
+	}
+
+	stats.Increment(g.category, "diverted")
+	ctx.SetDestination(fmt.Sprintf("%v:%v", "queue", g.config.Queue))
+	ctx.Response.SwapBody(util.MustToJSONBytes(divertedResponse(method, path, api, ctx.Request.Body())))
+	ctx.SetStatusCode(202)
+	ctx.Finished()
+}
+
+// divertedResponse mirrors the response of the write api, the results are the ones expected after the replay,
+// the ids generated by Elasticsearch are unknown until then
+func divertedResponse(method, path, api string, body []byte) util.MapStr {
+	index, _ := getAPI(path)
+	shards := util.MapStr{"total": 0, "successful": 0, "failed": 0}
+	switch api {
+	case "_bulk":
+		items := []util.MapStr{}
+		lines := bytes.Split(body, []byte("\n"))
+		for i := 0; i < len(lines); i++ {
+			line := bytes.TrimSpace(lines[i])
+			if len(line) == 0 {
+				continue
+			}
+			jsonparser.ObjectEach(line, func(action []byte, meta []byte, dataType jsonparser.ValueType, offset int) error {
+				op := string(action)
+				itemIndex, err := jsonparser.GetString(meta, "_index")
+				if err != nil {
+					itemIndex = index
+				}
+				id, _ := jsonparser.GetString(meta, "_id")
+				items = append(items, util.MapStr{op: util.MapStr{
+					"_index":  itemIndex,
+					"_id":     id,
+					"result":  writeResult(op),
+					"status":  202,
+					"_shards": shards,
+				}})
+				//the source line of the action
+				if op != "delete" {
+					i++
+				}
+				return nil
+			})
+		}
+		return util.MapStr{"took": 0, "errors": false, "items": items}
+	case "_doc", "_create", "_update":
+		id := ""
+		segments := strings.Split(strings.Trim(path, "/"), "/")
+		if len(segments) > 2 {
+			id = segments[2]
+		}
+		op := "index"
+		if method == "DELETE" {
+			op = "delete"
+		} else if api == "_update" {
+			op = "update"
+		}
+		return util.MapStr{
+			"_index":  index,
+			"_id":     id,
+			"result":  writeResult(op),
+			"_shards": shards,
+		}
+	}
+	return util.MapStr{"acknowledged": true}
+}
+
+func writeResult(op string) string {
+	switch op {
+	case "update":
+		return "updated"
+	case "delete":
+		return "deleted"
+	}
+	return "created"
+}
@@ -0,0 +1,221 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/util"
+)
+
+func newTestFailoverGroup(health map[string]bool, clusters ...string) *FailoverGroup {
+	group := &FailoverGroup{
+		config: &FailoverConfig{
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   3,
+		},
+		switchedAt: time.Now(),
+		category:   "test",
+		check: func(cluster string) bool {
+			return health[cluster]
+		},
+	}
+	for _, v := range clusters {
+		group.clusters = append(group.clusters, &failoverCluster{name: v, healthy: true})
+	}
+	return group
+}
+
+func activeCluster(g *FailoverGroup) string {
+	name, _ := g.Active()
+	return name
+}
+
+func TestFailoverAndFailback(t *testing.T) {
+	health := map[string]bool{"primary": true, "dr": true}
+	g := newTestFailoverGroup(health, "primary", "dr")
+
+	g.CheckHealth()
+	_, primary := g.Active()
+	assert.True(t, primary)
+
+	//fail over after the consecutive failed checks
+	health["primary"] = false
+	g.CheckHealth()
+	assert.Equal(t, "primary", activeCluster(g))
+	g.CheckHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+	_, primary = g.Active()
+	assert.False(t, primary)
+
+	//fail back after the consecutive successful checks
+	health["primary"] = true
+	g.CheckHealth()
+	g.CheckHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+	g.CheckHealth()
+	assert.Equal(t, "primary", activeCluster(g))
+
+	states := g.States()
+	assert.Equal(t, 2, len(states))
+	assert.True(t, states[0].Active)
+	assert.True(t, states[0].Healthy)
+	assert.Equal(t, 1, states[1].Priority)
+}
+
+func TestFailbackDelay(t *testing.T) {
+	health := map[string]bool{"primary": false, "dr": true}
+	g := newTestFailoverGroup(health, "primary", "dr")
+	g.failbackDelay = time.Hour
+
+	g.CheckHealth()
+	g.CheckHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+
+	health["primary"] = true
+	for i := 0; i < 5; i++ {
+		g.CheckHealth()
+	}
+	assert.Equal(t, "dr", activeCluster(g))
+
+	g.switchedAt = time.Now().Add(-2 * time.Hour)
+	g.CheckHealth()
+	assert.Equal(t, "primary", activeCluster(g))
+}
+
+func TestFailoverPriority(t *testing.T) {
+	health := map[string]bool{"primary": false, "secondary": false, "dr": true}
+	g := newTestFailoverGroup(health, "primary", "secondary", "dr")
+
+	g.CheckHealth()
+	g.CheckHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+
+	//fail back to the cluster with a higher priority
+	health["secondary"] = true
+	g.CheckHealth()
+	g.CheckHealth()
+	g.CheckHealth()
+	assert.Equal(t, "secondary", activeCluster(g))
+
+	//stay on the current cluster if none is healthy
+	health["secondary"] = false
+	health["dr"] = false
+	g.CheckHealth()
+	g.CheckHealth()
+	assert.Equal(t, "secondary", activeCluster(g))
+}
+
+func TestIsReadRequest(t *testing.T) {
+	assert.True(t, isReadRequest("GET", "/index/_doc/1"))
+	assert.True(t, isReadRequest("HEAD", "/index"))
+	assert.True(t, isReadRequest("POST", "/index/_search"))
+	assert.True(t, isReadRequest("POST", "/_msearch"))
+	assert.True(t, isReadRequest("POST", "/_search/scroll"))
+	assert.True(t, isReadRequest("POST", "/index/_count"))
+	assert.True(t, isReadRequest("DELETE", "/_search/scroll"))
+	assert.False(t, isReadRequest("POST", "/_bulk"))
+	assert.False(t, isReadRequest("POST", "/index/_doc"))
+	assert.False(t, isReadRequest("PUT", "/index/_doc/1"))
+	assert.False(t, isReadRequest("POST", "/index/_update_by_query"))
+	assert.False(t, isReadRequest("DELETE", "/index"))
+
+	assert.True(t, isReadRequest("POST", "/_sql"))
+	assert.True(t, isReadRequest("POST", "/_eql/search"))
+	assert.True(t, isReadRequest("POST", "/index/_pit"))
+	assert.True(t, isReadRequest("DELETE", "/_pit"))
+	assert.True(t, isReadRequest("POST", "/index/_async_search"))
+	assert.True(t, isReadRequest("DELETE", "/_async_search/id"))
+	assert.True(t, isReadRequest("POST", "/index/_knn_search"))
+	assert.True(t, isReadRequest("POST", "/index/_search/template"))
+	assert.False(t, isReadRequest("POST", "/index/_refresh"))
+}
+
+func TestGetWriteAPI(t *testing.T) {
+	assert.Equal(t, "_bulk", getWriteAPI("POST", "/_bulk"))
+	assert.Equal(t, "_bulk", getWriteAPI("POST", "/index/_bulk"))
+	assert.Equal(t, "_doc", getWriteAPI("PUT", "/index/_doc/1"))
+	assert.Equal(t, "_doc", getWriteAPI("DELETE", "/index/_doc/1"))
+	assert.Equal(t, "_create", getWriteAPI("PUT", "/index/_create/1"))
+	assert.Equal(t, "_update", getWriteAPI("POST", "/index/_update/1"))
+	assert.Equal(t, "_delete_by_query", getWriteAPI("POST", "/index/_delete_by_query"))
+	assert.Equal(t, "_aliases", getWriteAPI("POST", "/_aliases"))
+	assert.Equal(t, "_mapping", getWriteAPI("PUT", "/index/_mapping"))
+	assert.Equal(t, "index", getWriteAPI("PUT", "/index"))
+	assert.Equal(t, "index", getWriteAPI("DELETE", "/index"))
+
+	//unknown requests are not queued
+	assert.Equal(t, "", getWriteAPI("POST", "/index/_refresh"))
+	assert.Equal(t, "", getWriteAPI("POST", "/_security/user/test"))
+	assert.Equal(t, "", getWriteAPI("POST", "/index"))
+}
+
+func TestDivertedResponse(t *testing.T) {
+	body := []byte("{\"index\":{\"_id\":\"1\"}}\n{\"a\":1}\n{\"delete\":{\"_index\":\"other\",\"_id\":\"2\"}}\n{\"update\":{\"_id\":\"3\"}}\n{\"doc\":{\"a\":2}}\n")
+	res := divertedResponse("POST", "/index/_bulk", "_bulk", body)
+	assert.Equal(t, false, res["errors"])
+	items := res["items"].([]util.MapStr)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, "index", items[0]["index"].(util.MapStr)["_index"])
+	assert.Equal(t, "1", items[0]["index"].(util.MapStr)["_id"])
+	assert.Equal(t, 202, items[0]["index"].(util.MapStr)["status"])
+	assert.Equal(t, "other", items[1]["delete"].(util.MapStr)["_index"])
+	assert.Equal(t, "deleted", items[1]["delete"].(util.MapStr)["result"])
+	assert.Equal(t, "updated", items[2]["update"].(util.MapStr)["result"])
+
+	res = divertedResponse("PUT", "/index/_doc/1", "_doc", []byte("{\"a\":1}"))
+	assert.Equal(t, "index", res["_index"])
+	assert.Equal(t, "1", res["_id"])
+	assert.Equal(t, "created", res["result"])
+
+	res = divertedResponse("PUT", "/index", "index", nil)
+	assert.Equal(t, true, res["acknowledged"])
+}
+
+func TestInitHealth(t *testing.T) {
+	health := map[string]bool{"primary": false, "dr": true}
+	g := newTestFailoverGroup(health, "primary", "dr")
+	g.failbackDelay = time.Hour
+
+	//fail over on the first check, without waiting for the thresholds
+	g.InitHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+	states := g.States()
+	assert.False(t, states[0].Healthy)
+	assert.True(t, states[1].Healthy)
+
+	health["primary"] = true
+	g.CheckHealth()
+	assert.Equal(t, "dr", activeCluster(g))
+}
+
+func TestGetSecondaryClusters(t *testing.T) {
+	cfg := &ProxyConfig{Elasticsearch: "primary", Failover: FailoverConfig{Clusters: []string{"", "dr", "primary", "backup"}}}
+	assert.Equal(t, []string{"dr", "backup"}, getSecondaryClusters(cfg))
+}
@@ -0,0 +1,430 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/proxy/balancer"
+)
+
+type HedgingConfig struct {
+	Enabled bool `config:"enabled"`
+	// the idempotent read apis to be hedged, `_search`, `_get`, `_mget` and `_count`
+	APIs []string `config:"apis"`
+	// a second request is sent to another node if no response arrives within the percentile latency of the api
+	Percentile float64 `config:"percentile"`
+	MinDelay   string  `config:"min_delay"`
+	MaxDelay   string  `config:"max_delay"`
+	// the latency samples of the api required before hedging
+	MinSamples int `config:"min_samples"`
+	// the max percent of the hedged requests to the eligible requests
+	BudgetPercent float64 `config:"budget_percent"`
+}
+
+const (
+	latencySamples = 1024
+	//the percentile is refreshed every n samples
+	latencyRefreshSamples = 64
+	budgetWindow          = 10 * time.Second
+)
+
+// latencyTracker keeps the recent latencies of an api and the cached percentile
+type latencyTracker struct {
+	locker  sync.Mutex
+	samples [latencySamples]int64
+	count   int
+	next    int
+	records int
+	value   int64
+}
+
+func (t *latencyTracker) Record(latency time.Duration, percentile float64, minSamples int) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	t.samples[t.next] = int64(latency)
+	t.next = (t.next + 1) % latencySamples
+	if t.count < latencySamples {
+		t.count++
+	}
+
+	t.records++
+	if t.count >= minSamples && (atomic.LoadInt64(&t.value) == 0 || t.records%latencyRefreshSamples == 0) {
+		atomic.StoreInt64(&t.value, percentileOf(t.samples[:t.count], percentile))
+	}
+}
+
+// Percentile returns the cached percentile latency, 0 if the samples are not enough
+func (t *latencyTracker) Percentile() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.value))
+}
+
+func percentileOf(samples []int64, percentile float64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	idx := int(math.Ceil(percentile/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// hedgeBudget limits the hedged requests to the percent of the eligible requests in the window
+type hedgeBudget struct {
+	windowStart int64
+	requests    int64
+	hedges      int64
+}
+
+func (b *hedgeBudget) rotate(now int64) {
+	start := atomic.LoadInt64(&b.windowStart)
+	if now-start < int64(budgetWindow) {
+		return
+	}
+	if atomic.CompareAndSwapInt64(&b.windowStart, start, now) {
+		atomic.StoreInt64(&b.requests, 0)
+		atomic.StoreInt64(&b.hedges, 0)
+	}
+}
+
+func (b *hedgeBudget) Request() {
+	b.rotate(time.Now().UnixNano())
+	atomic.AddInt64(&b.requests, 1)
+}
+
+// Allow takes a hedge from the budget
+func (b *hedgeBudget) Allow(percent float64) bool {
+	hedges := atomic.AddInt64(&b.hedges, 1)
+	if float64(hedges)*100 > float64(atomic.LoadInt64(&b.requests))*percent {
+		atomic.AddInt64(&b.hedges, -1)
+		return false
+	}
+	return true
+}
+
+type hedger struct {
+	config     *HedgingConfig
+	apis       map[string]bool
+	minSamples int
+	minDelay   time.Duration
+	maxDelay   time.Duration
+	trackers   sync.Map
+	budget     hedgeBudget
+	category   string
+}
+
+func newHedger(cluster string, cfg *HedgingConfig) *hedger {
+	h := hedger{
+		config:   cfg,
+		apis:     map[string]bool{},
+		minDelay: util.GetDurationOrDefault(cfg.MinDelay, 5*time.Millisecond),
+		maxDelay: util.GetDurationOrDefault(cfg.MaxDelay, time.Second),
+		category: fmt.Sprintf("elasticsearch.%v.hedging", cluster),
+	}
+	for _, v := range cfg.APIs {
+		h.apis[v] = true
+	}
+	h.minSamples = cfg.MinSamples
+	if h.minSamples <= 0 {
+		h.minSamples = 1
+	} else if h.minSamples > latencySamples {
+		h.minSamples = latencySamples
+	}
+	return &h
+}
+
+func (h *hedger) getTracker(api string) *latencyTracker {
+	v, ok := h.trackers.Load(api)
+	if !ok {
+		v, _ = h.trackers.LoadOrStore(api, &latencyTracker{})
+	}
+	return v.(*latencyTracker)
+}
+
+// Delay returns the delay to send the hedged request of the api, 0 means the request should not be hedged
+func (h *hedger) Delay(api string) time.Duration {
+	if !h.apis[api] {
+		return 0
+	}
+	h.budget.Request()
+
+	delay := h.getTracker(api).Percentile()
+	if delay <= 0 {
+		return 0
+	}
+	if delay < h.minDelay {
+		delay = h.minDelay
+	}
+	if h.maxDelay > 0 && delay > h.maxDelay {
+		delay = h.maxDelay
+	}
+	return delay
+}
+
+func (h *hedger) Record(api string, latency time.Duration) {
+	if !h.apis[api] {
+		return
+	}
+	h.getTracker(api).Record(latency, h.config.Percentile, h.minSamples)
+}
+
+func (h *hedger) Allow() bool {
+	return h.budget.Allow(h.config.BudgetPercent)
+}
+
+// getHedgeAPI returns the idempotent read api of the request, or empty if the request can't be hedged
+func getHedgeAPI(method, path string, majorVersion int) string {
+	if method == "GET" || method == "HEAD" {
+		if _, _, ok := parseDocumentRequest(method, path, majorVersion); ok {
+			return "_get"
+		}
+	}
+	if method != "GET" && method != "POST" {
+		return ""
+	}
+
+	path = strings.TrimRight(path, "/")
+	api := path[strings.LastIndex(path, "/")+1:]
+	switch api {
+	case "_search", "_count", "_mget":
+		return api
+	}
+	return ""
+}
+
+// getHedgeDelay returns the api of the request and the delay to send the hedged request
+func (p *ReverseProxy) getHedgeDelay(metadata *elastic.ElasticsearchMetadata, ctx *fasthttp.RequestCtx) (string, time.Duration) {
+	uri := ctx.Request.PhantomURI()
+	api := getHedgeAPI(string(ctx.Method()), string(uri.Path()), metadata.GetMajorVersion())
+	if api == "" {
+		return "", 0
+	}
+	//a hedged scroll request leaves an extra search context behind
+	if uri.QueryArgs().Has("scroll") {
+		return "", 0
+	}
+	return api, p.hedger.Delay(api)
+}
+
+// getHedgeClient picks another host for the hedged request
+func (p *ReverseProxy) getHedgeClient(exclude, clientMode string) (fasthttp.ClientAPI, string, bool) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	if len(p.endpoints) < 2 {
+		return nil, "", false
+	}
+
+	//prefer the host of the balancer, which is aware of the load of the hosts
+	if p.bla != nil {
+		idx := p.bla.Distribute()
+		if idx < len(p.endpoints) && p.endpoints[idx] != exclude {
+			if c, ok := p.getClientOfHost(p.endpoints[idx], clientMode); ok {
+				return c, p.endpoints[idx], true
+			}
+		}
+	}
+
+	offset := rand.Intn(len(p.endpoints))
+	for i := 0; i < len(p.endpoints); i++ {
+		host := p.endpoints[(offset+i)%len(p.endpoints)]
+		if host == exclude {
+			continue
+		}
+		if c, ok := p.getClientOfHost(host, clientMode); ok {
+			return c, host, true
+		}
+	}
+	return nil, "", false
+}
+
+// getClientOfHost returns the client of the host, the locker is held
+func (p *ReverseProxy) getClientOfHost(host, clientMode string) (fasthttp.ClientAPI, bool) {
+	if clientMode == "host" {
+		c, ok := p.hostClients[host]
+		return c, ok
+	}
+	c, ok := p.clients[host]
+	return c, ok
+}
+
+type hedgeResult struct {
+	host string
+	req  *fasthttp.Request
+	res  *fasthttp.Response
+	err  error
+}
+
+// hedgeSender sends the request to the host, the request and the response are owned by it until it returns
+type hedgeSender struct {
+	host string
+	send func(req *fasthttp.Request, res *fasthttp.Response) error
+}
+
+// acquireHedgeRequest copies the request except the body, which is shared with the original request,
+// so that no body is copied for the requests answered in time
+func acquireHedgeRequest(req *fasthttp.Request) *fasthttp.Request {
+	shared := fasthttp.AcquireRequest()
+	body := req.SwapBody(nil)
+	req.CopyTo(shared)
+	req.SwapBody(body)
+	shared.SwapBody(body)
+	return shared
+}
+
+func releaseHedgeResult(result *hedgeResult) {
+	//give the shared body back before the request is reused
+	result.req.SwapBody(nil)
+	fasthttp.ReleaseRequest(result.req)
+	fasthttp.ReleaseResponse(result.res)
+}
+
+// sendHedgeRequest sends the request to the host in background, the result is sent to the channel
+func sendHedgeRequest(results chan *hedgeResult, sender hedgeSender, req *fasthttp.Request) {
+	result := &hedgeResult{host: sender.host, req: acquireHedgeRequest(req), res: fasthttp.AcquireResponse()}
+	result.req.SetHost(getRequestHost(sender.host))
+
+	go func() {
+		result.err = sender.send(result.req, result.res)
+		results <- result
+	}()
+}
+
+// hedgeRequest sends the request by the primary sender, and by the backup sender if no response arrives within the delay,
+// the first successful response wins. The in-flight requests of fasthttp can't be cancelled, so the slower one is
+// abandoned, and released once it returns, the original request gets a copy of its body then, as it's reused after the call.
+// The requests may outlive the call, that's why they can't be sent with the original request
+func hedgeRequest(req *fasthttp.Request, res *fasthttp.Response, delay time.Duration, primary hedgeSender, getBackup func() (hedgeSender, bool)) (string, error) {
+	results := make(chan *hedgeResult, 2)
+	sendHedgeRequest(results, primary, req)
+	pending := 1
+
+	timer := util.AcquireTimer(delay)
+	defer util.ReleaseTimer(timer)
+
+	var result *hedgeResult
+	select {
+	case result = <-results:
+	case <-timer.C:
+		if backup, ok := getBackup(); ok {
+			sendHedgeRequest(results, backup, req)
+			pending++
+		}
+		result = <-results
+	}
+	pending--
+
+	//the other one may still succeed
+	if result.err != nil && pending > 0 {
+		releaseHedgeResult(result)
+		result = <-results
+		pending--
+	}
+
+	result.res.CopyTo(res)
+	releaseHedgeResult(result)
+
+	if pending > 0 {
+		req.SwapBody(append([]byte(nil), req.Body()...))
+		go func() {
+			releaseHedgeResult(<-results)
+		}()
+	}
+
+	return result.host, result.err
+}
+
+// doHedgedRequest sends the request to the host, and a copy to another host if no response arrives within the delay,
+// returns the host which answered. Both requests are bound by the deadline of the `timeout`, which limits
+// how long the slower one keeps the connection after the call, without the `timeout` it runs until the host answers
+func (p *ReverseProxy) doHedgedRequest(pc fasthttp.ClientAPI, host, clientMode string, req *fasthttp.Request, res *fasthttp.Response, delay time.Duration) (string, error) {
+	var deadline time.Time
+	if p.proxyConfig.Timeout > 0 {
+		deadline = time.Now().Add(p.proxyConfig.Timeout)
+	}
+	send := func(client fasthttp.ClientAPI, endpointStats *balancer.EndpointStats) func(req *fasthttp.Request, res *fasthttp.Response) error {
+		return func(req *fasthttp.Request, res *fasthttp.Response) (err error) {
+			if endpointStats != nil {
+				endpointStats.Acquire()
+			}
+			start := time.Now()
+			if deadline.IsZero() {
+				err = client.Do(req, res)
+			} else {
+				err = client.DoTimeout(req, res, time.Until(deadline))
+			}
+			if endpointStats != nil {
+				endpointStats.Release(time.Since(start), err != nil || isBackendFailure(res.StatusCode()))
+			}
+			return err
+		}
+	}
+
+	getBackup := func() (hedgeSender, bool) {
+		client, backup, ok := p.getHedgeClient(host, clientMode)
+		if !ok {
+			return hedgeSender{}, false
+		}
+		if !p.hedger.Allow() {
+			stats.Increment(p.hedger.category, "budget_exhausted")
+			return hedgeSender{}, false
+		}
+		if global.Env().IsDebug {
+			log.Tracef("no response from [%v] in [%v], hedge request [%v] to [%v]", host, delay, req.PhantomURI().String(), backup)
+		}
+		stats.Increment(p.hedger.category, "hedged")
+		return hedgeSender{host: backup, send: send(client, p.getEndpointStats(backup))}, true
+	}
+
+	winner, err := hedgeRequest(req, res, delay, hedgeSender{host: host, send: send(pc, nil)}, getBackup)
+	if winner != host {
+		stats.Increment(p.hedger.category, "won")
+	}
+	return winner, err
+}
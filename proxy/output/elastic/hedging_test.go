@@ -0,0 +1,203 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+func TestGetHedgeAPI(t *testing.T) {
+	cases := []struct {
+		method, path string
+		version      int
+		api          string
+	}{
+		{"GET", "/index/_search", 7, "_search"},
+		{"POST", "/index/_search/", 7, "_search"},
+		{"POST", "/_search", 7, "_search"},
+		{"GET", "/index/_count", 7, "_count"},
+		{"POST", "/_mget", 7, "_mget"},
+		{"GET", "/index/_doc/1", 7, "_get"},
+		{"HEAD", "/index/_source/1", 7, "_get"},
+		{"GET", "/index/doc/1", 6, "_get"},
+		{"PUT", "/index/_doc/1", 7, ""},
+		{"DELETE", "/index/_search", 7, ""},
+		{"POST", "/_bulk", 7, ""},
+		{"POST", "/index/_update/1", 7, ""},
+		{"POST", "/_search/scroll", 7, ""},
+		{"GET", "/_cluster/health", 7, ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.api, getHedgeAPI(c.method, c.path, c.version), c.method+" "+c.path)
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	samples := []int64{}
+	for i := 100; i > 0; i-- {
+		samples = append(samples, int64(i))
+	}
+	assert.Equal(t, int64(95), percentileOf(samples, 95))
+	assert.Equal(t, int64(50), percentileOf(samples, 50))
+	assert.Equal(t, int64(100), percentileOf(samples, 100))
+	assert.Equal(t, int64(1), percentileOf(samples, 0))
+	assert.Equal(t, int64(0), percentileOf(nil, 95))
+	//the samples are not sorted in place
+	assert.Equal(t, int64(100), samples[0])
+}
+
+func TestHedgerDelay(t *testing.T) {
+	h := newHedger("test", &HedgingConfig{
+		APIs:          []string{"_search"},
+		Percentile:    90,
+		MinDelay:      "5ms",
+		MaxDelay:      "100ms",
+		MinSamples:    10,
+		BudgetPercent: 10,
+	})
+
+	//not enough samples
+	for i := 1; i < 10; i++ {
+		h.Record("_search", time.Duration(i)*time.Millisecond*10)
+	}
+	assert.Equal(t, time.Duration(0), h.Delay("_search"))
+
+	h.Record("_search", 100*time.Millisecond)
+	assert.Equal(t, 90*time.Millisecond, h.Delay("_search"))
+
+	//not hedged api
+	h.Record("_count", 100*time.Millisecond)
+	assert.Equal(t, time.Duration(0), h.Delay("_count"))
+
+	//limited by the min and max delay
+	h = newHedger("test", &HedgingConfig{APIs: []string{"_search"}, Percentile: 50, MinDelay: "5ms", MaxDelay: "100ms", MinSamples: 1})
+	h.Record("_search", time.Millisecond)
+	assert.Equal(t, 5*time.Millisecond, h.Delay("_search"))
+	h = newHedger("test", &HedgingConfig{APIs: []string{"_search"}, Percentile: 50, MinDelay: "5ms", MaxDelay: "100ms", MinSamples: 1})
+	h.Record("_search", time.Second)
+	assert.Equal(t, 100*time.Millisecond, h.Delay("_search"))
+}
+
+func TestHedgeBudget(t *testing.T) {
+	b := hedgeBudget{}
+	assert.False(t, b.Allow(10))
+
+	for i := 0; i < 20; i++ {
+		b.Request()
+	}
+	assert.True(t, b.Allow(10))
+	assert.True(t, b.Allow(10))
+	assert.False(t, b.Allow(10))
+
+	//a new window
+	b.windowStart = time.Now().Add(-budgetWindow).UnixNano()
+	b.Request()
+	assert.False(t, b.Allow(10))
+	for i := 0; i < 9; i++ {
+		b.Request()
+	}
+	assert.True(t, b.Allow(10))
+}
+
+func newTestHedgeSender(host string, delay time.Duration, err error, bodies chan string) hedgeSender {
+	return hedgeSender{host: host, send: func(req *fasthttp.Request, res *fasthttp.Response) error {
+		time.Sleep(delay)
+		if bodies != nil {
+			bodies <- string(req.Body())
+		}
+		if err != nil {
+			return err
+		}
+		res.SetBodyString(host)
+		return nil
+	}}
+}
+
+func newTestHedgeRequest() (*fasthttp.Request, *fasthttp.Response) {
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI("http://primary/index/_search")
+	req.SetBodyString("{\"query\":{}}")
+	return req, fasthttp.AcquireResponse()
+}
+
+func TestHedgeRequestPrimaryWins(t *testing.T) {
+	req, res := newTestHedgeRequest()
+	var hedged int32
+	host, err := hedgeRequest(req, res, 100*time.Millisecond, newTestHedgeSender("primary", 0, nil, nil), func() (hedgeSender, bool) {
+		atomic.AddInt32(&hedged, 1)
+		return newTestHedgeSender("backup", 0, nil, nil), true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "primary", host)
+	assert.Equal(t, "primary", string(res.Body()))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hedged))
+	assert.Equal(t, "{\"query\":{}}", string(req.Body()))
+}
+
+func TestHedgeRequestBackupWins(t *testing.T) {
+	req, res := newTestHedgeRequest()
+	bodies := make(chan string, 1)
+	host, err := hedgeRequest(req, res, 10*time.Millisecond, newTestHedgeSender("primary", 200*time.Millisecond, nil, bodies), func() (hedgeSender, bool) {
+		return newTestHedgeSender("backup", 0, nil, nil), true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", host)
+	assert.Equal(t, "backup", string(res.Body()))
+
+	//the caller reuses the request, the loser still sends the original body
+	req.SetBodyString("reused")
+	assert.Equal(t, "{\"query\":{}}", <-bodies)
+}
+
+func TestHedgeRequestErrorFallback(t *testing.T) {
+	req, res := newTestHedgeRequest()
+	host, err := hedgeRequest(req, res, 10*time.Millisecond, newTestHedgeSender("primary", 50*time.Millisecond, errors.New("timeout"), nil), func() (hedgeSender, bool) {
+		return newTestHedgeSender("backup", 100*time.Millisecond, nil, nil), true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", host)
+	assert.Equal(t, "backup", string(res.Body()))
+
+	//no hedged request is sent, the error is returned
+	req, res = newTestHedgeRequest()
+	host, err = hedgeRequest(req, res, 10*time.Millisecond, newTestHedgeSender("primary", 50*time.Millisecond, errors.New("timeout"), nil), func() (hedgeSender, bool) {
+		return hedgeSender{}, false
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "primary", host)
+}
+
+func TestGetRequestHost(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:9200", getRequestHost("127.0.0.1:9200"))
+	assert.Equal(t, "localhost", getRequestHost("unix:///var/run/elasticsearch.sock"))
+}
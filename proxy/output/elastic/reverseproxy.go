@@ -31,6 +31,7 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/cihub/seelog"
@@ -41,6 +42,7 @@ import (
 	task2 "infini.sh/framework/core/task"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 	"infini.sh/gateway/proxy/balancer"
 )
 
@@ -55,6 +57,16 @@ type ReverseProxy struct {
 	clients     map[string]*fasthttp.Client
 	locker      sync.RWMutex
 
+	//endpoint => in-flight requests and latency, kept across the refreshes
+	endpointStats map[string]*balancer.EndpointStats
+
+	//all the discovered hosts, the endpoints excludes the hosts ejected by the circuit breakers
+	allEndpoints   []string
+	breakers       *CircuitBreakers
+	breakerVersion int64
+
+	hedger *hedger
+
 	fixedClient bool
 	client      fasthttp.ClientAPI
 	host        string
@@ -159,7 +171,6 @@ func (p *ReverseProxy) refreshNodes(force bool) {
 	}
 	cfg := p.proxyConfig
 
-	ws := []int{}
 	esConfig := elastic.GetConfig(cfg.Elasticsearch)
 
 	metadata := elastic.GetOrInitMetadata(esConfig)
@@ -234,9 +245,7 @@ func (p *ReverseProxy) refreshNodes(force bool) {
 				//RetryIf: func(request *fasthttp.Request) bool {
 				//
 				//},
-				Dial: func(addr string) (net.Conn, error) {
-					return fasthttp.DialTimeout(addr, cfg.DialTimeout)
-				},
+				Dial:  p.getDialer(endpoint, false),
 				IsTLS: metadata.IsTLS(),
 				TLSConfig: &tls.Config{
 					InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
@@ -259,21 +268,17 @@ func (p *ReverseProxy) refreshNodes(force bool) {
 				WriteTimeout:                  cfg.WriteTimeout,
 				ReadBufferSize:                cfg.ReadBufferSize,
 				WriteBufferSize:               cfg.WriteBufferSize,
-				Dial: func(addr string) (net.Conn, error) {
-					return fasthttp.DialDualStackTimeout(addr, cfg.DialTimeout)
-				},
+				Dial: p.getDialer(endpoint, true),
 				TLSConfig: &tls.Config{
 					InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
 				},
 			}
 		}
 
-		//get predefined weights
-		w, o := cfg.Weights[endpoint]
-		if !o || w <= 0 {
-			w = 1
+		if _, ok = p.endpointStats[endpoint]; !ok {
+			p.endpointStats[endpoint] = balancer.NewEndpointStats()
 		}
-		ws = append(ws, w)
+
 		newHosts = append(newHosts, endpoint)
 	}
 
@@ -284,23 +289,130 @@ func (p *ReverseProxy) refreshNodes(force bool) {
 
 	sort.Strings(newHosts)
 
-	if util.JoinArray(newHosts, ", ") == util.JoinArray(p.endpoints, ", ") {
+	if util.JoinArray(newHosts, ", ") == util.JoinArray(p.allEndpoints, ", ") {
 		log.Debugf("hosts of [%v] no change, skip", esConfig.Name)
 		return
 	}
 
-	//replace with new hostClients
-	//TODO add locker
-	p.bla = balancer.NewBalancer(ws)
 	newHostsStr := util.JoinArray(newHosts, ", ")
 	if rate.GetRateLimiterPerSecond("elasticsearch", esConfig.Name+newHostsStr, 1).Allow() {
-		log.Infof("elasticsearch [%v] hosts: [%v] => [%v]", esConfig.Name, util.JoinArray(p.endpoints, ", "), newHostsStr)
+		log.Infof("elasticsearch [%v] hosts: [%v] => [%v]", esConfig.Name, util.JoinArray(p.allEndpoints, ", "), newHostsStr)
 	}
-	p.endpoints = newHosts
+	p.allEndpoints = newHosts
+	p.applyEndpoints()
 	log.Trace(esConfig.Name, " elasticsearch client nodes refreshed")
 
 }
 
+// applyEndpoints replaces the balancer with the hosts which are not ejected by the circuit breakers, the locker is held
+func (p *ReverseProxy) applyEndpoints() {
+	cfg := p.proxyConfig
+	hosts := p.allEndpoints
+	if p.breakers != nil {
+		atomic.StoreInt64(&p.breakerVersion, p.breakers.Version())
+		hosts = p.breakers.Available(hosts)
+		if len(hosts) == 0 {
+			hosts = p.allEndpoints
+		}
+	}
+
+	//get predefined weights, aligned with the sorted hosts
+	ws := []int{}
+	stats := []*balancer.EndpointStats{}
+	for _, endpoint := range hosts {
+		w, o := cfg.Weights[endpoint]
+		if !o || w <= 0 {
+			w = 1
+		}
+		ws = append(ws, w)
+		stats = append(stats, p.endpointStats[endpoint])
+	}
+
+	//replace with new hostClients
+	bla, err := balancer.NewBalancerByName(cfg.Balancer, ws, stats)
+	if err != nil {
+		log.Errorf("invalid balancer for elasticsearch [%v], fallback to weight: %v", cfg.Elasticsearch, err)
+		bla = balancer.NewBalancer(ws)
+	}
+	p.bla = bla
+	p.endpoints = hosts
+}
+
+// checkCircuitBreakers ejects or restores the hosts when the circuit breakers are changed
+func (p *ReverseProxy) checkCircuitBreakers() {
+	version := p.breakers.Version()
+	if version == atomic.LoadInt64(&p.breakerVersion) {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if version != atomic.LoadInt64(&p.breakerVersion) && len(p.allEndpoints) > 0 {
+		p.applyEndpoints()
+		log.Debugf("elasticsearch [%v] available hosts: [%v]", p.proxyConfig.Elasticsearch, util.JoinArray(p.endpoints, ", "))
+	}
+}
+
+// acquireProbe picks a half-open host to send the request to as a probe
+func (p *ReverseProxy) acquireProbe(clientMode string) (fasthttp.ClientAPI, string, bool) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	endpoint, ok := p.breakers.AcquireProbe(p.allEndpoints)
+	if !ok {
+		return nil, "", false
+	}
+	if clientMode == "host" {
+		if c, ok := p.hostClients[endpoint]; ok {
+			return c, endpoint, true
+		}
+	} else if c, ok := p.clients[endpoint]; ok {
+		return c, endpoint, true
+	}
+	p.breakers.ReleaseProbe(endpoint)
+	return nil, "", false
+}
+
+// reportCircuitBreaker reports the result of the request, connection errors and `502`, `503`, `504` are failures,
+// `429` are rejections
+func (p *ReverseProxy) reportCircuitBreaker(host, probe string, status int, failed bool) {
+	if probe != "" && probe != host {
+		p.breakers.ReleaseProbe(probe)
+	}
+	failure := failed || status == 502 || status == 503 || status == 504
+	p.breakers.Report(host, probe != "" && probe == host, failure, status == 429)
+}
+
+// getRequestHost returns the host of the request sent to the endpoint, the client of the unix domain socket dials the
+// socket file, the endpoint is only used to pick the client, `localhost` is sent instead of the path of the socket
+func getRequestHost(endpoint string) string {
+	if common.IsUnixSocket(endpoint) {
+		return "localhost"
+	}
+	return endpoint
+}
+
+// getDialer returns the dial func of the endpoint, the endpoint may be served by a unix domain socket
+func (p *ReverseProxy) getDialer(endpoint string, dualStack bool) func(addr string) (net.Conn, error) {
+	cfg := p.proxyConfig
+	socket := cfg.UnixSockets[endpoint]
+	if socket == "" && common.IsUnixSocket(endpoint) {
+		socket = endpoint
+	}
+	if socket != "" {
+		return common.DialUnixSocket(socket, cfg.DialTimeout)
+	}
+
+	if dualStack {
+		return func(addr string) (net.Conn, error) {
+			return fasthttp.DialDualStackTimeout(addr, cfg.DialTimeout)
+		}
+	}
+	return func(addr string) (net.Conn, error) {
+		return fasthttp.DialTimeout(addr, cfg.DialTimeout)
+	}
+}
+
 func NewReverseProxy(cfg *ProxyConfig) *ReverseProxy {
 
 	p := ReverseProxy{
@@ -309,6 +421,16 @@ func NewReverseProxy(cfg *ProxyConfig) *ReverseProxy {
 		hostClients: map[string]*fasthttp.HostClient{},
 		clients:     map[string]*fasthttp.Client{},
 		locker:      sync.RWMutex{},
+
+		endpointStats: map[string]*balancer.EndpointStats{},
+	}
+
+	if cfg.CircuitBreaker.Enabled && !cfg.FixedClient {
+		p.breakers = getCircuitBreakers(cfg.Elasticsearch, &cfg.CircuitBreaker)
+	}
+
+	if cfg.Hedging.Enabled && !cfg.FixedClient {
+		p.hedger = newHedger(cfg.Elasticsearch, &cfg.Hedging)
 	}
 
 	p.refreshNodes(true)
@@ -340,6 +462,9 @@ func NewReverseProxy(cfg *ProxyConfig) *ReverseProxy {
 }
 
 func (p *ReverseProxy) getHostClient() (clientAvailable bool, client *fasthttp.HostClient, endpoint string) {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
 	if p.hostClients == nil {
 		panic("ReverseProxy has been closed")
 	}
@@ -432,6 +557,17 @@ RANDOM:
 	return true, c, e
 }
 
+func (p *ReverseProxy) getEndpointStats(endpoint string) *balancer.EndpointStats {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return p.endpointStats[endpoint]
+}
+
+// isBackendFailure checks if the status means the endpoint is failing or overloaded
+func isBackendFailure(status int) bool {
+	return status == 429 || status >= 500
+}
+
 var failureMessage = []string{"connection refused", "no such host", "timed out", "Connection: close"}
 
 func (p *ReverseProxy) DelegateRequest(elasticsearch string, metadata *elastic.ElasticsearchMetadata, myctx *fasthttp.RequestCtx) {
@@ -455,22 +591,39 @@ func (p *ReverseProxy) DelegateRequest(elasticsearch string, metadata *elastic.E
 
 	var pc fasthttp.ClientAPI
 	var host string
+	//the half-open host which the request is sent to as a probe
+	var probe string
+	//connection errors of the upstream
+	var upstreamFailed bool
 
 	if p.proxyConfig.FixedClient {
 		pc = p.client
 		host = p.host
 	} else {
+		selected := false
+		if p.breakers != nil {
+			p.checkCircuitBreakers()
+			pc, probe, selected = p.acquireProbe(metadata.Config.ClientMode)
+			host = probe
+		}
+
+		if !selected && p.proxyConfig.ShardRouting {
+			pc, host, selected = p.getShardClient(metadata, myctx, metadata.Config.ClientMode)
+		}
+
 		//var ok bool
 		//使用算法来获取合适的 client
-		switch metadata.Config.ClientMode {
-		case "client":
-			_, pc, host = p.getClient()
-			break
-		case "host":
-			_, pc, host = p.getHostClient()
-			break
-		default:
-			_, pc, host = p.getClient()
+		if !selected {
+			switch metadata.Config.ClientMode {
+			case "client":
+				_, pc, host = p.getClient()
+				break
+			case "host":
+				_, pc, host = p.getHostClient()
+				break
+			default:
+				_, pc, host = p.getClient()
+			}
 		}
 
 		if !p.proxyConfig.SkipAvailableCheck && !elastic.IsHostAvailable(host) {
@@ -483,6 +636,12 @@ func (p *ReverseProxy) DelegateRequest(elasticsearch string, metadata *elastic.E
 		}
 	}
 
+	if p.breakers != nil {
+		defer func() {
+			p.reportCircuitBreaker(host, probe, res.StatusCode(), upstreamFailed)
+		}()
+	}
+
 	// modify schema，align with elasticsearch's schema
 	originalHost := string(myctx.Request.Header.Host())
 	originalSchema := myctx.Request.GetSchema()
@@ -508,16 +667,23 @@ func (p *ReverseProxy) DelegateRequest(elasticsearch string, metadata *elastic.E
 		schemaChanged = true
 	}
 
+	if stats := p.getEndpointStats(host); stats != nil {
+		stats.Acquire()
+		start := time.Now()
+		defer func() {
+			stats.Release(time.Since(start), isBackendFailure(res.StatusCode()))
+		}()
+	}
+
+	if common.IsTracingEnabled() {
+		span := p.startUpstreamSpan(myctx, elasticsearch, host)
+		defer p.endUpstreamSpan(span, res)
+	}
+
 	if !p.proxyConfig.SkipEnrichMetadata {
-		forwardedFor := myctx.Request.Header.Peek(fasthttp.HeaderXForwardedFor)
-		remoteAddr := myctx.RemoteAddr().String()
-		if len(forwardedFor) == 0 {
-			myctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, remoteAddr)
-		} else {
-			myctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, string(forwardedFor)+", "+remoteAddr)
-		}
-		myctx.Request.Header.Add(fasthttp.HeaderXRealIP, myctx.RemoteAddr().String())
-		myctx.Request.Header.Add(fasthttp.HeaderXForwardedHost, originalHost)
+		common.SetForwardedHeaders(myctx, originalHost)
+		common.SetTracingHeaders(myctx)
+		common.SetOpaqueIDHeader(myctx)
 	}
 
 	if global.Env().IsDebug {
@@ -526,24 +692,39 @@ func (p *ReverseProxy) DelegateRequest(elasticsearch string, metadata *elastic.E
 
 	curHost := string(myctx.Request.Host())
 	if host != curHost || host != originalHost {
-		myctx.Request.SetHostBytes([]byte(host))
+		myctx.Request.SetHost(getRequestHost(host))
+	}
+
+	//the probes are not hedged, they are used to check the half-open host
+	var hedgeAPI string
+	var hedgeDelay time.Duration
+	if p.hedger != nil && probe == "" {
+		hedgeAPI, hedgeDelay = p.getHedgeDelay(metadata, myctx)
 	}
 
 	retry := 0
 START:
 
 	metadata.CheckNodeTrafficThrottle(host, 1, myctx.Request.GetRequestLength(), 0)
+	requestStart := time.Now()
 
 	//if p.proxyConfig.Timeout <= 0 {
 	//	p.proxyConfig.Timeout = 60 * time.Second
 	//}
 
 	var err error
-	if p.proxyConfig.Timeout > 0 {
+	if hedgeDelay > 0 {
+		host, err = p.doHedgedRequest(pc, host, metadata.Config.ClientMode, &myctx.Request, res, hedgeDelay)
+	} else if p.proxyConfig.Timeout > 0 {
 		err = pc.DoTimeout(&myctx.Request, res, p.proxyConfig.Timeout)
 	} else {
 		err = pc.Do(&myctx.Request, res)
 	}
+	upstreamFailed = err != nil
+
+	if hedgeAPI != "" && err == nil {
+		p.hedger.Record(hedgeAPI, time.Since(requestStart))
+	}
 
 	if err != nil {
 
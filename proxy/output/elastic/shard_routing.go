@@ -0,0 +1,141 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// the methods of the single document apis
+var documentAPIMethods = map[string][]string{
+	"_doc":    {"GET", "HEAD", "PUT", "POST", "DELETE"},
+	"_create": {"PUT", "POST"},
+	"_update": {"POST"},
+	"_source": {"GET", "HEAD"},
+}
+
+// parseDocumentRequest parses the single document requests, eg: `GET /index/_doc/1`, `POST /index/_update/1`, or
+// `GET /index/type/1` before elasticsearch 7
+func parseDocumentRequest(method, path string, majorVersion int) (index, id string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	var api string
+	switch len(parts) {
+	case 3:
+		index, api, id = parts[0], parts[1], parts[2]
+		if majorVersion < 7 && !strings.HasPrefix(api, "_") {
+			//typed document api
+			api = "_doc"
+		}
+	case 4:
+		//typed update, create and source apis
+		if majorVersion >= 7 || strings.HasPrefix(parts[1], "_") {
+			return "", "", false
+		}
+		index, id, api = parts[0], parts[2], parts[3]
+	default:
+		return "", "", false
+	}
+
+	if index == "" || id == "" || strings.HasPrefix(index, "_") || strings.HasPrefix(id, "_") ||
+		strings.ContainsAny(index, ",*") {
+		return "", "", false
+	}
+
+	methods, ok := documentAPIMethods[api]
+	if !ok {
+		return "", "", false
+	}
+	for _, v := range methods {
+		if v == method {
+			return index, id, true
+		}
+	}
+	return "", "", false
+}
+
+// getShardClient returns the client of the node holding the primary shard of the single document request, so the
+// request is handled without forwarding by a coordinating node
+func (p *ReverseProxy) getShardClient(metadata *elastic.ElasticsearchMetadata, ctx *fasthttp.RequestCtx, clientMode string) (fasthttp.ClientAPI, string, bool) {
+	index, id, ok := parseDocumentRequest(string(ctx.Method()), string(ctx.Request.PhantomURI().Path()), metadata.GetMajorVersion())
+	if !ok {
+		return nil, "", false
+	}
+
+	category := fmt.Sprintf("elasticsearch.%v.shard_routing", p.proxyConfig.Elasticsearch)
+	routing := string(ctx.Request.PhantomURI().QueryArgs().Peek("routing"))
+	shardID, nodeID, err := common.GetPrimaryShardNode(metadata, index, id, routing)
+	if err != nil {
+		if global.Env().IsDebug {
+			log.Debugf("failed to get the primary shard of [%v][%v], %v", index, id, err)
+		}
+		stats.Increment(category, "miss")
+		return nil, "", false
+	}
+
+	nodeInfo := metadata.GetNodeInfo(nodeID)
+	if nodeInfo == nil {
+		stats.Increment(category, "miss")
+		return nil, "", false
+	}
+	host := nodeInfo.GetHttpPublishHost()
+
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	//only the hosts of the balancer, which are not filtered or ejected
+	for _, endpoint := range p.endpoints {
+		if endpoint != host {
+			continue
+		}
+		var client fasthttp.ClientAPI
+		if clientMode == "host" {
+			client, ok = p.hostClients[host]
+		} else {
+			client, ok = p.clients[host]
+		}
+		if !ok {
+			break
+		}
+		if global.Env().IsDebug {
+			log.Tracef("route [%v][%v] to shard [%v] on [%v]", index, id, shardID, host)
+		}
+		stats.Increment(category, "hit")
+		return client, host, true
+	}
+
+	stats.Increment(category, "miss")
+	return nil, "", false
+}
@@ -0,0 +1,66 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDocumentRequest(t *testing.T) {
+	cases := []struct {
+		method, path string
+		version      int
+		index, id    string
+		ok           bool
+	}{
+		{"GET", "/index/_doc/1", 7, "index", "1", true},
+		{"PUT", "/index/_doc/1", 8, "index", "1", true},
+		{"DELETE", "/index/_doc/1", 7, "index", "1", true},
+		{"POST", "/index/_update/1", 7, "index", "1", true},
+		{"PUT", "/index/_create/1", 7, "index", "1", true},
+		{"HEAD", "/index/_source/1", 7, "index", "1", true},
+		{"GET", "/index/doc/1", 6, "index", "1", true},
+		{"POST", "/index/doc/1/_update", 6, "index", "1", true},
+		{"POST", "/index/_doc", 7, "", "", false},
+		{"GET", "/index/_update/1", 7, "", "", false},
+		{"GET", "/index/_search/1", 7, "", "", false},
+		{"GET", "/index/doc/1", 7, "", "", false},
+		{"POST", "/index/doc/1/_update", 7, "", "", false},
+		{"GET", "/index1,index2/_doc/1", 7, "", "", false},
+		{"GET", "/logs-*/_doc/1", 7, "", "", false},
+		{"GET", "/_all/_doc/1", 7, "", "", false},
+		{"GET", "/index/_doc/1/_explain", 7, "", "", false},
+	}
+	for _, c := range cases {
+		index, id, ok := parseDocumentRequest(c.method, c.path, c.version)
+		assert.Equal(t, c.ok, ok, c.path)
+		assert.Equal(t, c.index, index, c.path)
+		assert.Equal(t, c.id, id, c.path)
+	}
+}
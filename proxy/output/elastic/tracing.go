@@ -0,0 +1,72 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package elastic
+
+import (
+	"fmt"
+	"strings"
+
+	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
+)
+
+// startUpstreamSpan starts the span of the request sent to elasticsearch, nested in the span of the filter
+func (p *ReverseProxy) startUpstreamSpan(ctx *fasthttp.RequestCtx, cluster, host string) common.Span {
+	span := common.StartSpan(ctx, fmt.Sprintf("%s %s", ctx.Method(), cluster), common.SpanKindClient)
+	span.SetAttribute("elasticsearch.cluster", cluster)
+	span.SetAttribute("server.address", host)
+	span.SetAttribute("http.request.method", string(ctx.Method()))
+	span.SetAttribute("url.path", string(ctx.Request.PhantomURI().Path()))
+	if index := getIndexFromPath(ctx.Request.PhantomURI().Path()); index != "" {
+		span.SetAttribute("elasticsearch.index", index)
+	}
+	span.SetAttribute("http.request.body.size", ctx.Request.GetRequestLength())
+	return span
+}
+
+func (p *ReverseProxy) endUpstreamSpan(span common.Span, res *fasthttp.Response) {
+	span.SetAttribute("http.response.status_code", res.StatusCode())
+	if v := res.Header.Peek("X-Retry-Times"); len(v) > 0 {
+		span.SetAttribute("http.request.resend_count", string(v))
+	}
+	if res.StatusCode() >= 500 {
+		span.RecordError(fmt.Errorf("status code: %v", res.StatusCode()))
+	}
+	span.End()
+}
+
+// getIndexFromPath returns the index of the request, eg: `/index/_search` or `/index1,index2/_bulk`
+func getIndexFromPath(path []byte) string {
+	p := strings.TrimPrefix(string(path), "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		p = p[:i]
+	}
+	if p == "" || strings.HasPrefix(p, "_") {
+		return ""
+	}
+	return p
+}
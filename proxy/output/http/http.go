@@ -46,6 +46,7 @@ import (
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type HTTPFilter struct {
@@ -178,7 +179,13 @@ func doWS(host string, ctx *fasthttp.RequestCtx) error {
 	conn := ctx.Conn()
 	defer conn.Close()
 
-	backendConn, err := net.Dial("tcp", host)
+	var backendConn net.Conn
+	var err error
+	if common.IsUnixSocket(host) {
+		backendConn, err = common.DialUnixSocket(host, 0)(host)
+	} else {
+		backendConn, err = net.Dial("tcp", host)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to connect to backend WebSocket server: %w", err)
 	}
@@ -224,16 +231,20 @@ func (filter *HTTPFilter) forward(host string, ctx *fasthttp.RequestCtx) (err er
 		panic("invalid host")
 	}
 
-	ctx.Request.SetHost(host)
+	if common.IsUnixSocket(host) {
+		//the client of the unix socket dials the socket file, the host is only used to pick the client
+		ctx.Request.SetHost("localhost")
+	} else {
+		ctx.Request.SetHost(host)
+	}
 
 	//keep original host
 	ctx.Request.UseHostHeader = true
 	ctx.Request.Header.SetHost(orignalHost)
 
 	if !filter.SkipEnrichMetadata {
-		ctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, ctx.RemoteAddr().String())
-		ctx.Request.Header.Set(fasthttp.HeaderXRealIP, ctx.RemoteAddr().String())
-		ctx.Request.Header.Set(fasthttp.HeaderXForwardedHost, orignalHost)
+		common.SetForwardedHeaders(ctx, orignalHost)
+		common.SetTracingHeaders(ctx)
 	}
 
 	clonedURI := ctx.Request.CloneURI()
@@ -352,6 +363,10 @@ func NewHTTPFilter(c *config.Config) (pipeline.Filter, error) {
 			TLSConfig:                     api.SimpleGetTLSConfig(runner.TLSConfig),
 		}
 
+		if common.IsUnixSocket(host) {
+			c.Dial = common.DialUnixSocket(host, 0)
+		}
+
 		runner.clients.Store(host, c)
 	}
 
@@ -42,6 +42,7 @@ import (
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 )
 
 type WildcardDomainFilter struct {
@@ -133,9 +134,8 @@ func (filter *WildcardDomainFilter) forward(host string, ctx *fasthttp.RequestCt
 	ctx.Request.Header.SetHost(orignalHost)
 
 	if !filter.SkipEnrichMetadata {
-		ctx.Request.Header.Set(fasthttp.HeaderXForwardedFor, ctx.RemoteAddr().String())
-		ctx.Request.Header.Set(fasthttp.HeaderXRealIP, ctx.RemoteAddr().String())
-		ctx.Request.Header.Set(fasthttp.HeaderXForwardedHost, orignalHost)
+		common.SetForwardedHeaders(ctx, orignalHost)
+		common.SetTracingHeaders(ctx)
 	}
 
 	clonedURI := ctx.Request.CloneURI()
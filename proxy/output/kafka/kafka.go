@@ -31,6 +31,7 @@ import (
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
+	"infini.sh/gateway/common"
 	"sync"
 	"time"
 )
@@ -58,6 +59,13 @@ func (filter *Kafka) Filter(ctx *fasthttp.RequestCtx) {
 	msg := filter.msgPool.Get().(kafka.Message)
 	msg.Key = util.Int64ToBytes(int64(util.GetIncrementID64("request")))
 	msg.Value = ctx.Request.Encode()
+	msg.Headers = nil
+	if requestID := common.GetRequestID(ctx); requestID != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: common.HeaderRequestID, Value: []byte(requestID)})
+	}
+	if tc := common.GetTraceContext(ctx); tc != nil {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: common.HeaderTraceParent, Value: []byte(tc.String())})
+	}
 
 	filter.lock.Lock()
 	filter.messages = append(filter.messages, msg)
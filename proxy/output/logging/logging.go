@@ -34,6 +34,7 @@ import (
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fastjson_marshal"
+	"infini.sh/gateway/common"
 	"infini.sh/gateway/common/model"
 
 	"time"
@@ -147,6 +148,12 @@ func (this *RequestLogging) Filter(ctx *fasthttp.RequestCtx) {
 	request.LoggingTime = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
 	request.Request.StartTime = ctx.Time().UTC().Format("2006-01-02T15:04:05.000Z")
 
+	request.RequestID = common.GetRequestID(ctx)
+	request.TraceID = ""
+	if tc := common.GetTraceContext(ctx); tc != nil {
+		request.TraceID = tc.TraceID
+	}
+
 	request.IsTLS = ctx.IsTLS()
 	if ctx.IsTLS() {
 		request.TLSDidResume = ctx.TLSConnectionState().DidResume
@@ -168,8 +175,8 @@ func (this *RequestLogging) Filter(ctx *fasthttp.RequestCtx) {
 	if ctx.LocalIP() != nil {
 		request.LocalIP = ctx.LocalIP().String()
 	}
-	if ctx.RemoteIP() != nil {
-		request.RemoteIP = ctx.RemoteIP().String()
+	if clientIP := common.GetClientIP(ctx); clientIP != nil {
+		request.RemoteIP = clientIP.String()
 	}
 
 	if ctx.RemoteAddr() != nil {
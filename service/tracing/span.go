@@ -0,0 +1,195 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"infini.sh/framework/core/util"
+	"infini.sh/gateway/common"
+)
+
+const instrumentationName = "infini.sh/gateway"
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func newTracer(provider *sdktrace.TracerProvider) *otelTracer {
+	return &otelTracer{tracer: provider.Tracer(instrumentationName)}
+}
+
+func (this *otelTracer) Start(name string, options common.SpanOptions) common.Span {
+	ctx := context.Background()
+	if parent, ok := options.Parent.(*span); ok && parent != nil {
+		ctx = parent.ctx
+	} else if options.RemoteParent != nil {
+		if sc, ok := toSpanContext(options.RemoteParent); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	if options.TraceContext != nil {
+		ctx = context.WithValue(ctx, presetIDsKey{}, options.TraceContext)
+	}
+
+	_, s := this.tracer.Start(ctx, name, trace.WithSpanKind(toSpanKind(options.Kind)))
+
+	//the nested spans are started from a fresh context, so the preset ids are only used by this span
+	v := &span{span: s, ctx: trace.ContextWithSpan(context.Background(), s)}
+	if options.TraceContext != nil {
+		//the propagated flags follow the sampling decision
+		options.TraceContext.Flags = s.SpanContext().TraceFlags().String()
+	}
+	return v
+}
+
+type span struct {
+	span trace.Span
+	ctx  context.Context
+}
+
+func (this *span) SetAttribute(key string, value interface{}) {
+	if !this.span.IsRecording() {
+		return
+	}
+	this.span.SetAttributes(toAttribute(key, value))
+}
+
+func (this *span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	this.span.RecordError(err)
+	this.span.SetStatus(codes.Error, err.Error())
+}
+
+func (this *span) TraceContext() *common.TraceContext {
+	sc := this.span.SpanContext()
+	if !sc.IsValid() {
+		return nil
+	}
+	return &common.TraceContext{
+		Version:  "00",
+		TraceID:  sc.TraceID().String(),
+		ParentID: sc.SpanID().String(),
+		Flags:    sc.TraceFlags().String(),
+	}
+}
+
+func (this *span) End() {
+	this.span.End()
+}
+
+func toSpanContext(tc *common.TraceContext) (trace.SpanContext, bool) {
+	traceID, err := trace.TraceIDFromHex(tc.TraceID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(tc.ParentID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	var flags trace.TraceFlags
+	if tc.IsSampled() {
+		flags = trace.FlagsSampled
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags, Remote: true})
+	return sc, sc.IsValid()
+}
+
+func toSpanKind(kind common.SpanKind) trace.SpanKind {
+	switch kind {
+	case common.SpanKindServer:
+		return trace.SpanKindServer
+	case common.SpanKindClient:
+		return trace.SpanKindClient
+	case common.SpanKindProducer:
+		return trace.SpanKindProducer
+	case common.SpanKindConsumer:
+		return trace.SpanKindConsumer
+	default:
+		return trace.SpanKindInternal
+	}
+}
+
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	default:
+		return attribute.String(key, util.ToString(v))
+	}
+}
+
+type presetIDsKey struct{}
+
+// idGenerator generates the random ids, except the root span of the request, which uses the ids assigned by the entry,
+// so the `traceparent` sent to the upstream and the request id match the exported span
+type idGenerator struct{}
+
+func (this *idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if tc, ok := ctx.Value(presetIDsKey{}).(*common.TraceContext); ok {
+		traceID, err1 := trace.TraceIDFromHex(tc.TraceID)
+		spanID, err2 := trace.SpanIDFromHex(tc.ParentID)
+		if err1 == nil && err2 == nil {
+			return traceID, spanID
+		}
+	}
+	var traceID trace.TraceID
+	for !traceID.IsValid() {
+		_, _ = rand.Read(traceID[:])
+	}
+	return traceID, this.NewSpanID(context.Background(), traceID)
+}
+
+func (this *idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if tc, ok := ctx.Value(presetIDsKey{}).(*common.TraceContext); ok && tc.TraceID == traceID.String() {
+		if spanID, err := trace.SpanIDFromHex(tc.ParentID); err == nil {
+			return spanID
+		}
+	}
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		_, _ = rand.Read(spanID[:])
+	}
+	return spanID
+}
@@ -0,0 +1,169 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/gateway/common"
+)
+
+type Config struct {
+	Enabled bool `config:"enabled"`
+	// Protocol is the protocol of the OTLP exporter, `http` or `grpc`
+	Protocol    string            `config:"protocol"`
+	Endpoint    string            `config:"endpoint"`
+	URLPath     string            `config:"url_path"`
+	Insecure    bool              `config:"insecure"`
+	Headers     map[string]string `config:"headers"`
+	Timeout     string            `config:"timeout"`
+	ServiceName string            `config:"service_name"`
+	// SampleRate is the ratio of the traces started by the gateway to be sampled, the sampling decision of the
+	// incoming `traceparent` is respected
+	SampleRate float64 `config:"sample_rate"`
+}
+
+type TracingModule struct {
+}
+
+func (this TracingModule) Name() string {
+	return "tracing"
+}
+
+var (
+	tracingConfig = Config{
+		Protocol:    "http",
+		ServiceName: "gateway",
+		SampleRate:  1,
+	}
+	provider *sdktrace.TracerProvider
+)
+
+func (module TracingModule) Setup() {
+	ok, err := env.ParseConfig("tracing", &tracingConfig)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+
+	if !tracingConfig.Enabled {
+		return
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", tracingConfig.ServiceName),
+		attribute.String("service.instance.id", global.Env().SystemConfig.NodeConfig.ID),
+	)
+
+	provider, err = newTracerProvider(&tracingConfig, res)
+	if err != nil {
+		panic(err)
+	}
+
+	//register in setup, so the entries and pipelines started later are traced
+	common.RegisterTracer(newTracer(provider))
+	log.Infof("tracing enabled, exporting spans to [%v] via otlp/%v", tracingConfig.Endpoint, tracingConfig.Protocol)
+}
+
+func (module TracingModule) Start() error {
+	return nil
+}
+
+func (module TracingModule) Stop() error {
+	if provider == nil {
+		return nil
+	}
+
+	common.RegisterTracer(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return provider.Shutdown(ctx)
+}
+
+func newTracerProvider(cfg *Config, res *resource.Resource) (*sdktrace.TracerProvider, error) {
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithIDGenerator(&idGenerator{}),
+	), nil
+}
+
+func newExporter(cfg *Config) (sdktrace.SpanExporter, error) {
+	timeout := util.GetDurationOrDefault(cfg.Timeout, 10*time.Second)
+
+	switch strings.ToLower(cfg.Protocol) {
+	case "grpc":
+		options := []otlptracegrpc.Option{otlptracegrpc.WithTimeout(timeout)}
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			options = append(options, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+		return otlptracegrpc.New(context.Background(), options...)
+	case "http", "":
+		options := []otlptracehttp.Option{otlptracehttp.WithTimeout(timeout)}
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.URLPath != "" {
+			options = append(options, otlptracehttp.WithURLPath(cfg.URLPath))
+		}
+		if cfg.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			options = append(options, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		return otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unsupported tracing protocol: %v", cfg.Protocol)
+	}
+}
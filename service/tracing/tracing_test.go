@@ -0,0 +1,118 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"infini.sh/gateway/common"
+)
+
+// collector is a stand-in of the OTLP/HTTP collector
+type collector struct {
+	lock     sync.Mutex
+	requests []*http.Request
+}
+
+func (this *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.lock.Lock()
+	this.requests = append(this.requests, r)
+	this.lock.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestTracer(t *testing.T, sampleRate float64) (*otelTracer, *collector, func()) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	cfg := Config{
+		Protocol:    "http",
+		Endpoint:    strings.TrimPrefix(server.URL, "http://"),
+		Insecure:    true,
+		ServiceName: "gateway",
+		SampleRate:  sampleRate,
+	}
+	p, err := newTracerProvider(&cfg, resource.Empty())
+	assert.Nil(t, err)
+	return newTracer(p), c, func() {
+		assert.Nil(t, p.Shutdown(context.Background()))
+		server.Close()
+	}
+}
+
+func TestExportSpans(t *testing.T) {
+	tracer, c, shutdown := newTestTracer(t, 1)
+
+	parent, _ := common.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tc := parent.Child()
+
+	root := tracer.Start("GET es_entry", common.SpanOptions{Kind: common.SpanKindServer, RemoteParent: parent, TraceContext: tc})
+	//the root span uses the ids assigned by the entry
+	assert.Equal(t, tc.String(), root.TraceContext().String())
+
+	filter := tracer.Start("elasticsearch", common.SpanOptions{Parent: root})
+	assert.Equal(t, tc.TraceID, filter.TraceContext().TraceID)
+	assert.NotEqual(t, tc.ParentID, filter.TraceContext().ParentID)
+	filter.SetAttribute("elasticsearch.cluster", "prod")
+	filter.SetAttribute("http.response.status_code", 200)
+	filter.End()
+	root.End()
+
+	shutdown()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	assert.True(t, len(c.requests) > 0)
+	assert.Equal(t, "/v1/traces", c.requests[0].URL.Path)
+	assert.Equal(t, "application/x-protobuf", c.requests[0].Header.Get("Content-Type"))
+}
+
+func TestSampling(t *testing.T) {
+	tracer, _, shutdown := newTestTracer(t, 0)
+	defer shutdown()
+
+	tc := common.NewTraceContext()
+	root := tracer.Start("GET es_entry", common.SpanOptions{Kind: common.SpanKindServer, TraceContext: tc})
+	assert.Equal(t, "00", root.TraceContext().Flags)
+	//the flags propagated to the upstream follow the sampling decision
+	assert.Equal(t, "00", tc.Flags)
+	root.End()
+
+	//the sampled incoming trace is respected
+	parent := common.NewTraceContext()
+	span := tracer.Start("GET es_entry", common.SpanOptions{Kind: common.SpanKindServer, RemoteParent: parent})
+	assert.Equal(t, parent.TraceID, span.TraceContext().TraceID)
+	assert.Equal(t, "01", span.TraceContext().Flags)
+	span.End()
+}